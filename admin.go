@@ -0,0 +1,117 @@
+package main
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// 管理接口：查看与取消进行中的流，运行时调整日志级别与 worker 数。
+// 不重启进程即可处理占用后端的失控生成：
+//
+//	curl -H "Authorization: Bearer $ADMIN_TOKEN" localhost:8080/admin/streams
+//	curl -X DELETE -H "Authorization: Bearer $ADMIN_TOKEN" localhost:8080/admin/streams/gen-xxx
+//	curl -X PATCH -H "Authorization: Bearer $ADMIN_TOKEN" -d '{"log_level":"debug","workers":4}' localhost:8080/admin/runtime
+
+// maxWorkers PATCH /admin/runtime 允许设置的最大 worker 数
+const maxWorkers = 1024
+
+// AdminHandler 管理接口
+type AdminHandler struct {
+	token    string
+	pipeline *StreamPipeline
+}
+
+// Register 在 mux 上注册管理路由，全部要求管理令牌
+func (h *AdminHandler) Register(mux *http.ServeMux) {
+	mux.Handle("GET /admin/streams", h.require(http.HandlerFunc(h.ListStreams)))
+	mux.Handle("DELETE /admin/streams/{id}", h.require(http.HandlerFunc(h.CancelStream)))
+	mux.Handle("GET /admin/runtime", h.require(http.HandlerFunc(h.GetRuntime)))
+	mux.Handle("PATCH /admin/runtime", h.require(http.HandlerFunc(h.PatchRuntime)))
+}
+
+// require 校验 Authorization: Bearer <token>，以常数时间比较
+func (h *AdminHandler) require(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if !ok || subtle.ConstantTimeCompare([]byte(token), []byte(h.token)) != 1 {
+			w.Header().Set("WWW-Authenticate", `Bearer realm="admin"`)
+			writeJSONError(w, http.StatusUnauthorized, "需要管理令牌")
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// ListStreams 处理 GET /admin/streams：排队中与进行中的生成，最早提交的在前
+func (h *AdminHandler) ListStreams(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, map[string]any{"streams": h.pipeline.Streams()})
+}
+
+// CancelStream 处理 DELETE /admin/streams/{id}：取消生成的 context，
+// 已连接的客户端收到 done(cancelled)，路由后端上的请求随之中断
+func (h *AdminHandler) CancelStream(w http.ResponseWriter, r *http.Request) {
+	id := r.PathValue("id")
+	if !h.pipeline.Cancel(id) {
+		writeJSONError(w, http.StatusNotFound, "stream not found")
+		return
+	}
+	metrics.AdminCancels.Inc()
+	warnf("管理接口取消了流 %s", id)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// RuntimeSettings GET /admin/runtime 与 PATCH /admin/runtime 的响应
+type RuntimeSettings struct {
+	LogLevel string `json:"log_level"`
+	Workers  int    `json:"workers"`
+}
+
+// RuntimePatch PATCH /admin/runtime 请求体，省略的字段保持不变
+type RuntimePatch struct {
+	LogLevel *string `json:"log_level"`
+	Workers  *int    `json:"workers"`
+}
+
+func (h *AdminHandler) runtime() RuntimeSettings {
+	return RuntimeSettings{LogLevel: CurrentLogLevel().String(), Workers: h.pipeline.Workers()}
+}
+
+// GetRuntime 处理 GET /admin/runtime
+func (h *AdminHandler) GetRuntime(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, h.runtime())
+}
+
+// PatchRuntime 处理 PATCH /admin/runtime：先校验全部字段再生效，返回调整后的设置
+func (h *AdminHandler) PatchRuntime(w http.ResponseWriter, r *http.Request) {
+	var req RuntimePatch
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeJSONError(w, http.StatusBadRequest, "请求体不是合法的 JSON: "+err.Error())
+		return
+	}
+	level := CurrentLogLevel()
+	if req.LogLevel != nil {
+		var err error
+		if level, err = ParseLogLevel(*req.LogLevel); err != nil {
+			writeJSON(w, http.StatusBadRequest, map[string]string{"error": err.Error(), "field": "log_level"})
+			return
+		}
+	}
+	if req.Workers != nil && (*req.Workers < 1 || *req.Workers > maxWorkers) {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": fmt.Sprintf("workers 必须在 1 到 %d 之间", maxWorkers), "field": "workers"})
+		return
+	}
+
+	old := h.runtime()
+	SetLogLevel(level)
+	if req.Workers != nil {
+		h.pipeline.SetWorkers(*req.Workers)
+	}
+	now := h.runtime()
+	if now != old {
+		infof("运行时设置已调整：日志级别 %s → %s，worker 数 %d → %d", old.LogLevel, now.LogLevel, old.Workers, now.Workers)
+	}
+	writeJSON(w, http.StatusOK, now)
+}
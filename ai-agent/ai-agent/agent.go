@@ -6,6 +6,8 @@ import (
 	"log"
 	"os"
 
+	"ai-answer-demo/tracing"
+
 	"github.com/cloudwego/eino-ext/components/model/openai"
 	"github.com/cloudwego/eino-ext/components/tool/duckduckgo"
 	"github.com/cloudwego/eino/components/tool"
@@ -13,8 +15,11 @@ import (
 	"github.com/cloudwego/eino/schema"
 )
 
-// RunAgent 启动一个完整的 Agent 示例
+// RunAgent 启动一个完整的 Agent 示例。开启追踪时整个运行为一个 span，各节点与工具调用挂在其下
 func RunAgent(ctx context.Context) {
+	ctx, span := tracing.Start(ctx, "RunAgent")
+	defer span.End()
+
 	// 初始化 Todo 工具
 	addTool := GetAddTodoTool()
 	updateTool, err := GetUpdateTodoTool()
@@ -81,7 +86,7 @@ func RunAgent(ctx context.Context) {
 			Role:    schema.User,
 			Content: "添加一个学习 Eino 的 TODO，同时搜索一下 cloudwego/eino 的仓库地址",
 		},
-	})
+	}, compose.WithCallbacks(traceHandler()))
 	if err != nil {
 		log.Fatal(err)
 	}
@@ -0,0 +1,86 @@
+package ai_agent
+
+import (
+	"context"
+
+	"ai-answer-demo/tracing"
+
+	"github.com/cloudwego/eino/callbacks"
+	"github.com/cloudwego/eino/components/model"
+	"github.com/cloudwego/eino/schema"
+)
+
+// traceHandler 为编排中的每个节点（chat_model、tools 及其中的单个工具）创建一个 span，
+// span 之间的父子关系与节点的嵌套关系一致。未开启追踪时 span 为 nil，各方法均为空操作
+func traceHandler() callbacks.Handler {
+	return callbacks.NewHandlerBuilder().
+		OnStartFn(func(ctx context.Context, info *callbacks.RunInfo, _ callbacks.CallbackInput) context.Context {
+			return startNodeSpan(ctx, info)
+		}).
+		OnStartWithStreamInputFn(func(ctx context.Context, info *callbacks.RunInfo, input *schema.StreamReader[callbacks.CallbackInput]) context.Context {
+			input.Close()
+			return startNodeSpan(ctx, info)
+		}).
+		OnEndFn(func(ctx context.Context, _ *callbacks.RunInfo, output callbacks.CallbackOutput) context.Context {
+			span := tracing.SpanFromContext(ctx)
+			setModelAttrs(span, output)
+			span.End()
+			return ctx
+		}).
+		OnEndWithStreamOutputFn(func(ctx context.Context, _ *callbacks.RunInfo, output *schema.StreamReader[callbacks.CallbackOutput]) context.Context {
+			// 流式输出读完时节点才算结束；回调收到的是副本，必须读完并关闭
+			span := tracing.SpanFromContext(ctx)
+			go func() {
+				defer output.Close()
+				for {
+					chunk, err := output.Recv()
+					if err != nil {
+						break
+					}
+					setModelAttrs(span, chunk)
+				}
+				span.End()
+			}()
+			return ctx
+		}).
+		OnErrorFn(func(ctx context.Context, _ *callbacks.RunInfo, err error) context.Context {
+			span := tracing.SpanFromContext(ctx)
+			span.RecordError(err)
+			span.End()
+			return ctx
+		}).
+		Build()
+}
+
+// startNodeSpan 开始节点的 span，名称如 "ChatModel chat_model"、"Tool duckduckgo_search"
+func startNodeSpan(ctx context.Context, info *callbacks.RunInfo) context.Context {
+	name := string(info.Component)
+	if info.Name != "" {
+		name += " " + info.Name
+	}
+	attrs := []tracing.Attr{tracing.String("eino.component", string(info.Component))}
+	if info.Type != "" {
+		attrs = append(attrs, tracing.String("eino.type", info.Type))
+	}
+	if info.Name != "" {
+		attrs = append(attrs, tracing.String("eino.name", info.Name))
+	}
+	ctx, _ = tracing.Start(ctx, name, attrs...)
+	return ctx
+}
+
+// setModelAttrs 记录 ChatModel 返回的模型名与 token 用量，其他组件的输出忽略
+func setModelAttrs(span *tracing.Span, output callbacks.CallbackOutput) {
+	out := model.ConvCallbackOutput(output)
+	if out == nil {
+		return
+	}
+	if out.Config != nil && out.Config.Model != "" {
+		span.SetAttrs(tracing.String("gen_ai.request.model", out.Config.Model))
+	}
+	if out.TokenUsage != nil {
+		span.SetAttrs(
+			tracing.Int("gen_ai.usage.input_tokens", out.TokenUsage.PromptTokens),
+			tracing.Int("gen_ai.usage.output_tokens", out.TokenUsage.CompletionTokens))
+	}
+}
@@ -3,9 +3,12 @@ package main
 import (
 	"context"
 	"fmt"
+	"log"
 	"os"
+	"time"
 
 	aiagent "ai-answer-demo/ai-agent/ai-agent"
+	"ai-answer-demo/tracing"
 )
 
 // usage 显示用法说明
@@ -15,6 +18,28 @@ func usage() {
 	fmt.Println("  run-agent       启动完整的 Agent 示例")
 	fmt.Println("  run-encourager  启动程序员鼓励师示例")
 	fmt.Println("  help            显示帮助信息")
+	fmt.Println("环境变量 TRACE_EXPORT 设置为文件路径或 OTLP/HTTP 地址时导出链路追踪")
+}
+
+// setupTracing 按 TRACE_EXPORT 开启追踪，返回退出前导出剩余 span 的函数
+func setupTracing() func() {
+	target := os.Getenv("TRACE_EXPORT")
+	if target == "" {
+		return func() {}
+	}
+	exporter, err := tracing.NewExporter(target)
+	if err != nil {
+		log.Fatalf("创建 trace 导出失败: %v", err)
+	}
+	tracer := tracing.NewTracer("agent-cli", 1, exporter)
+	tracing.SetDefault(tracer)
+	return func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+		if err := tracer.Shutdown(ctx); err != nil {
+			log.Printf("导出剩余 trace 失败: %v", err)
+		}
+	}
 }
 
 func main() {
@@ -26,6 +51,8 @@ func main() {
 	}
 
 	ctx := context.Background()
+	shutdown := setupTracing()
+	defer shutdown()
 
 	switch os.Args[1] {
 	case "run-agent":
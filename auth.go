@@ -0,0 +1,379 @@
+package main
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"os/signal"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// 认证与限流失败时返回给客户端的原因码
+const (
+	AuthMissingCredentials = "missing_credentials"
+	AuthInvalidKey         = "invalid_key"
+	AuthInvalidSignature   = "invalid_signature"
+	AuthTokenExpired       = "token_expired"
+	AuthKeyDisabled        = "key_disabled"
+	AuthRateLimited        = "rate_limited"
+	AuthQuotaExceeded      = "quota_exceeded"
+)
+
+// APIKey 密钥文件中的一项。Key 用于 Bearer 认证，HMACSecret 用于签发短期令牌，二者至少配置一个
+type APIKey struct {
+	ID         string `json:"id"`
+	Key        string `json:"key,omitempty"`
+	HMACSecret string `json:"hmac_secret,omitempty"`
+	Disabled   bool   `json:"disabled,omitempty"`
+	// 每分钟最多请求数，0 表示不限制
+	RequestsPerMinute int `json:"requests_per_minute"`
+	// 每日（UTC）最多输出 token 数，0 表示不限制
+	DailyTokens int64 `json:"daily_tokens"`
+	// 排队优先级，同时是请求通过 X-Priority 可选的最高优先级，为空时为 standard
+	Priority PriorityClass `json:"priority,omitempty"`
+}
+
+// keysFile 密钥文件格式
+type keysFile struct {
+	Keys []APIKey `json:"keys"`
+}
+
+// keyUsage 单个密钥的限流与用量状态，按密钥 ID 保存，重新加载密钥文件后保留
+type keyUsage struct {
+	mu sync.Mutex
+
+	// 令牌桶：容量与每分钟请求数相同，按时间匀速补充
+	tokens   float64
+	capacity float64
+	filled   time.Time
+
+	day  string // 用量所属的 UTC 日期
+	used int64
+}
+
+// allowRequest 消耗一个请求令牌，不足时返回需要等待的时间
+func (u *keyUsage) allowRequest(perMinute int, now time.Time) (bool, time.Duration) {
+	if perMinute <= 0 {
+		return true, 0
+	}
+	u.mu.Lock()
+	defer u.mu.Unlock()
+
+	capacity := float64(perMinute)
+	rate := capacity / 60 // 每秒补充的令牌数
+	if u.filled.IsZero() {
+		u.tokens = capacity
+	} else {
+		// 重新加载后限额变化时，按差值调整桶内令牌，避免调高限额后仍被旧状态限流
+		u.tokens += capacity - u.capacity
+		u.tokens = min(capacity, u.tokens+now.Sub(u.filled).Seconds()*rate)
+	}
+	u.capacity = capacity
+	u.filled = now
+	if u.tokens >= 1 {
+		u.tokens--
+		return true, 0
+	}
+	return false, time.Duration((1 - u.tokens) / rate * float64(time.Second))
+}
+
+// remaining 返回当日剩余的 token 额度，limit 为 0 时不限制
+func (u *keyUsage) remaining(limit int64, now time.Time) int64 {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	u.rollover(now)
+	return limit - u.used
+}
+
+// consume 计入已流式输出的 token
+func (u *keyUsage) consume(n int64) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	u.rollover(time.Now())
+	u.used += n
+}
+
+func (u *keyUsage) rollover(now time.Time) {
+	day := now.UTC().Format(time.DateOnly)
+	if u.day != day {
+		u.day = day
+		u.used = 0
+	}
+}
+
+// AuthError 认证或限流失败
+type AuthError struct {
+	Status     int
+	Reason     string
+	Message    string
+	RetryAfter time.Duration
+}
+
+func (e *AuthError) Error() string {
+	return e.Reason + ": " + e.Message
+}
+
+// Authenticator 从密钥文件加载 API Key，校验请求凭证并执行按密钥的请求限流与每日 token 配额。
+// 收到 SIGHUP 时重新加载密钥文件，加载失败时保留原有密钥
+type Authenticator struct {
+	path string
+
+	mu     sync.RWMutex
+	byKey  map[string]*APIKey // 按密钥的 sha256 索引，避免直接以明文比较
+	byID   map[string]*APIKey
+	usages map[string]*keyUsage
+}
+
+// NewAuthenticator 加载密钥文件，path 为空时返回 nil，表示不启用认证
+func NewAuthenticator(path string) (*Authenticator, error) {
+	if path == "" {
+		return nil, nil
+	}
+	a := &Authenticator{path: path, usages: make(map[string]*keyUsage)}
+	if err := a.Reload(); err != nil {
+		return nil, err
+	}
+	return a, nil
+}
+
+// Reload 重新读取密钥文件
+func (a *Authenticator) Reload() error {
+	data, err := os.ReadFile(a.path)
+	if err != nil {
+		return err
+	}
+	var f keysFile
+	if err := json.Unmarshal(data, &f); err != nil {
+		return fmt.Errorf("解析密钥文件失败: %w", err)
+	}
+
+	byKey := make(map[string]*APIKey)
+	byID := make(map[string]*APIKey)
+	for i := range f.Keys {
+		k := &f.Keys[i]
+		if k.ID == "" || strings.Contains(k.ID, ".") {
+			return fmt.Errorf("密钥 #%d: id 不能为空且不能包含 '.'", i)
+		}
+		if k.Key == "" && k.HMACSecret == "" {
+			return fmt.Errorf("密钥 %s: key 与 hmac_secret 至少配置一个", k.ID)
+		}
+		if _, dup := byID[k.ID]; dup {
+			return fmt.Errorf("密钥 %s: id 重复", k.ID)
+		}
+		if k.Priority != "" {
+			if _, err := ParsePriorityClass(string(k.Priority)); err != nil {
+				return fmt.Errorf("密钥 %s: priority 取值为 interactive、standard 或 batch", k.ID)
+			}
+		}
+		byID[k.ID] = k
+		if k.Key != "" {
+			byKey[hashKey(k.Key)] = k
+		}
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.byKey, a.byID = byKey, byID
+	return nil
+}
+
+// WatchReload 收到 SIGHUP 时重新加载密钥文件
+func (a *Authenticator) WatchReload() {
+	sig := make(chan os.Signal, 1)
+	signal.Notify(sig, syscall.SIGHUP)
+	for range sig {
+		if err := a.Reload(); err != nil {
+			warnf("重新加载密钥文件失败，继续使用原有密钥: %v", err)
+			continue
+		}
+		infof("已重新加载密钥文件 %s", a.path)
+	}
+}
+
+func hashKey(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:])
+}
+
+// SignToken 签发 HMAC 令牌，格式为 <id>.<过期时间 unix 秒>.<hex(HMAC-SHA256(secret, "<id>.<过期时间>"))>
+func SignToken(id, secret string, expires time.Time) string {
+	payload := id + "." + strconv.FormatInt(expires.Unix(), 10)
+	return payload + "." + tokenSignature(secret, payload)
+}
+
+func tokenSignature(secret, payload string) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(payload))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// credential 从请求中取出凭证：优先 Authorization: Bearer，
+// 浏览器 EventSource 无法设置请求头时可使用 access_token 查询参数
+func credential(r *http.Request) string {
+	if h := r.Header.Get("Authorization"); h != "" {
+		scheme, token, ok := strings.Cut(h, " ")
+		if ok && strings.EqualFold(scheme, "Bearer") {
+			return strings.TrimSpace(token)
+		}
+		return ""
+	}
+	return r.URL.Query().Get("access_token")
+}
+
+// Authenticate 校验凭证，返回对应的密钥
+func (a *Authenticator) Authenticate(r *http.Request) (*APIKey, error) {
+	cred := credential(r)
+	if cred == "" {
+		return nil, &AuthError{Status: http.StatusUnauthorized, Reason: AuthMissingCredentials, Message: "缺少 API Key，请通过 Authorization: Bearer 提供"}
+	}
+
+	a.mu.RLock()
+	key, ok := a.byKey[hashKey(cred)]
+	a.mu.RUnlock()
+	if !ok {
+		var err error
+		if key, err = a.verifyToken(cred, time.Now()); err != nil {
+			return nil, err
+		}
+	}
+	if key.Disabled {
+		return nil, &AuthError{Status: http.StatusUnauthorized, Reason: AuthKeyDisabled, Message: "API Key 已停用"}
+	}
+	return key, nil
+}
+
+// verifyToken 校验 HMAC 令牌
+func (a *Authenticator) verifyToken(token string, now time.Time) (*APIKey, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, &AuthError{Status: http.StatusUnauthorized, Reason: AuthInvalidKey, Message: "API Key 无效"}
+	}
+	a.mu.RLock()
+	key, ok := a.byID[parts[0]]
+	a.mu.RUnlock()
+	if !ok || key.HMACSecret == "" {
+		return nil, &AuthError{Status: http.StatusUnauthorized, Reason: AuthInvalidKey, Message: "API Key 无效"}
+	}
+	expected := tokenSignature(key.HMACSecret, parts[0]+"."+parts[1])
+	if !hmac.Equal([]byte(expected), []byte(parts[2])) {
+		return nil, &AuthError{Status: http.StatusUnauthorized, Reason: AuthInvalidSignature, Message: "令牌签名不正确"}
+	}
+	expires, err := strconv.ParseInt(parts[1], 10, 64)
+	if err != nil || now.Unix() >= expires {
+		return nil, &AuthError{Status: http.StatusUnauthorized, Reason: AuthTokenExpired, Message: "令牌已过期"}
+	}
+	return key, nil
+}
+
+func (a *Authenticator) usage(id string) *keyUsage {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	u, ok := a.usages[id]
+	if !ok {
+		u = &keyUsage{}
+		a.usages[id] = u
+	}
+	return u
+}
+
+// Lookup 按 ID 返回密钥及其用量，用于重启后恢复的任务重新计入额度；密钥已被删除时 ok 为 false
+func (a *Authenticator) Lookup(id string) (*APIKey, *keyUsage, bool) {
+	a.mu.RLock()
+	key, ok := a.byID[id]
+	a.mu.RUnlock()
+	if !ok {
+		return nil, nil, false
+	}
+	return key, a.usage(id), true
+}
+
+// Admit 执行请求限流与每日 token 配额检查
+func (a *Authenticator) Admit(key *APIKey) (*keyUsage, error) {
+	now := time.Now()
+	u := a.usage(key.ID)
+	if ok, wait := u.allowRequest(key.RequestsPerMinute, now); !ok {
+		return nil, &AuthError{Status: http.StatusTooManyRequests, Reason: AuthRateLimited, Message: fmt.Sprintf("请求过于频繁，每分钟最多 %d 次", key.RequestsPerMinute), RetryAfter: wait}
+	}
+	if key.DailyTokens > 0 && u.remaining(key.DailyTokens, now) <= 0 {
+		tomorrow := now.UTC().Truncate(24 * time.Hour).Add(24 * time.Hour)
+		return nil, &AuthError{Status: http.StatusTooManyRequests, Reason: AuthQuotaExceeded, Message: fmt.Sprintf("今日 token 额度 %d 已用尽", key.DailyTokens), RetryAfter: tomorrow.Sub(now)}
+	}
+	return u, nil
+}
+
+// Require 返回要求认证的 handler，a 为 nil（未配置密钥文件）时原样返回 h
+func (a *Authenticator) Require(h http.Handler) http.Handler {
+	if a == nil {
+		return h
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		key, err := a.Authenticate(r)
+		var u *keyUsage
+		if err == nil {
+			u, err = a.Admit(key)
+		}
+		if err != nil {
+			writeAuthError(w, err.(*AuthError))
+			return
+		}
+		ctx := context.WithValue(r.Context(), apiKeyContextKey{}, &authInfo{key: key, usage: u})
+		h.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+func writeAuthError(w http.ResponseWriter, err *AuthError) {
+	if err.Status == http.StatusUnauthorized {
+		w.Header().Set("WWW-Authenticate", `Bearer realm="stream"`)
+	}
+	if err.RetryAfter > 0 {
+		setRetryAfter(w, err.RetryAfter)
+	}
+	writeJSON(w, err.Status, map[string]string{"error": err.Message, "reason": err.Reason})
+}
+
+type apiKeyContextKey struct{}
+
+type authInfo struct {
+	key   *APIKey
+	usage *keyUsage
+}
+
+// APIKeyFromContext 返回请求通过认证的密钥，未启用认证时返回 nil
+func APIKeyFromContext(ctx context.Context) *APIKey {
+	if info, ok := ctx.Value(apiKeyContextKey{}).(*authInfo); ok {
+		return info.key
+	}
+	return nil
+}
+
+// quotaBudget 返回密钥当日剩余的 token 额度，作为 GenerateOptions.Budget；
+// 未启用认证或不限额度时返回 0。额度已用尽的请求在准入时已被拒绝，这里至少返回 1
+func quotaBudget(key *APIKey, usage *keyUsage) int {
+	if key == nil || usage == nil || key.DailyTokens <= 0 {
+		return 0
+	}
+	return int(max(usage.remaining(key.DailyTokens, time.Now()), 1))
+}
+
+// applyQuota 按请求所属密钥的剩余额度设置 opts.Budget。
+// 准入只检查额度是否已用尽，单个请求仍可能超出剩余额度，需要在生成时截断
+func applyQuota(ctx context.Context, opts *GenerateOptions) {
+	opts.Budget = quotaBudget(APIKeyFromContext(ctx), usageFromContext(ctx))
+}
+
+// usageFromContext 返回请求所属密钥的用量，未启用认证时返回 nil
+func usageFromContext(ctx context.Context) *keyUsage {
+	if info, ok := ctx.Value(apiKeyContextKey{}).(*authInfo); ok {
+		return info.usage
+	}
+	return nil
+}
@@ -0,0 +1,194 @@
+package main
+
+import (
+	"errors"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// newTestAuthenticator 以给定内容的密钥文件创建 Authenticator
+func newTestAuthenticator(t *testing.T, keys string) *Authenticator {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "keys.json")
+	if err := os.WriteFile(path, []byte(keys), 0600); err != nil {
+		t.Fatal(err)
+	}
+	a, err := NewAuthenticator(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return a
+}
+
+// authReason 返回 err 的原因码，err 为 nil 时返回空
+func authReason(err error) string {
+	var ae *AuthError
+	if errors.As(err, &ae) {
+		return ae.Reason
+	}
+	if err != nil {
+		return err.Error()
+	}
+	return ""
+}
+
+func TestAuthenticate(t *testing.T) {
+	a := newTestAuthenticator(t, `{"keys": [
+		{"id": "a", "key": "sk-a", "hmac_secret": "sa"},
+		{"id": "b", "key": "sk-b"},
+		{"id": "off", "key": "sk-off", "disabled": true}
+	]}`)
+	now := time.Now()
+	valid := SignToken("a", "sa", now.Add(time.Minute))
+	for _, tc := range []struct {
+		name, cred, reason string
+	}{
+		{"密钥", "sk-a", ""},
+		{"令牌", valid, ""},
+		{"缺少凭证", "", AuthMissingCredentials},
+		{"未知密钥", "sk-x", AuthInvalidKey},
+		{"已停用", "sk-off", AuthKeyDisabled},
+		{"签名错误", SignToken("a", "wrong", now.Add(time.Minute)), AuthInvalidSignature},
+		{"篡改过期时间", "a.9999999999." + valid[len(valid)-64:], AuthInvalidSignature},
+		{"已过期", SignToken("a", "sa", now.Add(-time.Second)), AuthTokenExpired},
+		{"未配置 hmac_secret", SignToken("b", "", now.Add(time.Minute)), AuthInvalidKey},
+		{"未知 ID", SignToken("x", "sa", now.Add(time.Minute)), AuthInvalidKey},
+		{"格式错误", "a.b", AuthInvalidKey},
+	} {
+		r := httptest.NewRequest("GET", "/stream", nil)
+		if tc.cred != "" {
+			r.Header.Set("Authorization", "Bearer "+tc.cred)
+		}
+		key, err := a.Authenticate(r)
+		if got := authReason(err); got != tc.reason {
+			t.Errorf("%s: 原因 %q，期望 %q", tc.name, got, tc.reason)
+		}
+		if err == nil && key.ID != "a" {
+			t.Errorf("%s: 密钥 %s，期望 a", tc.name, key.ID)
+		}
+	}
+
+	r := httptest.NewRequest("GET", "/stream?access_token="+valid, nil)
+	if _, err := a.Authenticate(r); err != nil {
+		t.Errorf("access_token 查询参数: %v", err)
+	}
+}
+
+func TestAllowRequest(t *testing.T) {
+	var u keyUsage
+	now := time.Now()
+	for i := 0; i < 2; i++ {
+		if ok, _ := u.allowRequest(2, now); !ok {
+			t.Fatalf("第 %d 个请求被限流", i+1)
+		}
+	}
+	ok, wait := u.allowRequest(2, now)
+	if ok || wait < 29*time.Second || wait > 30*time.Second {
+		t.Fatalf("桶空时 allowRequest = %v, %s，期望等待 30s", ok, wait)
+	}
+	if ok, _ := u.allowRequest(2, now.Add(30*time.Second)); !ok {
+		t.Error("30s 后应补充一个令牌")
+	}
+	if ok, _ := u.allowRequest(2, now.Add(30*time.Second)); ok {
+		t.Error("补充的令牌只有一个")
+	}
+	// 调高限额后按差值补充令牌，不受旧状态限制
+	if ok, _ := u.allowRequest(4, now.Add(30*time.Second)); !ok {
+		t.Error("限额从 2 调到 4 后应立即可用")
+	}
+	if ok, _ := u.allowRequest(0, now); !ok {
+		t.Error("perMinute 为 0 时不限制")
+	}
+}
+
+func TestQuotaRollover(t *testing.T) {
+	var u keyUsage
+	now := time.Now()
+	u.consume(30)
+	if got := u.remaining(100, now); got != 70 {
+		t.Fatalf("remaining = %d，期望 70", got)
+	}
+	u.consume(80)
+	if got := u.remaining(100, now); got != -10 {
+		t.Fatalf("超出额度后 remaining = %d，期望 -10", got)
+	}
+	if got := u.remaining(100, now.Add(24*time.Hour)); got != 100 {
+		t.Errorf("次日（UTC）remaining = %d，期望重置为 100", got)
+	}
+}
+
+func TestQuotaBudget(t *testing.T) {
+	key := &APIKey{ID: "a", DailyTokens: 100}
+	var u keyUsage
+	if got := quotaBudget(nil, nil); got != 0 {
+		t.Errorf("未启用认证时 quotaBudget = %d，期望 0", got)
+	}
+	if got := quotaBudget(&APIKey{ID: "a"}, &u); got != 0 {
+		t.Errorf("不限额度时 quotaBudget = %d，期望 0", got)
+	}
+	u.consume(60)
+	if got := quotaBudget(key, &u); got != 40 {
+		t.Errorf("quotaBudget = %d，期望剩余额度 40", got)
+	}
+	u.consume(60)
+	if got := quotaBudget(key, &u); got != 1 {
+		t.Errorf("额度用尽后 quotaBudget = %d，期望 1", got)
+	}
+
+	opts := &GenerateOptions{MaxTokens: 50, Budget: 40}
+	if got := opts.tokenLimit(); got != 40 {
+		t.Errorf("tokenLimit = %d，期望取 max_tokens 与额度中较小的 40", got)
+	}
+	opts.MaxTokens = 0
+	if got := opts.tokenLimit(); got != 40 {
+		t.Errorf("max_tokens 为默认值时 tokenLimit = %d，期望 40", got)
+	}
+}
+
+func TestAdmitAndReload(t *testing.T) {
+	a := newTestAuthenticator(t, `{"keys": [{"id": "a", "key": "sk-a", "requests_per_minute": 1, "daily_tokens": 10}]}`)
+	key, _, _ := a.Lookup("a")
+	u, err := a.Admit(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := a.Admit(key); authReason(err) != AuthRateLimited {
+		t.Errorf("超过每分钟请求数: %v，期望 %s", err, AuthRateLimited)
+	}
+
+	// 加载失败时保留原有密钥
+	if err := os.WriteFile(a.path, []byte(`{"keys": [{"id": ""}]}`), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := a.Reload(); err == nil {
+		t.Fatal("非法的密钥文件应加载失败")
+	}
+	if _, _, ok := a.Lookup("a"); !ok {
+		t.Fatal("加载失败后原有密钥应保留")
+	}
+
+	// 重新加载后用量按 ID 保留，额度用尽时拒绝
+	u.consume(10)
+	if err := os.WriteFile(a.path, []byte(`{"keys": [{"id": "a", "key": "sk-new", "daily_tokens": 10}]}`), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := a.Reload(); err != nil {
+		t.Fatal(err)
+	}
+	r := httptest.NewRequest("GET", "/", nil)
+	r.Header.Set("Authorization", "Bearer sk-a")
+	if _, err := a.Authenticate(r); authReason(err) != AuthInvalidKey {
+		t.Errorf("旧密钥: %v，期望 %s", err, AuthInvalidKey)
+	}
+	r.Header.Set("Authorization", "Bearer sk-new")
+	key, err = a.Authenticate(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := a.Admit(key); authReason(err) != AuthQuotaExceeded {
+		t.Errorf("额度用尽: %v，期望 %s", err, AuthQuotaExceeded)
+	}
+}
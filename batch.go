@@ -0,0 +1,129 @@
+package main
+
+import (
+	"errors"
+	"time"
+
+	"github.com/cloudwego/eino/schema"
+)
+
+// BatchPolicy 共享输出（见 StreamPipeline.Responses）的攒批策略：token 数、字节数、
+// 等待时间任一达到上限即下发。各项为 0 表示不限制，全部为 0 时逐 token 下发。
+// 自带 Output 的请求（HTTP、WebSocket 与任务）不受影响，始终逐 token 下发，由传输层合并写出
+type BatchPolicy struct {
+	MaxTokens  int `json:"max_tokens"`
+	MaxBytes   int `json:"max_bytes"`
+	MaxDelayMs int `json:"max_delay_ms"` // 从批次中第一个 token 开始计时
+}
+
+// interactiveBatch 请求自带 Output 时的策略：交互式请求逐 token 下发，合并写出由传输层负责
+var interactiveBatch = BatchPolicy{MaxTokens: 1}
+
+func (p BatchPolicy) maxDelay() time.Duration {
+	return time.Duration(p.MaxDelayMs) * time.Millisecond
+}
+
+// batcher 按策略把一个请求的分片组装成有序的 StreamResponse
+type batcher struct {
+	id     string
+	policy BatchPolicy
+	out    chan<- *StreamResponse
+
+	seq      int
+	pending  *StreamResponse
+	bytes    int
+	deadline <-chan time.Time // 当前批次按 MaxDelayMs 下发的时限，任何一次下发后清空
+	usage    *schema.TokenUsage
+}
+
+func newBatcher(id string, policy BatchPolicy, out chan<- *StreamResponse) *batcher {
+	return &batcher{id: id, policy: policy, out: out}
+}
+
+// addToken 加入一段代表 n 个上游 token 的文本，批次已满时立即下发，否则在批次的第一个 token 处开始 MaxDelayMs 计时
+func (b *batcher) addToken(token string, n int) {
+	res := b.current()
+	res.Tokens = append(res.Tokens, token)
+	res.TokenCounts = append(res.TokenCounts, n)
+	b.bytes += len(token)
+	p := b.policy
+	unlimited := p.MaxTokens <= 0 && p.MaxBytes <= 0 && p.MaxDelayMs <= 0
+	if unlimited || (p.MaxTokens > 0 && len(res.Tokens) >= p.MaxTokens) || (p.MaxBytes > 0 && b.bytes >= p.MaxBytes) {
+		b.flush()
+		return
+	}
+	if b.deadline == nil && p.MaxDelayMs > 0 {
+		b.deadline = time.After(p.maxDelay())
+	}
+}
+
+// Deadline 返回当前批次到期的 channel，没有等待中的批次时为 nil
+func (b *batcher) Deadline() <-chan time.Time {
+	return b.deadline
+}
+
+// addToolCalls 加入工具调用。工具调用与前面的 token 一起立即下发，
+// 保证其后的 token 不会被排到工具调用之前
+func (b *batcher) addToolCalls(calls []schema.ToolCall) {
+	res := b.current()
+	res.ToolCalls = append(res.ToolCalls, calls...)
+	b.flush()
+}
+
+// addFailover 加入后端切换。先下发已攒的 token，切换随后续 token 所在的批次下发
+func (b *batcher) addFailover(f Failover) {
+	if b.pending != nil && len(b.pending.Tokens) > 0 {
+		b.flush()
+	}
+	res := b.current()
+	res.Failovers = append(res.Failovers, f)
+}
+
+// addModerations 加入内容处置。先下发已攒的 token，处置随后续 token 所在的批次下发，
+// 保证 moderation 事件紧挨在被隐去的内容之前
+func (b *batcher) addModerations(mods []Moderation) {
+	if b.pending != nil && len(b.pending.Tokens) > 0 {
+		b.flush()
+	}
+	res := b.current()
+	res.Moderations = append(res.Moderations, mods...)
+}
+
+// flush 下发当前批次，没有内容时不下发
+func (b *batcher) flush() {
+	if b.pending == nil {
+		return
+	}
+	b.send(b.pending)
+}
+
+// finish 下发最后一个响应：剩余 token、用量、错误与结束原因，Final 为 true
+func (b *batcher) finish(outcome DoneReason, err error) {
+	res := b.current()
+	res.Final = true
+	res.FinishReason = outcome
+	res.Usage = b.usage
+	if err != nil {
+		res.Err = err
+		res.ErrCode = ErrCodeGeneration
+		var panicErr *PanicError
+		if errors.As(err, &panicErr) {
+			res.ErrCode = ErrCodeInternal
+		}
+	}
+	b.send(res)
+}
+
+func (b *batcher) current() *StreamResponse {
+	if b.pending == nil {
+		b.pending = &StreamResponse{ID: b.id}
+	}
+	return b.pending
+}
+
+func (b *batcher) send(res *StreamResponse) {
+	res.Seq = b.seq
+	b.seq++
+	b.pending, b.bytes, b.deadline = nil, 0, nil
+	b.out <- res
+}
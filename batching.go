@@ -0,0 +1,273 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/cloudwego/eino/schema"
+)
+
+// 本文件实现连续批处理（continuous batching）的模拟：后端每一步为批内每个序列各生成一个 token，
+// 序列可以在两步之间加入或离开批次。ContinuousBatcher 实现 ModelGenerator，
+// StreamPipeline 的 worker 照常调用 Stream，调度器把各 worker 的请求合并成步批次
+
+// BatchedGenerator 按步生成的后端
+type BatchedGenerator interface {
+	// Step 执行一步前向计算，为 seqs 中的每个序列生成下一个 token，返回与 seqs 等长、顺序一致的结果。
+	// 同一时刻只会有一个 Step 在执行
+	Step(ctx context.Context, seqs []*Sequence) []StepOutput
+}
+
+// Sequence 批次中的一个序列
+type Sequence struct {
+	ID       string
+	Messages []*schema.Message
+	Options  *GenerateOptions
+	// 已生成的 token 数，由调度器在每步之后更新
+	Generated int
+	// 后端私有状态（如 KV cache 句柄），调度器不读取
+	State any
+}
+
+// StepOutput 一个序列在一步中的输出
+type StepOutput struct {
+	Token string
+	Done  bool  // 序列已生成完毕，调度器随后将其移出批次
+	Err   error // 序列失败，调度器下发错误后将其移出批次
+}
+
+func (c BatchingConfig) step() time.Duration {
+	return time.Duration(c.StepMs * float64(time.Millisecond))
+}
+
+func (c BatchingConfig) perSequence() time.Duration {
+	return time.Duration(c.PerSequenceMs * float64(time.Millisecond))
+}
+
+// batchSeq 调度器中的一个序列及其下发 channel
+type batchSeq struct {
+	seq *Sequence
+	ctx context.Context
+	out chan Chunk
+}
+
+// ContinuousBatcher 连续批处理调度器
+type ContinuousBatcher struct {
+	gen        BatchedGenerator
+	maxBatch   int
+	bufferSize int
+
+	joins  chan *batchSeq
+	ctx    context.Context
+	cancel context.CancelFunc
+	done   chan struct{}
+}
+
+func NewContinuousBatcher(gen BatchedGenerator, maxBatch, bufferSize int) *ContinuousBatcher {
+	if maxBatch < 1 {
+		maxBatch = 1
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	c := &ContinuousBatcher{
+		gen:        gen,
+		maxBatch:   maxBatch,
+		bufferSize: bufferSize,
+		joins:      make(chan *batchSeq),
+		ctx:        ctx,
+		cancel:     cancel,
+		done:       make(chan struct{}),
+	}
+	go c.run()
+	return c
+}
+
+// Stream 将请求作为一个序列加入批次。批次已满时等待有序列离开
+func (c *ContinuousBatcher) Stream(ctx context.Context, messages []*schema.Message, opts *GenerateOptions) <-chan Chunk {
+	s := &batchSeq{
+		seq: &Sequence{ID: newID("seq-"), Messages: messages, Options: opts},
+		ctx: ctx,
+		out: make(chan Chunk, c.bufferSize),
+	}
+	go func() {
+		select {
+		case c.joins <- s:
+		case <-ctx.Done():
+			close(s.out)
+		case <-c.ctx.Done():
+			close(s.out)
+		}
+	}()
+	return s.out
+}
+
+// Close 停止调度，进行中的序列随之结束
+func (c *ContinuousBatcher) Close() {
+	c.cancel()
+	<-c.done
+}
+
+// run 调度循环：在两步之间接纳新序列、移除已取消的序列，然后执行一步
+func (c *ContinuousBatcher) run() {
+	defer close(c.done)
+	var active []*batchSeq
+	defer func() {
+		for _, s := range active {
+			close(s.out)
+		}
+	}()
+
+	for {
+		// 批次为空时阻塞等待第一个序列，避免空转
+		if len(active) == 0 {
+			select {
+			case s := <-c.joins:
+				active = append(active, s)
+			case <-c.ctx.Done():
+				return
+			}
+		}
+	admit:
+		for len(active) < c.maxBatch {
+			select {
+			case s := <-c.joins:
+				active = append(active, s)
+			default:
+				break admit
+			}
+		}
+		// 客户端取消或达到 max_tokens、停止序列时 ctx 被取消，序列在这里离开批次
+		active = slices.DeleteFunc(active, func(s *batchSeq) bool {
+			if s.ctx.Err() != nil {
+				close(s.out)
+				return true
+			}
+			return false
+		})
+		if len(active) == 0 {
+			continue
+		}
+
+		seqs := make([]*Sequence, len(active))
+		for i, s := range active {
+			seqs[i] = s.seq
+		}
+		outputs := c.gen.Step(c.ctx, seqs)
+		if c.ctx.Err() != nil {
+			return
+		}
+		metrics.BatchSteps.Inc()
+		metrics.BatchSize.Observe(float64(len(seqs)))
+
+		kept := active[:0]
+		for i, s := range active {
+			if c.deliver(s, outputs[i]) {
+				kept = append(kept, s)
+			}
+		}
+		clear(active[len(kept):])
+		active = kept
+	}
+}
+
+// deliver 下发一个序列在本步的输出，返回序列是否继续留在批次中。
+// 下发 channel 已满时整个批次等待，与真实后端一致：下一步必须拿到所有序列的上一个 token
+func (c *ContinuousBatcher) deliver(s *batchSeq, o StepOutput) bool {
+	s.seq.Generated++
+	send := func(chunk Chunk) bool {
+		select {
+		case s.out <- chunk:
+			return true
+		case <-s.ctx.Done():
+			return false
+		}
+	}
+	if o.Token != "" && !send(Chunk{Content: o.Token}) {
+		close(s.out)
+		return false
+	}
+	if o.Err != nil {
+		send(Chunk{Err: o.Err})
+		close(s.out)
+		return false
+	}
+	if o.Done {
+		close(s.out)
+		return false
+	}
+	return true
+}
+
+// MockBatchedGenerator 离线模拟的批处理后端。模拟单个加速器：同一时刻只执行一次前向计算，
+// 一步耗时 step + perSeq × 批大小，固定开销被批内序列分摊。
+// 它同时实现 ModelGenerator，以逐请求的方式（每次前向只计算一个序列）运行，作为对比基线
+type MockBatchedGenerator struct {
+	step       time.Duration
+	perSeq     time.Duration
+	bufferSize int
+
+	device sync.Mutex
+}
+
+func NewMockBatchedGenerator(step, perSeq time.Duration, bufferSize int) *MockBatchedGenerator {
+	return &MockBatchedGenerator{step: step, perSeq: perSeq, bufferSize: bufferSize}
+}
+
+// forward 占用设备执行一次前向计算
+func (m *MockBatchedGenerator) forward(ctx context.Context, n int) error {
+	m.device.Lock()
+	defer m.device.Unlock()
+	t := time.NewTimer(m.step + time.Duration(n)*m.perSeq)
+	defer t.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-t.C:
+		return nil
+	}
+}
+
+// mockLength 与 MockGenerator 一致：默认 50 个 token，设置 max_tokens 时以其为准
+func mockLength(opts *GenerateOptions) int {
+	if opts.MaxTokens > 0 {
+		return opts.MaxTokens
+	}
+	return 50
+}
+
+func (m *MockBatchedGenerator) Step(ctx context.Context, seqs []*Sequence) []StepOutput {
+	outputs := make([]StepOutput, len(seqs))
+	if err := m.forward(ctx, len(seqs)); err != nil {
+		for i := range outputs {
+			outputs[i].Err = err
+		}
+		return outputs
+	}
+	for i, seq := range seqs {
+		outputs[i] = StepOutput{
+			Token: fmt.Sprintf("token-%d", seq.Generated),
+			Done:  seq.Generated+1 >= mockLength(seq.Options),
+		}
+	}
+	return outputs
+}
+
+func (m *MockBatchedGenerator) Stream(ctx context.Context, messages []*schema.Message, opts *GenerateOptions) <-chan Chunk {
+	out := make(chan Chunk, m.bufferSize)
+	go func() {
+		defer close(out)
+		for i := 0; i < mockLength(opts); i++ {
+			if m.forward(ctx, 1) != nil {
+				return
+			}
+			select {
+			case <-ctx.Done():
+				return
+			case out <- Chunk{Content: fmt.Sprintf("token-%d", i)}:
+			}
+		}
+	}()
+	return out
+}
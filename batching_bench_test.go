@@ -0,0 +1,158 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"sync"
+	"testing"
+	"time"
+)
+
+// 在同一个模拟加速器上，对比逐请求调用 ModelGenerator.Stream（每次前向只计算一个序列）
+// 与连续批处理的吞吐、首 token 延迟与 token 间延迟。请求经由完整的 StreamPipeline 提交，与线上路径一致：
+//
+//	go test -run '^$' -bench Batching
+
+const (
+	batchBenchRequests = 16
+	batchBenchTokens   = 16
+	// 模拟后端：一步固定开销 1ms，每个序列另加 0.05ms
+	batchBenchStep   = time.Millisecond
+	batchBenchPerSeq = 50 * time.Microsecond
+)
+
+// batchBenchStats 一次基准运行中收集的延迟
+type batchBenchStats struct {
+	mu     sync.Mutex
+	ttfts  []time.Duration
+	itls   []time.Duration
+	tokens int
+}
+
+// consume 读取一个请求的全部响应并记录延迟，submitted 为提交时间
+func (s *batchBenchStats) consume(req *StreamRequest, submitted time.Time) {
+	var ttft time.Duration
+	var itls []time.Duration
+	last, tokens := submitted, 0
+	for res := range req.Output {
+		for range res.Tokens {
+			now := time.Now()
+			if tokens == 0 {
+				ttft = now.Sub(submitted)
+			} else {
+				itls = append(itls, now.Sub(last))
+			}
+			last = now
+			tokens++
+		}
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.ttfts = append(s.ttfts, ttft)
+	s.itls = append(s.itls, itls...)
+	s.tokens += tokens
+}
+
+// BenchmarkBatching burst：全部请求同时到达；staggered：每隔一步到达一个，序列在批次运行中陆续加入与离开。
+// 模拟后端每步耗时 batchBenchStep + batchBenchPerSeq × 批大小
+func BenchmarkBatching(b *testing.B) {
+	perRequest := func(b *testing.B) ModelGenerator {
+		return NewMockBatchedGenerator(batchBenchStep, batchBenchPerSeq, batchBenchTokens)
+	}
+	continuous := func(maxBatch int) func(b *testing.B) ModelGenerator {
+		return func(b *testing.B) ModelGenerator {
+			cb := NewContinuousBatcher(NewMockBatchedGenerator(batchBenchStep, batchBenchPerSeq, batchBenchTokens), maxBatch, batchBenchTokens)
+			b.Cleanup(cb.Close)
+			return cb
+		}
+	}
+	for _, arrival := range []struct {
+		name string
+		gap  time.Duration
+	}{{"burst", 0}, {"staggered", batchBenchStep}} {
+		for _, c := range []struct {
+			name string
+			gen  func(b *testing.B) ModelGenerator
+		}{
+			{"per-request", perRequest},
+			{"continuous/max-4", continuous(4)},
+			{"continuous/max-16", continuous(batchBenchRequests)},
+		} {
+			b.Run(c.name+"/"+arrival.name, func(b *testing.B) {
+				benchmarkBatching(b, c.gen(b), arrival.gap)
+			})
+		}
+	}
+}
+
+// benchmarkBatching 每次迭代提交 batchBenchRequests 个请求，相邻请求间隔 gap 到达，等待全部完成
+func benchmarkBatching(b *testing.B, gen ModelGenerator, gap time.Duration) {
+	// worker 数不构成瓶颈，并发度只受生成器本身限制
+	p := NewStreamPipeline(gen, batchBenchRequests, nil, nil)
+	p.StartWorkers(batchBenchRequests)
+	b.Cleanup(p.Stop)
+	stats := &batchBenchStats{}
+	stepsBefore, seqsBefore := batchSizeTotals()
+	b.ResetTimer()
+
+	start := time.Now()
+	for i := 0; i < b.N; i++ {
+		var wg sync.WaitGroup
+		for r := 0; r < batchBenchRequests; r++ {
+			if r > 0 && gap > 0 {
+				time.Sleep(gap)
+			}
+			req := &StreamRequest{
+				ID:      fmt.Sprintf("bench-%d-%d", i, r),
+				Ctx:     context.Background(),
+				Prompt:  "bench",
+				Options: &GenerateOptions{MaxTokens: batchBenchTokens},
+				Output:  make(chan *StreamResponse, batchBenchTokens+1),
+			}
+			submitted := time.Now()
+			if err := p.Submit(req); err != nil {
+				b.Fatal(err)
+			}
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				stats.consume(req, submitted)
+			}()
+		}
+		wg.Wait()
+	}
+	elapsed := time.Since(start)
+	b.StopTimer()
+
+	steps, seqs := batchSizeTotals()
+	if steps > stepsBefore {
+		b.ReportMetric((seqs-seqsBefore)/float64(steps-stepsBefore), "batch")
+	} else {
+		b.ReportMetric(1, "batch")
+	}
+	b.ReportMetric(float64(stats.tokens)/elapsed.Seconds(), "tokens/s")
+	b.ReportMetric(durationPercentile(stats.ttfts, 0.50), "ttft-p50-ms")
+	b.ReportMetric(durationPercentile(stats.ttfts, 0.99), "ttft-p99-ms")
+	b.ReportMetric(durationPercentile(stats.itls, 0.50), "itl-p50-ms")
+	b.ReportMetric(durationPercentile(stats.itls, 0.99), "itl-p99-ms")
+}
+
+// batchSizeTotals 返回批处理指标中的步数与序列总数
+func batchSizeTotals() (uint64, float64) {
+	h := metrics.BatchSize
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return h.count, h.sum
+}
+
+// durationPercentile 返回 q 分位的延迟（毫秒），最近秩法
+func durationPercentile(ds []time.Duration, q float64) float64 {
+	if len(ds) == 0 {
+		return 0
+	}
+	sorted := append([]time.Duration(nil), ds...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+	i := int(q*float64(len(sorted))+0.999999) - 1
+	return float64(sorted[max(0, min(i, len(sorted)-1))].Microseconds()) / 1000
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"net/netip"
+	"strings"
+	"time"
+)
+
+// 回调地址由调用方指定，服务端代为发起请求。为防止借回调访问内网服务或云主机元数据（SSRF），
+// 回调只能发往公网地址：提交时解析主机名校验一次，每次建立连接前重新解析并连接到校验过的地址，
+// 避免 DNS 重绑定绕过提交时的校验；重定向后的地址同样经由 DialContext 校验。回调接收方本就在内网时，将其主机名加入 jobs.callback_allowed_hosts
+
+// sharedAddressSpace 运营商级 NAT 地址（RFC 6598），部分云厂商的元数据服务位于其中
+var sharedAddressSpace = netip.MustParsePrefix("100.64.0.0/10")
+
+// publicAddr 地址是否可以作为回调目标：排除回环、私有、链路本地（含 169.254.169.254）、未指定与组播地址
+func publicAddr(a netip.Addr) bool {
+	a = a.Unmap()
+	return a.IsValid() && !a.IsLoopback() && !a.IsPrivate() && !a.IsLinkLocalUnicast() && !a.IsUnspecified() &&
+		!a.IsMulticast() && !sharedAddressSpace.Contains(a)
+}
+
+// callbackGuard 校验回调地址解析到的 IP
+type callbackGuard struct {
+	allowed  map[string]bool // 不受限制的主机名或 IP，小写
+	resolver *net.Resolver
+	dialer   *net.Dialer
+}
+
+func newCallbackGuard(allowedHosts []string) *callbackGuard {
+	g := &callbackGuard{
+		allowed:  make(map[string]bool, len(allowedHosts)),
+		resolver: net.DefaultResolver,
+		dialer:   &net.Dialer{Timeout: 10 * time.Second, KeepAlive: 30 * time.Second},
+	}
+	for _, h := range allowedHosts {
+		g.allowed[strings.ToLower(h)] = true
+	}
+	return g
+}
+
+// resolve 解析 host 并校验全部地址，任一地址不是公网地址即拒绝；host 在白名单中时返回 nil, nil
+func (g *callbackGuard) resolve(ctx context.Context, host string) ([]netip.Addr, error) {
+	if g.allowed[strings.ToLower(host)] {
+		return nil, nil
+	}
+	var addrs []netip.Addr
+	if a, err := netip.ParseAddr(host); err == nil {
+		addrs = []netip.Addr{a}
+	} else if addrs, err = g.resolver.LookupNetIP(ctx, "ip", host); err != nil {
+		return nil, fmt.Errorf("无法解析回调主机 %s: %w", host, err)
+	}
+	for _, a := range addrs {
+		if !publicAddr(a) {
+			return nil, fmt.Errorf("回调主机 %s 解析到内网或保留地址 %s", host, a.Unmap())
+		}
+	}
+	return addrs, nil
+}
+
+// DialContext 用作回调 http.Transport 的 DialContext：重新解析并校验，依次连接校验过的地址
+func (g *callbackGuard) DialContext(ctx context.Context, network, addr string) (net.Conn, error) {
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return nil, err
+	}
+	addrs, err := g.resolve(ctx, host)
+	if err != nil {
+		return nil, err
+	}
+	if addrs == nil {
+		return g.dialer.DialContext(ctx, network, addr)
+	}
+	for _, a := range addrs {
+		var conn net.Conn
+		if conn, err = g.dialer.DialContext(ctx, network, net.JoinHostPort(a.Unmap().String(), port)); err == nil {
+			return conn, nil
+		}
+	}
+	return nil, err
+}
+
+// client 返回经由 g 建立连接的回调客户端。不使用环境变量中的代理，否则连接的是代理而不是回调地址
+func (g *callbackGuard) client(timeout time.Duration) *http.Client {
+	t := http.DefaultTransport.(*http.Transport).Clone()
+	t.Proxy = nil
+	t.DialContext = g.DialContext
+	return &http.Client{Timeout: timeout, Transport: t}
+}
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestPublicAddr(t *testing.T) {
+	for addr, want := range map[string]bool{
+		"8.8.8.8":         true,
+		"2001:4860::8888": true,
+		"127.0.0.1":       false,
+		"::1":             false,
+		"10.1.2.3":        false,
+		"172.16.0.1":      false,
+		"192.168.1.1":     false,
+		"169.254.169.254": false,
+		"fe80::1":         false,
+		"fd00::1":         false,
+		"0.0.0.0":         false,
+		"::":              false,
+		"100.100.100.200": false,
+		"224.0.0.1":       false,
+		"::ffff:10.0.0.1": false,
+	} {
+		if got := publicAddr(netip.MustParseAddr(addr)); got != want {
+			t.Errorf("publicAddr(%s) = %v，期望 %v", addr, got, want)
+		}
+	}
+}
+
+func TestCallbackGuardResolve(t *testing.T) {
+	g := newCallbackGuard([]string{"Hooks.Internal"})
+	for host, ok := range map[string]bool{
+		"127.0.0.1":       false,
+		"localhost":       false,
+		"169.254.169.254": false,
+		"hooks.internal":  true, // 白名单不解析
+		"1.1.1.1":         true,
+	} {
+		_, err := g.resolve(context.Background(), host)
+		if (err == nil) != ok {
+			t.Errorf("resolve(%s) err = %v，期望通过 = %v", host, err, ok)
+		}
+	}
+}
+
+// TestCallbackGuardRedirect 白名单主机重定向到内网地址时，连接前的校验拒绝该地址
+func TestCallbackGuardRedirect(t *testing.T) {
+	target := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		t.Error("重定向到内网地址的请求不应送达")
+	}))
+	defer target.Close()
+	u, _ := url.Parse(target.URL)
+	redirect := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Redirect(w, r, "http://localhost:"+u.Port()+"/", http.StatusTemporaryRedirect)
+	}))
+	defer redirect.Close()
+
+	client := newCallbackGuard([]string{"127.0.0.1"}).client(time.Second)
+	_, err := client.Post(redirect.URL, "application/json", strings.NewReader("{}"))
+	if err == nil || !strings.Contains(err.Error(), "内网或保留地址") {
+		t.Errorf("err = %v，期望重定向目标被拒绝", err)
+	}
+}
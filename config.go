@@ -0,0 +1,351 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"slices"
+)
+
+// ServerConfig 流式服务配置
+type ServerConfig struct {
+	Addr      string          `json:"addr"`
+	Generator GeneratorConfig `json:"generator"`
+	Pipeline  PipelineConfig  `json:"pipeline"`
+	Sessions  SessionConfig   `json:"sessions"`
+	Auth      AuthConfig      `json:"auth"`
+	Delivery  DeliveryConfig  `json:"delivery"`
+	Dev       DevConfig       `json:"dev"`
+	Journal   JournalConfig   `json:"journal"`
+	Tracing   TracingConfig   `json:"tracing"`
+	Filter    FilterConfig    `json:"filter"`
+	Jobs      JobsConfig      `json:"jobs"`
+	Admin     AdminConfig     `json:"admin"`
+	// 日志级别：debug、info、warn、error，可通过 PATCH /admin/runtime 调整
+	LogLevel string `json:"log_level"`
+	// 断线后生成与回放缓冲区的保留时间（秒）
+	ResumeGraceSeconds int `json:"resume_grace_seconds"`
+	// 输入完全相同的并发请求是否共享同一个生成
+	DedupePrompts bool `json:"dedupe_prompts"`
+	// 关闭时等待进行中的流结束的最长时间（秒），超时后取消剩余生成
+	DrainTimeoutSeconds int `json:"drain_timeout_seconds"`
+}
+
+// PipelineConfig 流式 worker 池配置
+type PipelineConfig struct {
+	Workers  int `json:"workers"`   // 最大并发生成数
+	MaxQueue int `json:"max_queue"` // 最大排队请求数，超出后返回 503
+	// 各优先级的调度权重与排队上限，键为 interactive、standard、batch
+	Classes map[PriorityClass]PriorityClassConfig `json:"classes"`
+}
+
+// GeneratorConfig 生成器配置，Type 为空时使用离线 mock
+type GeneratorConfig struct {
+	Type       GeneratorType `json:"type"`
+	BufferSize int           `json:"buffer_size"`
+	BaseURL    string        `json:"base_url"`
+	Model      string        `json:"model"`
+	APIKey     string        `json:"api_key"`
+	// 允许请求通过 model 参数选择的模型，为空时不限制
+	Models []string `json:"models"`
+	// 类型为 mock_batched 时的连续批处理参数
+	Batching BatchingConfig `json:"batching"`
+	// 类型为 router 时的后端列表与健康检查、熔断参数
+	Router RouterConfig `json:"router"`
+}
+
+// RouterConfig 多后端路由配置
+type RouterConfig struct {
+	// weighted（按权重轮询）或 least_loaded（进行中请求数与权重之比最小）
+	Strategy RouterStrategy  `json:"strategy"`
+	Backends []BackendConfig `json:"backends"`
+	// 主动健康检查的间隔与超时（毫秒），间隔为 0 时不检查
+	HealthIntervalMs int `json:"health_interval_ms"`
+	HealthTimeoutMs  int `json:"health_timeout_ms"`
+	// 连续失败达到该次数后熔断，冷却 OpenSeconds 秒后放行一个试探请求
+	FailureThreshold int `json:"failure_threshold"`
+	OpenSeconds      int `json:"open_seconds"`
+}
+
+// BackendConfig 路由的一个后端，生成器字段与 GeneratorConfig 相同，不能再嵌套 router
+type BackendConfig struct {
+	Name   string `json:"name"`
+	Weight int    `json:"weight"` // 默认 1
+	GeneratorConfig
+}
+
+// BatchingConfig 连续批处理配置，生成器类型为 mock_batched 时生效
+type BatchingConfig struct {
+	// 每步最多合并的序列数，1 表示不合并：每个请求独立执行前向计算。
+	// 实际批大小还受 pipeline.workers 限制
+	MaxBatch int `json:"max_batch"`
+	// 模拟后端一步的耗时：StepMs + PerSequenceMs × 批大小
+	StepMs        float64 `json:"step_ms"`
+	PerSequenceMs float64 `json:"per_sequence_ms"`
+}
+
+// defaultConfig 返回未提供配置文件时的默认配置
+func defaultConfig() *ServerConfig {
+	return &ServerConfig{
+		Addr:                ":8080",
+		LogLevel:            "info",
+		ResumeGraceSeconds:  30,
+		DedupePrompts:       true,
+		DrainTimeoutSeconds: 30,
+		Generator: GeneratorConfig{
+			Type:       GeneratorMock,
+			BufferSize: 10,
+			Batching: BatchingConfig{
+				MaxBatch:      16,
+				StepMs:        20,
+				PerSequenceMs: 1,
+			},
+			Router: RouterConfig{
+				Strategy:         RouteWeighted,
+				HealthIntervalMs: 5000,
+				HealthTimeoutMs:  2000,
+				FailureThreshold: 3,
+				OpenSeconds:      30,
+			},
+		},
+		Pipeline: PipelineConfig{
+			Workers:  8,
+			MaxQueue: 64,
+			Classes: map[PriorityClass]PriorityClassConfig{
+				PriorityInteractive: {Weight: 8},
+				PriorityStandard:    {Weight: 4},
+				PriorityBatch:       {Weight: 1, MaxQueue: 32},
+			},
+		},
+		Sessions: SessionConfig{
+			Store: SessionStoreMemory,
+			Dir:   "sessions",
+		},
+		Dev: DevConfig{
+			FixturesDir: "fixtures",
+		},
+		Jobs: JobsConfig{
+			Dir:                 "jobs",
+			Concurrency:         4,
+			CallbackMaxAttempts: 8,
+			CallbackTimeoutMs:   10000,
+			RetentionHours:      168,
+		},
+		Filter: FilterConfig{
+			PII:         []string{RuleCNMobile, RuleCNIDCard, RuleEmail},
+			Replacement: "[REDACTED]",
+			WindowChars: 32,
+		},
+		Tracing: TracingConfig{
+			ServiceName: "ai-answer-demo",
+			SampleRatio: 1,
+		},
+		Delivery: DeliveryConfig{
+			WriteTimeoutMs:   10000,
+			FlushIntervalMs:  20,
+			FlushBytes:       4096,
+			LagBudgetMs:      30000,
+			SlowClientPolicy: SlowClientDisconnect,
+		},
+	}
+}
+
+// LoadConfig 从 JSON 文件加载配置，文件不存在时退回默认配置
+func LoadConfig(path string) (*ServerConfig, error) {
+	cfg := defaultConfig()
+
+	data, err := os.ReadFile(path)
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return nil, err
+	}
+	if err == nil {
+		if err := json.Unmarshal(data, cfg); err != nil {
+			return nil, err
+		}
+	}
+
+	for class := range cfg.Pipeline.Classes {
+		if _, err := ParsePriorityClass(string(class)); err != nil {
+			return nil, fmt.Errorf("pipeline.classes: 未知的优先级 %q", class)
+		}
+	}
+
+	if err := cfg.Generator.Router.validate(); err != nil {
+		return nil, err
+	}
+
+	// API Key 优先从环境变量读取，避免写入配置文件
+	if cfg.Generator.APIKey == "" {
+		cfg.Generator.APIKey = os.Getenv("OPENAI_API_KEY")
+	}
+	if _, err := ParseLogLevel(cfg.LogLevel); err != nil {
+		return nil, fmt.Errorf("log_level: %w", err)
+	}
+	if cfg.Admin.Token == "" {
+		cfg.Admin.Token = os.Getenv("ADMIN_TOKEN")
+	}
+	if cfg.Jobs.CallbackSecret == "" {
+		cfg.Jobs.CallbackSecret = os.Getenv("JOBS_CALLBACK_SECRET")
+	}
+	for i := range cfg.Generator.Router.Backends {
+		if b := &cfg.Generator.Router.Backends[i]; b.APIKey == "" && b.Type == GeneratorOpenAI {
+			b.APIKey = os.Getenv("OPENAI_API_KEY")
+		}
+	}
+	return cfg, nil
+}
+
+// SessionConfig 会话存储配置
+type SessionConfig struct {
+	Store SessionStoreType `json:"store"` // memory / file
+	Dir   string           `json:"dir"`   // file 存储的目录
+}
+
+// JournalConfig 生成记录配置
+type JournalConfig struct {
+	// JSONL 日志文件路径，每次生成一行，为空（默认）时不记录。
+	// 记录包含完整的用户输入与生成内容（其中可能有用户粘贴的个人信息），开启前应确认日志文件的访问控制与保留策略
+	Path string `json:"path"`
+	// 是否开放 GET /replay/{id}；记录中含用户输入，生产环境建议只使用 -replay 命令
+	ReplayEndpoint bool `json:"replay_endpoint"`
+}
+
+// AdminConfig 管理接口配置
+type AdminConfig struct {
+	// 访问 /admin/ 的 Bearer 令牌，为空时从环境变量 ADMIN_TOKEN 读取，仍为空则不开放管理接口。
+	// 与 API Key 相互独立，管理请求不计入任何密钥的限流与额度
+	Token string `json:"token"`
+}
+
+// JobsConfig 异步生成任务配置
+type JobsConfig struct {
+	// 任务文件目录，每个任务一个 JSON 文件，重启后未完成的任务重新执行；为空时不开放 /jobs
+	Dir string `json:"dir"`
+	// 同时执行的任务数上限，任务的生成仍经由 pipeline 排队
+	Concurrency int `json:"concurrency"`
+	// 回调签名密钥（HMAC-SHA256），为空时从环境变量 JOBS_CALLBACK_SECRET 读取，仍为空则不接受回调地址
+	CallbackSecret string `json:"callback_secret"`
+	// 回调最多投递次数，失败后从 1 秒起指数退避
+	CallbackMaxAttempts int `json:"callback_max_attempts"`
+	CallbackTimeoutMs   int `json:"callback_timeout_ms"`
+	// 允许解析到内网地址的回调主机名或 IP。默认回调只能发往公网地址，防止借回调访问内网服务
+	CallbackAllowedHosts []string `json:"callback_allowed_hosts"`
+	// 已结束任务的保留时间（小时），到期后删除任务文件，0 表示一直保留
+	RetentionHours int `json:"retention_hours"`
+}
+
+// FilterConfig 生成内容过滤配置
+type FilterConfig struct {
+	Enabled bool `json:"enabled"`
+	// 启用的个人信息规则：cn_mobile、cn_id_card、email
+	PII []string `json:"pii"`
+	// 自定义屏蔽词，不区分大小写；BlocklistFile 中每行一个，两者合并生效
+	Blocklist     []string `json:"blocklist"`
+	BlocklistFile string   `json:"blocklist_file"`
+	// 命中内容的替换文本
+	Replacement string `json:"replacement"`
+	// 为匹配跨越 token 边界而暂存的字符数，至少为最长屏蔽词的长度。越大越安全，但输出延迟也越大
+	WindowChars int `json:"window_chars"`
+}
+
+// TracingConfig 链路追踪配置
+type TracingConfig struct {
+	// OTLP/JSON 导出目标：文件路径，或 http:// 开头的 OTLP/HTTP collector 地址
+	// （如 http://localhost:4318/v1/traces，可用 tracecollector 在本地接收）。为空时不启用
+	Export      string `json:"export"`
+	ServiceName string `json:"service_name"`
+	// 请求未携带 traceparent 时的采样率；携带时沿用上游的采样决定
+	SampleRatio float64 `json:"sample_ratio"`
+}
+
+// DevConfig 开发模式配置
+type DevConfig struct {
+	// 开启后请求可通过 X-Fixture 请求头选择 FixturesDir 下的回放脚本
+	Enabled     bool   `json:"enabled"`
+	FixturesDir string `json:"fixtures_dir"`
+}
+
+// AuthConfig API Key 认证配置
+type AuthConfig struct {
+	// 密钥文件路径，为空时不启用认证；收到 SIGHUP 时重新加载
+	KeysFile string `json:"keys_file"`
+}
+
+// DeliveryConfig 向客户端写出事件的策略
+type DeliveryConfig struct {
+	// 单次写出与 flush 的截止时间（毫秒），超时视为连接已不可用，0 表示不限制
+	WriteTimeoutMs int `json:"write_timeout_ms"`
+	// 距上次 flush 不足该间隔时先攒批，到期或攒够 FlushBytes 再 flush；0 表示每批事件都立即 flush
+	FlushIntervalMs int `json:"flush_interval_ms"`
+	FlushBytes      int `json:"flush_bytes"`
+	// 待写出事件的滞后上限（毫秒），超出后按 SlowClientPolicy 处理，0 表示不检查
+	LagBudgetMs      int              `json:"lag_budget_ms"`
+	SlowClientPolicy SlowClientPolicy `json:"slow_client_policy"`
+}
+
+// validate 检查路由策略与后端名称；未使用 router 时后端列表为空，不做检查
+func (c RouterConfig) validate() error {
+	if c.Strategy != RouteWeighted && c.Strategy != RouteLeastLoaded {
+		return fmt.Errorf("generator.router.strategy: 未知的策略 %q", c.Strategy)
+	}
+	seen := make(map[string]bool)
+	for _, b := range c.Backends {
+		if b.Name == "" {
+			return errors.New("generator.router.backends: 后端缺少 name")
+		}
+		if seen[b.Name] {
+			return fmt.Errorf("generator.router.backends: 后端名称 %q 重复", b.Name)
+		}
+		if b.Type == GeneratorRouter {
+			return fmt.Errorf("generator.router.backends: 后端 %q 不能是 router", b.Name)
+		}
+		seen[b.Name] = true
+	}
+	return nil
+}
+
+// ModelNames 返回对外展示的模型名称，第一个为默认模型
+func (c GeneratorConfig) ModelNames() []string {
+	if c.Type == GeneratorMock || c.Type == "" || c.Type == GeneratorMockBatched {
+		return []string{string(GeneratorMock)}
+	}
+	names := []string{}
+	if c.Type == GeneratorRouter {
+		// 各后端模型的并集，再加上 Models 中额外列出的
+		for _, b := range c.Router.Backends {
+			for _, m := range b.ModelNames() {
+				if !slices.Contains(names, m) {
+					names = append(names, m)
+				}
+			}
+		}
+		for _, m := range c.Models {
+			if !slices.Contains(names, m) {
+				names = append(names, m)
+			}
+		}
+		return names
+	}
+	if c.Model != "" {
+		names = append(names, c.Model)
+	}
+	for _, m := range c.Models {
+		if m != c.Model {
+			names = append(names, m)
+		}
+	}
+	return names
+}
+
+// AllowedModels 返回请求可通过 model 参数选择的模型，为空表示不限制
+func (c GeneratorConfig) AllowedModels() []string {
+	if c.Type == GeneratorRouter && len(c.Models) == 0 {
+		return nil
+	}
+	if c.Type == GeneratorMock || c.Type == "" || c.Type == GeneratorMockBatched || len(c.Models) > 0 {
+		return c.ModelNames()
+	}
+	return nil
+}
@@ -0,0 +1,113 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"os"
+	"time"
+)
+
+// SlowClientPolicy 客户端读取速度跟不上生成时的处理策略
+type SlowClientPolicy string
+
+const (
+	// SlowClientDisconnect 下发 slow_consumer 错误后断开，生成继续保留，客户端可通过 Last-Event-ID 续传
+	SlowClientDisconnect SlowClientPolicy = "disconnect"
+	// SlowClientIgnore 不检查滞后，只受单次写出的截止时间约束
+	SlowClientIgnore SlowClientPolicy = "ignore"
+)
+
+func (c DeliveryConfig) writeTimeout() time.Duration {
+	return time.Duration(c.WriteTimeoutMs) * time.Millisecond
+}
+
+func (c DeliveryConfig) flushInterval() time.Duration {
+	return time.Duration(c.FlushIntervalMs) * time.Millisecond
+}
+
+func (c DeliveryConfig) lagBudget() time.Duration {
+	if c.SlowClientPolicy == SlowClientIgnore {
+		return 0
+	}
+	return time.Duration(c.LagBudgetMs) * time.Millisecond
+}
+
+// sseWriter 按 DeliveryConfig 向单个连接写出 SSE 事件：每次写出前设置写截止时间，
+// 按时间与字节阈值合并 flush。空闲后的第一个事件立即 flush 以保证首字延迟，
+// 突发的连续 token 在 FlushIntervalMs 内合并为一次 flush
+type sseWriter struct {
+	w   http.ResponseWriter
+	rc  *http.ResponseController
+	cfg DeliveryConfig
+
+	since     time.Time // 连接开始时间，回放历史事件不计入滞后
+	pending   int       // 上次 flush 后写入的字节数
+	lastFlush time.Time
+	flushes   int
+}
+
+func newSSEWriter(w http.ResponseWriter, cfg DeliveryConfig) *sseWriter {
+	return &sseWriter{w: w, rc: http.NewResponseController(w), cfg: cfg, since: time.Now()}
+}
+
+// deadline 设置本次写出的截止时间，底层不支持时忽略
+func (s *sseWriter) deadline() {
+	if d := s.cfg.writeTimeout(); d > 0 {
+		s.rc.SetWriteDeadline(time.Now().Add(d))
+	}
+}
+
+// Write 写出一个事件，不 flush
+func (s *sseWriter) Write(id string, ev StreamEvent) error {
+	s.deadline()
+	n, err := writeEvent(s.w, id, ev)
+	s.pending += n
+	return err
+}
+
+// Lagging 检查下一个待写出事件是否已超出滞后预算
+func (s *sseWriter) Lagging(next StreamEvent, now time.Time) bool {
+	budget := s.cfg.lagBudget()
+	if budget <= 0 {
+		return false
+	}
+	at := next.At
+	if at.Before(s.since) {
+		at = s.since
+	}
+	return now.Sub(at) > budget
+}
+
+// Flush 立即 flush 已写入的事件
+func (s *sseWriter) Flush() error {
+	return s.flush(time.Now())
+}
+
+func (s *sseWriter) flush(now time.Time) error {
+	s.deadline()
+	s.pending = 0
+	s.lastFlush = now
+	s.flushes++
+	return s.rc.Flush()
+}
+
+// MaybeFlush 按合并策略 flush，返回 0 表示已 flush（或无需 flush），
+// 否则返回最迟应在多久之后 flush
+func (s *sseWriter) MaybeFlush(now time.Time) (time.Duration, error) {
+	if s.pending == 0 {
+		return 0, nil
+	}
+	interval := s.cfg.flushInterval()
+	elapsed := now.Sub(s.lastFlush)
+	if interval <= 0 || elapsed >= interval || (s.cfg.FlushBytes > 0 && s.pending >= s.cfg.FlushBytes) {
+		return 0, s.flush(now)
+	}
+	return interval - elapsed, nil
+}
+
+// countWriteTimeout 写出因截止时间到期失败时计入慢客户端断开
+func countWriteTimeout(err error) {
+	if errors.Is(err, os.ErrDeadlineExceeded) {
+		metrics.SlowClientsWriteTimeout.Inc()
+	}
+}
@@ -0,0 +1,194 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"time"
+
+	"github.com/cloudwego/eino/schema"
+)
+
+// EventProtocolVersion SSE 事件协议版本，写入每个事件 data 的 v 字段；
+// 浏览器 EventSource 读不到响应头，因此版本号随 payload 下发
+const EventProtocolVersion = 1
+
+// EventType SSE 事件名
+type EventType string
+
+const (
+	EventQueued   EventType = "queued"
+	EventToken    EventType = "token"
+	EventToolCall EventType = "tool_call"
+	// 内容过滤隐去了一处内容，紧挨在包含替换文本的 token 事件之前
+	EventModeration EventType = "moderation"
+	// 多后端路由在生成中途切换了后端，之后的 token 由新后端续写
+	EventFailover EventType = "failover"
+	EventUsage    EventType = "usage"
+	EventError    EventType = "error"
+	EventDone     EventType = "done"
+	EventShutdown EventType = "shutdown"
+)
+
+// DoneReason done 事件中的结束原因
+type DoneReason string
+
+const (
+	DoneStop      DoneReason = "stop"
+	DoneLength    DoneReason = "length" // 达到 max_tokens
+	DoneError     DoneReason = "error"
+	DoneCancelled DoneReason = "cancelled"
+)
+
+// 错误码
+const (
+	ErrCodeGeneration = "generation_failed"
+	ErrCodeInternal   = "internal_error"
+	// 客户端读取跟不上生成，连接被断开，可携带 Last-Event-ID 续传
+	ErrCodeSlowConsumer = "slow_consumer"
+)
+
+// QueuedData queued 事件：请求在 worker 池中排队
+type QueuedData struct {
+	V        int `json:"v"`
+	Position int `json:"position"`
+}
+
+// TokenData token 事件：一个生成的 token
+type TokenData struct {
+	V       int    `json:"v"`
+	Index   int    `json:"index"`
+	Content string `json:"content"`
+}
+
+// ToolCallData tool_call 事件：模型发起的工具调用（流式增量）
+type ToolCallData struct {
+	V         int    `json:"v"`
+	Index     int    `json:"index"`
+	ID        string `json:"id,omitempty"`
+	Name      string `json:"name,omitempty"`
+	Arguments string `json:"arguments,omitempty"`
+}
+
+func newToolCallData(call schema.ToolCall) *ToolCallData {
+	data := &ToolCallData{
+		V:         EventProtocolVersion,
+		ID:        call.ID,
+		Name:      call.Function.Name,
+		Arguments: call.Function.Arguments,
+	}
+	if call.Index != nil {
+		data.Index = *call.Index
+	}
+	return data
+}
+
+// ModerationData moderation 事件：一处被隐去的内容，不包含原文
+type ModerationData struct {
+	V           int                `json:"v"`
+	Category    ModerationCategory `json:"category"`
+	Rule        string             `json:"rule"`
+	Action      string             `json:"action"`
+	Replacement string             `json:"replacement"`
+}
+
+func newModerationData(m Moderation) *ModerationData {
+	return &ModerationData{V: EventProtocolVersion, Category: m.Category, Rule: m.Rule, Action: "redact", Replacement: m.Replacement}
+}
+
+// FailoverData failover 事件：生成中途从 From 切换到 To，此前已下发 Tokens 个 token
+type FailoverData struct {
+	V      int    `json:"v"`
+	From   string `json:"from"`
+	To     string `json:"to"`
+	Tokens int    `json:"tokens"`
+}
+
+func newFailoverData(f Failover) *FailoverData {
+	return &FailoverData{V: EventProtocolVersion, From: f.From, To: f.To, Tokens: f.Tokens}
+}
+
+// UsageData usage 事件：本次生成的 token 用量
+type UsageData struct {
+	V                int `json:"v"`
+	PromptTokens     int `json:"prompt_tokens"`
+	CompletionTokens int `json:"completion_tokens"`
+	TotalTokens      int `json:"total_tokens"`
+}
+
+// ErrorData error 事件：生成或服务端处理失败
+type ErrorData struct {
+	V       int    `json:"v"`
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+// DoneData done 事件：流的最后一个事件
+type DoneData struct {
+	V      int        `json:"v"`
+	Reason DoneReason `json:"reason"`
+}
+
+// ShutdownData shutdown 事件：服务开始关闭，进行中的生成最迟在 DrainDeadline 被取消
+type ShutdownData struct {
+	V             int    `json:"v"`
+	DrainDeadline string `json:"drain_deadline"`
+}
+
+// StreamEvent 已编码的 SSE 事件，data 在生成时编码一次，回放时直接写出
+type StreamEvent struct {
+	Type EventType
+	Data []byte
+	At   time.Time // 事件产生时间，用于计算客户端滞后
+
+	tokens int // token 事件代表的上游 token 数，由 Generation.appendToken 设置，用于计量
+}
+
+// NewStreamEvent 编码事件 payload
+func NewStreamEvent(typ EventType, data any) StreamEvent {
+	b, err := json.Marshal(data)
+	if err != nil {
+		b, _ = json.Marshal(&ErrorData{V: EventProtocolVersion, Code: ErrCodeInternal, Message: err.Error()})
+		typ = EventError
+	}
+	return StreamEvent{Type: typ, Data: b, At: time.Now()}
+}
+
+// writeEvent 写出一个 SSE 帧，id 为空时不写 id 字段（不影响客户端的 Last-Event-ID）。
+// 各字段直接写入 w 的缓冲区，不经过 fmt 格式化，也不拼接中间字符串
+func writeEvent(w io.Writer, id string, ev StreamEvent) (int, error) {
+	fw := frameWriter{w: w}
+	if id != "" {
+		fw.writeString("id: ")
+		fw.writeString(id)
+		fw.writeString("\n")
+	}
+	fw.writeString("event: ")
+	fw.writeString(string(ev.Type))
+	fw.writeString("\ndata: ")
+	fw.write(ev.Data)
+	fw.writeString("\n\n")
+	return fw.n, fw.err
+}
+
+// frameWriter 累计写入字节数，遇到第一个错误后不再写入
+type frameWriter struct {
+	w   io.Writer
+	n   int
+	err error
+}
+
+func (f *frameWriter) writeString(s string) {
+	if f.err == nil {
+		var m int
+		m, f.err = io.WriteString(f.w, s)
+		f.n += m
+	}
+}
+
+func (f *frameWriter) write(b []byte) {
+	if f.err == nil {
+		var m int
+		m, f.err = f.w.Write(b)
+		f.n += m
+	}
+}
@@ -0,0 +1,179 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log"
+	"math/rand/v2"
+	"net/http"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// fakeollama：实现 Ollama /api/chat 与 /api/tags 的本地假后端，用于离线验证多后端路由的
+// 选择、健康检查、熔断与故障切换。输出形如 "a:token-0 a:token-1 ..."，带后端名，
+// 便于看出每个 token 来自哪个后端；对话末尾是 assistant 消息（续写）时接着其中的编号继续：
+//
+//	go run ./fakeollama -addr :11501 -name a -break-rate 1 -break-after 5 &
+//	go run ./fakeollama -addr :11502 -name b &
+//
+// 运行中切换健康状态（不健康时 /api/tags 与 /api/chat 均返回 503）：
+//
+//	curl -X POST 'localhost:11501/fake/health?ok=false'
+
+type config struct {
+	name       string
+	model      string
+	tokens     int
+	latency    time.Duration
+	failRate   float64
+	breakRate  float64
+	breakAfter int
+}
+
+type server struct {
+	cfg     config
+	healthy atomic.Bool
+}
+
+// chatRequest /api/chat 请求中用到的字段
+type chatRequest struct {
+	Model    string `json:"model"`
+	Messages []struct {
+		Role    string `json:"role"`
+		Content string `json:"content"`
+	} `json:"messages"`
+	Options struct {
+		NumPredict int `json:"num_predict"`
+	} `json:"options"`
+}
+
+// chatResponse /api/chat 的一行 NDJSON
+type chatResponse struct {
+	Model     string    `json:"model"`
+	CreatedAt time.Time `json:"created_at"`
+	Message   struct {
+		Role    string `json:"role"`
+		Content string `json:"content"`
+	} `json:"message"`
+	Done            bool   `json:"done"`
+	DoneReason      string `json:"done_reason,omitempty"`
+	PromptEvalCount int    `json:"prompt_eval_count,omitempty"`
+	EvalCount       int    `json:"eval_count,omitempty"`
+}
+
+func main() {
+	addr := flag.String("addr", ":11434", "监听地址")
+	var cfg config
+	flag.StringVar(&cfg.name, "name", "fake", "后端名，出现在每个 token 中")
+	flag.StringVar(&cfg.model, "model", "fake", "/api/tags 返回的模型名")
+	flag.IntVar(&cfg.tokens, "tokens", 20, "未指定 num_predict 时输出的 token 数")
+	flag.DurationVar(&cfg.latency, "latency", 50*time.Millisecond, "每个 token 的间隔")
+	flag.Float64Var(&cfg.failRate, "fail-rate", 0, "在输出第一个 token 之前返回 500 的概率")
+	flag.Float64Var(&cfg.breakRate, "break-rate", 0, "输出 -break-after 个 token 后以错误中断的概率")
+	flag.IntVar(&cfg.breakAfter, "break-after", 5, "中断前输出的 token 数")
+	flag.Parse()
+
+	s := &server{cfg: cfg}
+	s.healthy.Store(true)
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /{$}", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, "Ollama is running")
+	})
+	mux.HandleFunc("GET /api/tags", s.tags)
+	mux.HandleFunc("POST /api/chat", s.chat)
+	mux.HandleFunc("POST /fake/health", s.setHealth)
+	log.Printf("假后端 %s 监听 %s", cfg.name, *addr)
+	log.Fatal(http.ListenAndServe(*addr, mux))
+}
+
+func (s *server) tags(w http.ResponseWriter, r *http.Request) {
+	if !s.healthy.Load() {
+		http.Error(w, `{"error":"unhealthy"}`, http.StatusServiceUnavailable)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]any{
+		"models": []map[string]string{{"name": s.cfg.model, "model": s.cfg.model}},
+	})
+}
+
+func (s *server) setHealth(w http.ResponseWriter, r *http.Request) {
+	ok := r.URL.Query().Get("ok") != "false"
+	s.healthy.Store(ok)
+	log.Printf("健康状态: %v", ok)
+	fmt.Fprintf(w, "healthy=%v\n", ok)
+}
+
+// writeError 以 Ollama 的格式返回错误：状态码加一行 {"error": ...}，客户端据此报错
+func writeError(w http.ResponseWriter, code int, msg string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(map[string]string{"error": msg})
+}
+
+func (s *server) chat(w http.ResponseWriter, r *http.Request) {
+	var req chatRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	if !s.healthy.Load() {
+		writeError(w, http.StatusServiceUnavailable, "unhealthy")
+		return
+	}
+	if rand.Float64() < s.cfg.failRate {
+		log.Printf("模拟失败：输出前返回 500")
+		writeError(w, http.StatusInternalServerError, "simulated failure before first token")
+		return
+	}
+
+	// 续写时从 assistant 消息中已有的 token 数开始编号
+	first := 0
+	if n := len(req.Messages); n > 0 && req.Messages[n-1].Role == "assistant" {
+		first = strings.Count(req.Messages[n-1].Content, "token-")
+	}
+	count := s.cfg.tokens
+	if req.Options.NumPredict > 0 {
+		count = req.Options.NumPredict
+	}
+	breakAt := -1
+	if rand.Float64() < s.cfg.breakRate {
+		breakAt = min(s.cfg.breakAfter, count)
+	}
+
+	w.Header().Set("Content-Type", "application/x-ndjson")
+	flusher, _ := w.(http.Flusher)
+	enc := json.NewEncoder(w)
+	line := chatResponse{Model: req.Model}
+	line.Message.Role = "assistant"
+	for i := 0; i < count; i++ {
+		if i == breakAt {
+			log.Printf("模拟中断：已输出 %d 个 token", i)
+			enc.Encode(map[string]string{"error": "simulated failure mid-stream"})
+			return
+		}
+		select {
+		case <-r.Context().Done():
+			return
+		case <-time.After(s.cfg.latency):
+		}
+		line.CreatedAt = time.Now()
+		line.Message.Content = fmt.Sprintf("%s:token-%d ", s.cfg.name, first+i)
+		if err := enc.Encode(line); err != nil {
+			return
+		}
+		if flusher != nil {
+			flusher.Flush()
+		}
+	}
+
+	line.CreatedAt = time.Now()
+	line.Message.Content = ""
+	line.Done, line.DoneReason = true, "stop"
+	line.PromptEvalCount, line.EvalCount = len(req.Messages)*8, count
+	enc.Encode(line)
+}
@@ -0,0 +1,400 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+	"unicode/utf8"
+)
+
+// 内容过滤：在生成器输出与 SSE 写出之间隐去个人信息与屏蔽词，每处隐去都以 moderation 事件告知客户端。
+// 匹配可能跨越 token 边界，因此尾部一段文本会暂存，待后续 token 到达、确认不再构成匹配后再输出
+
+// 内置的个人信息规则
+const (
+	RuleCNMobile = "cn_mobile"  // 中国大陆手机号，可带 +86 前缀与空格、连字符分隔
+	RuleCNIDCard = "cn_id_card" // 18 位居民身份证号
+	RuleEmail    = "email"
+	// 自定义屏蔽词，不区分大小写
+	RuleBlocklist = "blocklist"
+)
+
+// filterRuleNames 全部规则名
+var filterRuleNames = []string{RuleCNMobile, RuleCNIDCard, RuleEmail, RuleBlocklist}
+
+// ModerationCategory 处置的类别
+type ModerationCategory string
+
+const (
+	ModerationPII       ModerationCategory = "pii"
+	ModerationBlocklist ModerationCategory = "blocklist"
+)
+
+// Moderation 一处内容处置。只记录规则与替换文本，不回显被隐去的原文
+type Moderation struct {
+	Category    ModerationCategory
+	Rule        string
+	Replacement string
+}
+
+// filterRule 一条匹配规则
+type filterRule struct {
+	name     string
+	category ModerationCategory
+	re       *regexp.Regexp
+	// 匹配前后不能紧邻数字，避免把更长数字串的一部分当作手机号或身份证号
+	digitBounded bool
+	// 匹配可能含空格的最大长度（字符），暂存窗口不小于它；不含空格的匹配由 safeCut 的连续字符规则保证
+	maxChars int
+}
+
+var piiRules = map[string]*filterRule{
+	RuleCNMobile: {
+		name:         RuleCNMobile,
+		category:     ModerationPII,
+		re:           regexp.MustCompile(`(?:\+?86[- ]?)?1[3-9]\d[- ]?\d{4}[- ]?\d{4}`),
+		digitBounded: true,
+		maxChars:     len("+86 138 0013 8000"),
+	},
+	RuleCNIDCard: {
+		name:         RuleCNIDCard,
+		category:     ModerationPII,
+		re:           regexp.MustCompile(`[1-9]\d{5}(?:18|19|20)\d{2}(?:0[1-9]|1[0-2])(?:0[1-9]|[12]\d|3[01])\d{3}[\dXx]`),
+		digitBounded: true,
+	},
+	RuleEmail: {
+		name:     RuleEmail,
+		category: ModerationPII,
+		re:       regexp.MustCompile(`[A-Za-z0-9._%+-]{1,64}@[A-Za-z0-9-]+(?:\.[A-Za-z0-9-]+)*\.[A-Za-z]{2,}`),
+	},
+}
+
+// ContentFilter 编译好的过滤规则，可被多个生成并发使用
+type ContentFilter struct {
+	rules       []*filterRule
+	replacement string
+	window      int // 暂存的字符数
+}
+
+// NewContentFilter 按配置编译规则，未开启时返回 nil
+func NewContentFilter(cfg FilterConfig) (*ContentFilter, error) {
+	if !cfg.Enabled {
+		return nil, nil
+	}
+	f := &ContentFilter{replacement: cfg.Replacement, window: cfg.WindowChars}
+	for _, name := range cfg.PII {
+		rule, ok := piiRules[name]
+		if !ok {
+			return nil, fmt.Errorf("filter.pii: 未知的规则 %q", name)
+		}
+		f.rules = append(f.rules, rule)
+		f.window = max(f.window, rule.maxChars)
+	}
+
+	terms := cfg.Blocklist
+	if cfg.BlocklistFile != "" {
+		fileTerms, err := readBlocklist(cfg.BlocklistFile)
+		if err != nil {
+			return nil, err
+		}
+		terms = append(terms, fileTerms...)
+	}
+	if len(terms) > 0 {
+		// 较长的词优先，避免只隐去其前缀
+		sorted := append([]string(nil), terms...)
+		sort.Slice(sorted, func(i, j int) bool { return len(sorted[i]) > len(sorted[j]) })
+		quoted := make([]string, len(sorted))
+		for i, t := range sorted {
+			quoted[i] = regexp.QuoteMeta(t)
+			// 暂存窗口至少要容纳最长的屏蔽词
+			f.window = max(f.window, utf8.RuneCountInString(t))
+		}
+		f.rules = append(f.rules, &filterRule{
+			name:     RuleBlocklist,
+			category: ModerationBlocklist,
+			re:       regexp.MustCompile(`(?i)(?:` + strings.Join(quoted, "|") + `)`),
+		})
+	}
+	return f, nil
+}
+
+// readBlocklist 每行一个屏蔽词，忽略空行与 # 开头的注释
+func readBlocklist(path string) ([]string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	var terms []string
+	sc := bufio.NewScanner(file)
+	for sc.Scan() {
+		line := strings.TrimSpace(sc.Text())
+		if line != "" && !strings.HasPrefix(line, "#") {
+			terms = append(terms, line)
+		}
+	}
+	return terms, sc.Err()
+}
+
+// Rules 返回启用的规则名
+func (f *ContentFilter) Rules() []string {
+	names := make([]string, len(f.rules))
+	for i, r := range f.rules {
+		names[i] = r.name
+	}
+	return names
+}
+
+// maxHoldBytes 连续的邮箱或数字字符最多暂存的字节数，超出后按窗口截断，
+// 避免很长的无分隔文本（如 base64）一直滞留到生成结束
+const maxHoldBytes = 256
+
+// redactor 一次生成的过滤状态
+type redactor struct {
+	f       *ContentFilter
+	pending string
+	bounds  []tokenBound // pending 中各段上游输出的结束位置，递增
+	prev    rune         // 已输出文本的最后一个字符，用于判断数字边界
+}
+
+func (f *ContentFilter) newRedactor() *redactor {
+	return &redactor{f: f, prev: -1}
+}
+
+// piece 一段可以输出的（已隐去的）文本，对应 tokens 个完整的上游 token
+type piece struct {
+	text   string
+	tokens int
+	mods   []Moderation
+}
+
+// chunk 转为单独下发的分片
+func (p piece) chunk() Chunk {
+	return Chunk{Content: p.text, Tokens: p.tokens, Moderations: p.mods}
+}
+
+// tokenBound 一段上游输出的结束位置及其包含的 token 数
+type tokenBound struct {
+	end, tokens int
+}
+
+// Push 追加一段包含 n 个上游 token 的文本，返回可以安全输出的文本，按 token 边界分段
+func (r *redactor) Push(s string, n int) []piece {
+	r.pending += s
+	r.bounds = append(r.bounds, tokenBound{end: len(r.pending), tokens: n})
+	return r.emit(false)
+}
+
+// Flush 流结束时输出暂存的文本
+func (r *redactor) Flush() []piece {
+	return r.emit(true)
+}
+
+// match pending 中的一处匹配
+type match struct {
+	start, end int
+	rule       *filterRule
+}
+
+// emit 输出 pending 中已确定的部分。未结束时保留末尾 window 个字符，
+// 且不在连续的邮箱或数字字符中间截断；跨越截断点的匹配整体留到下一次。
+// 截断点对齐到 token 边界，输出的文本在不落在匹配内部的 token 边界处分段，
+// 每段对应至少一个上游 token，使下游按段计数时与上游 token 数一致
+func (r *redactor) emit(final bool) []piece {
+	cut := len(r.pending)
+	if !final {
+		cut = r.safeCut()
+	}
+	matches := r.matches()
+	for {
+		for _, m := range matches {
+			if m.start >= cut {
+				break
+			}
+			if m.end > cut {
+				cut = m.start
+				break
+			}
+		}
+		aligned := 0
+		for _, b := range r.bounds {
+			if b.end > cut {
+				break
+			}
+			aligned = b.end
+		}
+		if aligned == cut {
+			break
+		}
+		cut = aligned
+	}
+	if cut == 0 {
+		return nil
+	}
+
+	var pieces []piece
+	pos, n := 0, 0
+	for _, b := range r.bounds {
+		if b.end > cut {
+			break
+		}
+		n += b.tokens
+		if inMatch(matches, b.end) {
+			continue
+		}
+		pieces = append(pieces, r.redact(pos, b.end, matches, n))
+		pos, n = b.end, 0
+	}
+
+	r.prev, _ = utf8.DecodeLastRuneInString(r.pending[:cut])
+	r.pending = r.pending[cut:]
+	kept := r.bounds[:0]
+	for _, b := range r.bounds {
+		if b.end > cut {
+			kept = append(kept, tokenBound{end: b.end - cut, tokens: b.tokens})
+		}
+	}
+	r.bounds = kept
+	return pieces
+}
+
+// inMatch 位置 i 是否落在某处匹配内部
+func inMatch(matches []match, i int) bool {
+	for _, m := range matches {
+		if m.start >= i {
+			return false
+		}
+		if m.end > i {
+			return true
+		}
+	}
+	return false
+}
+
+// redact 隐去 pending[start:end] 中的匹配，调用方保证匹配不跨越 start 与 end
+func (r *redactor) redact(start, end int, matches []match, tokens int) piece {
+	var b strings.Builder
+	p := piece{tokens: tokens}
+	pos := start
+	for _, m := range matches {
+		if m.start < start {
+			continue
+		}
+		if m.start >= end {
+			break
+		}
+		b.WriteString(r.pending[pos:m.start])
+		b.WriteString(r.f.replacement)
+		p.mods = append(p.mods, Moderation{Category: m.rule.category, Rule: m.rule.name, Replacement: r.f.replacement})
+		pos = m.end
+	}
+	b.WriteString(r.pending[pos:end])
+	p.text = b.String()
+	return p
+}
+
+// safeCut 返回可以输出的前缀长度
+func (r *redactor) safeCut() int {
+	cut := len(r.pending)
+	for i := 0; i < r.f.window && cut > 0; i++ {
+		_, size := utf8.DecodeLastRuneInString(r.pending[:cut])
+		cut -= size
+	}
+	for cut > 0 && cut < len(r.pending) && isWordByte(r.pending[cut-1]) && isWordByte(r.pending[cut]) && len(r.pending)-cut < maxHoldBytes {
+		cut--
+	}
+	return cut
+}
+
+// isWordByte 可能构成邮箱、手机号或身份证号的字符
+func isWordByte(c byte) bool {
+	return c >= '0' && c <= '9' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || strings.IndexByte("._%+-@", c) >= 0
+}
+
+// matches 返回 pending 中互不重叠的匹配，按位置排序；重叠时取起点靠前的，起点相同取较长的
+func (r *redactor) matches() []match {
+	var all []match
+	for _, rule := range r.f.rules {
+		for _, loc := range rule.re.FindAllStringIndex(r.pending, -1) {
+			if rule.digitBounded && !r.digitBounded(loc[0], loc[1]) {
+				continue
+			}
+			all = append(all, match{start: loc[0], end: loc[1], rule: rule})
+		}
+	}
+	sort.Slice(all, func(i, j int) bool {
+		if all[i].start != all[j].start {
+			return all[i].start < all[j].start
+		}
+		return all[i].end > all[j].end
+	})
+	var kept []match
+	for _, m := range all {
+		if len(kept) > 0 && m.start < kept[len(kept)-1].end {
+			continue
+		}
+		kept = append(kept, m)
+	}
+	return kept
+}
+
+// digitBounded 匹配前后都不是数字；匹配位于 pending 末尾时后面可能还有数字，
+// 由 emit 的截断规则保证此时不会输出
+func (r *redactor) digitBounded(start, end int) bool {
+	before := r.prev
+	if start > 0 {
+		before, _ = utf8.DecodeLastRuneInString(r.pending[:start])
+	}
+	if before >= '0' && before <= '9' {
+		return false
+	}
+	return end == len(r.pending) || r.pending[end] < '0' || r.pending[end] > '9'
+}
+
+// filterStream 对生成器输出做内容过滤，处置记录随隐去后的内容所在的分片下发。
+// 一个上游 token 释放出的多段文本各自成为一个分片，Tokens 记录每段对应的上游 token 数。
+// 工具调用、切换后端、生成出错或提前结束时立即输出暂存的文本，保证文本不会排到它们之后
+func filterStream(in <-chan Chunk, f *ContentFilter) <-chan Chunk {
+	if f == nil {
+		return in
+	}
+	out := make(chan Chunk, cap(in))
+	go func() {
+		defer close(out)
+		r := f.newRedactor()
+		for chunk := range in {
+			if chunk.Failover != nil {
+				// 切换前已输出的文本先于 failover 事件下发
+				for _, p := range r.Flush() {
+					out <- p.chunk()
+				}
+			}
+			var pieces []piece
+			if chunk.Content != "" {
+				pieces = r.Push(chunk.Content, chunk.tokenCount())
+			}
+			if len(chunk.ToolCalls) > 0 || chunk.Err != nil || chunk.FinishReason != "" {
+				pieces = append(pieces, r.Flush()...)
+			}
+			// 最后一段与原分片的用量、结束原因等一起下发
+			chunk.Content, chunk.Tokens = "", 0
+			for i, p := range pieces {
+				if i == len(pieces)-1 {
+					chunk.Content, chunk.Tokens, chunk.Moderations = p.text, p.tokens, p.mods
+					break
+				}
+				out <- p.chunk()
+			}
+			if !chunk.isEmpty() {
+				out <- chunk
+			}
+		}
+		for _, p := range r.Flush() {
+			out <- p.chunk()
+		}
+	}()
+	return out
+}
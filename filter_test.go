@@ -0,0 +1,146 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func newTestFilter(t *testing.T, blocklist ...string) *ContentFilter {
+	t.Helper()
+	f, err := NewContentFilter(FilterConfig{
+		Enabled:     true,
+		PII:         []string{RuleCNMobile, RuleCNIDCard, RuleEmail},
+		Blocklist:   blocklist,
+		Replacement: "[R]",
+		WindowChars: 8,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return f
+}
+
+// runFilter 将 tokens 逐个作为分片经过 filterStream，最后一个分片带结束原因，
+// 返回输出文本、命中的规则与输出分片的 token 数之和
+func runFilter(t *testing.T, f *ContentFilter, tokens []string) (string, []string, int) {
+	t.Helper()
+	in := make(chan Chunk, len(tokens))
+	for i, s := range tokens {
+		c := Chunk{Content: s}
+		if i == len(tokens)-1 {
+			c.FinishReason = DoneStop
+		}
+		in <- c
+	}
+	close(in)
+	var text strings.Builder
+	var rules []string
+	n := 0
+	for c := range filterStream(in, f) {
+		if c.Content != "" && c.Tokens == 0 {
+			t.Errorf("分片 %q 未携带 token 数", c.Content)
+		}
+		text.WriteString(c.Content)
+		n += c.tokenCount()
+		for _, m := range c.Moderations {
+			rules = append(rules, m.Rule)
+		}
+	}
+	return text.String(), rules, n
+}
+
+func TestFilterAcrossTokens(t *testing.T) {
+	f := newTestFilter(t, "secret plan")
+	for _, tc := range []struct {
+		name   string
+		tokens []string
+		want   string
+		rules  []string
+	}{
+		{"手机号跨三个 token", []string{"call 138", "0013", "8000 now"}, "call [R] now", []string{RuleCNMobile}},
+		{"带区号与空格的手机号", []string{"tel +86 1", "38 0013 ", "8000."}, "tel [R].", []string{RuleCNMobile}},
+		{"身份证号", []string{"id 11010519", "491231002", "X ok"}, "id [R] ok", []string{RuleCNIDCard}},
+		{"邮箱", []string{"mail alice.", "smith@exa", "mple.com!"}, "mail [R]!", []string{RuleEmail}},
+		{"屏蔽词跨 token 且不区分大小写", []string{"the SEC", "RET pl", "an is"}, "the [R] is", []string{RuleBlocklist}},
+		{"一个 token 中的两处", []string{"a 13800138000 b x@y.cn c"}, "a [R] b [R] c", []string{RuleCNMobile, RuleEmail}},
+		// 更长数字串中的 11 位不是手机号
+		{"前面紧邻数字", []string{"no 9138", "00138000", "1 end"}, "no 9138001380001 end", nil},
+		{"后面紧邻数字", []string{"n 13800138000", "5 x"}, "n 138001380005 x", nil},
+		{"身份证号后面紧邻数字", []string{"id 1101051949123", "1002", "17 ok"}, "id 1101051949123100217 ok", nil},
+		{"手机号位于末尾", []string{"call 1380013", "8000"}, "call [R]", []string{RuleCNMobile}},
+		{"不含匹配", []string{"hello ", "world"}, "hello world", nil},
+	} {
+		text, rules, n := runFilter(t, f, tc.tokens)
+		if text != tc.want || fmt.Sprint(rules) != fmt.Sprint(tc.rules) {
+			t.Errorf("%s: 输出 %q %v，期望 %q %v", tc.name, text, rules, tc.want, tc.rules)
+		}
+		if n != len(tc.tokens) {
+			t.Errorf("%s: 输出分片的 token 数之和 %d，期望 %d", tc.name, n, len(tc.tokens))
+		}
+	}
+}
+
+// TestRedactorReleasesAtTokenBounds 暂存的文本在 token 边界处分段释放，每段对应一个 token
+func TestRedactorReleasesAtTokenBounds(t *testing.T) {
+	r := newTestFilter(t).newRedactor()
+	var released []piece
+	for i := 0; i < 20; i++ {
+		pieces := r.Push(fmt.Sprintf("w%d ", i), 1)
+		if i >= 10 && len(pieces) == 0 {
+			t.Fatalf("第 %d 个 token 后仍未释放任何文本", i)
+		}
+		released = append(released, pieces...)
+	}
+	released = append(released, r.Flush()...)
+	if len(released) != 20 {
+		t.Fatalf("释放 %d 段，期望每个 token 一段", len(released))
+	}
+	for i, p := range released {
+		if want := fmt.Sprintf("w%d ", i); p.text != want || p.tokens != 1 {
+			t.Errorf("第 %d 段 = %q（%d 个 token），期望 %q（1 个 token）", i, p.text, p.tokens, want)
+		}
+	}
+}
+
+// TestRedactorLongWordRun 很长的无分隔文本超过 maxHoldBytes 后按 token 边界释放，不等到生成结束
+func TestRedactorLongWordRun(t *testing.T) {
+	r := newTestFilter(t).newRedactor()
+	pushed, released := 0, 0
+	for i := 0; pushed < 2*maxHoldBytes; i++ {
+		tok := fmt.Sprintf("token-%d", i)
+		pushed += len(tok)
+		for _, p := range r.Push(tok, 1) {
+			if !strings.HasPrefix(p.text, "token-") || p.tokens != 1 {
+				t.Fatalf("释放的段 %q（%d 个 token）不是完整的 token", p.text, p.tokens)
+			}
+			released += len(p.text)
+		}
+	}
+	if pushed-released > maxHoldBytes+len("token-100") {
+		t.Errorf("已推入 %d 字节，暂存 %d 字节，超过 maxHoldBytes", pushed, pushed-released)
+	}
+}
+
+// TestFilterMergedTokens 匹配跨越的 token 合为一段，段的 token 数为被合并的上游 token 数之和
+func TestFilterMergedTokens(t *testing.T) {
+	in := make(chan Chunk, 5)
+	in <- Chunk{Content: "call ", Tokens: 2} // limitStream 暂存后一起释放的两个 token
+	in <- Chunk{Content: "138"}
+	in <- Chunk{Content: "0013"}
+	in <- Chunk{Content: "8000", Tokens: 3}
+	in <- Chunk{Content: " ok", FinishReason: DoneStop}
+	close(in)
+	var pieces []string
+	total := 0
+	for c := range filterStream(in, newTestFilter(t)) {
+		pieces = append(pieces, fmt.Sprintf("%s/%d", c.Content, c.tokenCount()))
+		total += c.tokenCount()
+	}
+	if want := []string{"call /2", "[R]/5", " ok/1"}; fmt.Sprint(pieces) != fmt.Sprint(want) {
+		t.Errorf("分片 %v，期望 %v", pieces, want)
+	}
+	if total != 8 {
+		t.Errorf("token 数之和 %d，期望 8", total)
+	}
+}
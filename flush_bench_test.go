@@ -0,0 +1,123 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"net/http"
+	"testing"
+	"time"
+)
+
+// 对比逐 token flush 与合并 flush 的写系统调用次数与内存分配：
+//
+//	go test -run '^$' -bench Flush
+
+// benchTokens 每次基准迭代模拟的一条流中的 token 数
+const benchTokens = 256
+
+// syscallCounter 统计写到底层连接的次数，每次 Write 对应一次 write 系统调用
+type syscallCounter struct {
+	writes int
+}
+
+func (c *syscallCounter) Write(p []byte) (int, error) {
+	c.writes++
+	return len(p), nil
+}
+
+// benchResponseWriter 模拟 net/http 的响应：写入先进入 4KB 缓冲区，flush 时才写到连接
+type benchResponseWriter struct {
+	header http.Header
+	conn   *syscallCounter
+	buf    *bufio.Writer
+}
+
+func newBenchResponseWriter() *benchResponseWriter {
+	conn := &syscallCounter{}
+	return &benchResponseWriter{header: make(http.Header), conn: conn, buf: bufio.NewWriterSize(conn, 4096)}
+}
+
+func (w *benchResponseWriter) Header() http.Header         { return w.header }
+func (w *benchResponseWriter) WriteHeader(int)             {}
+func (w *benchResponseWriter) Write(p []byte) (int, error) { return w.buf.Write(p) }
+func (w *benchResponseWriter) WriteString(s string) (int, error) {
+	return w.buf.WriteString(s)
+}
+func (w *benchResponseWriter) FlushError() error { return w.buf.Flush() }
+func (w *benchResponseWriter) Flush()            { w.buf.Flush() }
+
+func benchEvents() []StreamEvent {
+	events := make([]StreamEvent, benchTokens)
+	for i := range events {
+		events[i] = NewStreamEvent(EventToken, &TokenData{V: EventProtocolVersion, Index: i, Content: fmt.Sprintf("token-%d", i)})
+	}
+	return events
+}
+
+// BenchmarkFlushFprintf 旧实现：经 fmt.Fprintf 格式化写出，每个 token 都 flush
+func BenchmarkFlushFprintf(b *testing.B) {
+	events := benchEvents()
+	ids := benchIDs(len(events))
+	b.ReportAllocs()
+	b.ResetTimer()
+
+	syscalls := 0
+	for i := 0; i < b.N; i++ {
+		w := newBenchResponseWriter()
+		for j, ev := range events {
+			fmt.Fprintf(w, "id: %s\nevent: %s\ndata: %s\n\n", ids[j], ev.Type, ev.Data)
+			w.Flush()
+		}
+		syscalls += w.conn.writes
+	}
+	b.ReportMetric(float64(syscalls)/float64(b.N), "syscalls/op")
+	b.ReportMetric(benchTokens, "flushes/op")
+}
+
+// BenchmarkFlushEveryToken sseWriter 不合并，每批事件都立即 flush
+func BenchmarkFlushEveryToken(b *testing.B) {
+	benchmarkFlush(b, DeliveryConfig{}, time.Millisecond)
+}
+
+// BenchmarkFlushCoalesced sseWriter 按 20ms / 4KB 合并，token 间隔不同时的效果
+func BenchmarkFlushCoalesced(b *testing.B) {
+	coalesced := DeliveryConfig{FlushIntervalMs: 20, FlushBytes: 4096}
+	for _, gap := range []time.Duration{time.Millisecond, 5 * time.Millisecond, 50 * time.Millisecond} {
+		b.Run(fmt.Sprintf("gap=%s", gap), func(b *testing.B) {
+			benchmarkFlush(b, coalesced, gap)
+		})
+	}
+}
+
+func benchIDs(n int) []string {
+	ids := make([]string, n)
+	for i := range ids {
+		ids[i] = formatEventID("gen-bench", i)
+	}
+	return ids
+}
+
+// benchmarkFlush 模拟 token 每 tokenGap 到达一次、每个 token 单独唤醒写循环的流
+func benchmarkFlush(b *testing.B, cfg DeliveryConfig, tokenGap time.Duration) {
+	events := benchEvents()
+	ids := benchIDs(len(events))
+	b.ReportAllocs()
+	b.ResetTimer()
+
+	syscalls, flushes := 0, 0
+	for i := 0; i < b.N; i++ {
+		w := newBenchResponseWriter()
+		sw := newSSEWriter(w, cfg)
+		now := time.Now()
+		for j, ev := range events {
+			sw.Write(ids[j], ev)
+			sw.MaybeFlush(now)
+			now = now.Add(tokenGap)
+		}
+		sw.Flush()
+		syscalls += w.conn.writes
+		flushes += sw.flushes
+	}
+	b.ReportMetric(float64(syscalls)/float64(b.N), "syscalls/op")
+	b.ReportMetric(float64(flushes)/float64(b.N), "flushes/op")
+}
@@ -0,0 +1,327 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"ai-answer-demo/tracing"
+
+	"github.com/cloudwego/eino/schema"
+)
+
+// Generation 一次独立于 HTTP 请求生命周期的生成过程，
+// 缓存已产出的事件作为回放缓冲区，客户端断线重连后可从断点继续
+type Generation struct {
+	ID string
+
+	mu          sync.Mutex
+	events      []StreamEvent
+	tokenEvents int // 已追加的 token 事件数
+	tokens      int // 已生成的上游 token 数
+	content     strings.Builder
+	reason      DoneReason
+	done        bool
+	doneCh      chan struct{}
+	notify      chan struct{} // 每次有新事件或生成结束时关闭并替换，用于广播
+	subscribers int
+	idleTimer   *time.Timer
+	cancel      context.CancelFunc
+	request     *StreamRequest
+	key         string // 去重键，为空表示不参与去重
+	tenant      string // 发起生成的租户，只有同一租户可以订阅、续传或复用
+}
+
+// Next 返回从 from 开始的已缓冲事件、生成是否已结束，以及下一次状态变化的通知 channel
+func (g *Generation) Next(from int) ([]StreamEvent, bool, <-chan struct{}) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if from > len(g.events) {
+		from = len(g.events)
+	}
+	return g.events[from:], g.done, g.notify
+}
+
+func (g *Generation) append(ev StreamEvent) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.events = append(g.events, ev)
+	close(g.notify)
+	g.notify = make(chan struct{})
+}
+
+// appendToken 追加代表 n 个上游 token 的 token 事件，index 为该事件在本次生成的 token 事件中的序号
+func (g *Generation) appendToken(content string, n int) {
+	g.mu.Lock()
+	index := g.tokenEvents
+	g.tokenEvents++
+	g.tokens += n
+	g.content.WriteString(content)
+	g.mu.Unlock()
+	ev := NewStreamEvent(EventToken, &TokenData{V: EventProtocolVersion, Index: index, Content: content})
+	ev.tokens = n
+	g.append(ev)
+}
+
+// finish 追加 usage 与 done 事件并标记生成结束
+func (g *Generation) finish(usage *schema.TokenUsage, reason DoneReason) {
+	g.mu.Lock()
+	completion := g.tokens
+	g.mu.Unlock()
+
+	data := &UsageData{V: EventProtocolVersion, CompletionTokens: completion, TotalTokens: completion}
+	if usage != nil {
+		data.PromptTokens = usage.PromptTokens
+		data.CompletionTokens = usage.CompletionTokens
+		data.TotalTokens = usage.TotalTokens
+	}
+	g.append(NewStreamEvent(EventUsage, data))
+	g.append(NewStreamEvent(EventDone, &DoneData{V: EventProtocolVersion, Reason: reason}))
+
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.done = true
+	g.reason = reason
+	close(g.notify)
+	g.notify = make(chan struct{})
+	close(g.doneCh)
+}
+
+// Done 返回生成结束时关闭的 channel
+func (g *Generation) Done() <-chan struct{} {
+	return g.doneCh
+}
+
+// Result 返回已生成的完整文本与结束原因，生成未结束时原因为空
+func (g *Generation) Result() (string, DoneReason) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	return g.content.String(), g.reason
+}
+
+// Messages 返回生成的对话输入
+func (g *Generation) Messages() []*schema.Message {
+	return g.request.Messages
+}
+
+// GenerationRegistry 管理进行中与刚结束的生成，
+// 没有订阅者的生成在宽限期内保留，超时后取消并回收
+type GenerationRegistry struct {
+	pipeline    *StreamPipeline
+	lifecycle   *ServerLifecycle
+	gracePeriod time.Duration
+	dedupe      bool
+	delivery    DeliveryConfig
+
+	mu          sync.Mutex
+	generations map[string]*Generation
+	inflight    map[string]*Generation // 按输入去重的进行中生成
+}
+
+func NewGenerationRegistry(pipeline *StreamPipeline, lifecycle *ServerLifecycle, gracePeriod time.Duration, dedupe bool, delivery DeliveryConfig) *GenerationRegistry {
+	return &GenerationRegistry{
+		pipeline:    pipeline,
+		lifecycle:   lifecycle,
+		gracePeriod: gracePeriod,
+		dedupe:      dedupe,
+		delivery:    delivery,
+		generations: make(map[string]*Generation),
+		inflight:    make(map[string]*Generation),
+	}
+}
+
+// Start 将一次新的生成按 adm 的优先级与租户提交到 StreamPipeline 排队，生成使用独立的 context，
+// 不随请求结束而取消；开启去重时，输入完全相同的同优先级并发请求共享同一个进行中的生成（singleflight），
+// 避免高优先级请求等待在低优先级的排队生成上。
+// reqCtx 只用于关联追踪：生成的 span 挂在请求的 span 之下，共享生成时只属于发起它的请求。
+// 队列已满时返回 ErrQueueFull，服务关闭中返回 ErrShuttingDown
+func (r *GenerationRegistry) Start(reqCtx context.Context, messages []*schema.Message, opts *GenerateOptions, adm Admission) (*Generation, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	span := tracing.SpanFromContext(reqCtx)
+	var key string
+	if r.dedupe {
+		// 不同租户的额度不同（opts.Budget），且不应读到彼此的输出，不跨租户复用
+		key = generationKey(messages, opts) + ":" + string(adm.Class) + ":" + adm.Tenant
+		if gen, ok := r.inflight[key]; ok {
+			metrics.DedupedGenerations.Inc()
+			span.SetAttrs(tracing.String("generation.id", gen.ID), tracing.Bool("generation.deduped", true))
+			return gen, nil
+		}
+	}
+
+	ctx, cancel := context.WithCancel(context.WithoutCancel(reqCtx))
+	gen := &Generation{
+		ID:     newID("gen-"),
+		notify: make(chan struct{}),
+		doneCh: make(chan struct{}),
+		cancel: cancel,
+		key:    key,
+		tenant: adm.Tenant,
+	}
+	gen.request = &StreamRequest{
+		ID:       gen.ID,
+		Ctx:      ctx,
+		Messages: messages,
+		Options:  opts,
+		Class:    adm.Class,
+		Tenant:   adm.Tenant,
+		Client:   adm.Client,
+		Output:   make(chan *StreamResponse, 10),
+	}
+	if err := r.pipeline.Submit(gen.request); err != nil {
+		cancel()
+		return nil, err
+	}
+
+	span.SetAttrs(tracing.String("generation.id", gen.ID))
+	r.generations[gen.ID] = gen
+	if key != "" {
+		r.inflight[key] = gen
+	}
+
+	go func() {
+		defer cancel()
+		var usage *schema.TokenUsage
+		reason := DoneStop
+		for res := range gen.request.Output {
+			for _, f := range res.Failovers {
+				gen.append(NewStreamEvent(EventFailover, newFailoverData(f)))
+			}
+			for _, m := range res.Moderations {
+				gen.append(NewStreamEvent(EventModeration, newModerationData(m)))
+			}
+			for i, token := range res.Tokens {
+				gen.appendToken(token, res.TokenCounts[i])
+			}
+			for _, call := range res.ToolCalls {
+				gen.append(NewStreamEvent(EventToolCall, newToolCallData(call)))
+			}
+			if res.Err != nil {
+				gen.append(NewStreamEvent(EventError, &ErrorData{V: EventProtocolVersion, Code: res.ErrCode, Message: res.Err.Error()}))
+			}
+			if res.Final {
+				usage, reason = res.Usage, res.FinishReason
+			}
+		}
+		r.forget(gen)
+		gen.finish(usage, reason)
+		r.scheduleIdle(gen)
+	}()
+	return gen, nil
+}
+
+// forget 将已结束的生成移出去重表，之后相同输入会触发新的生成
+func (r *GenerationRegistry) forget(gen *Generation) {
+	if gen.key == "" {
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.inflight[gen.key] == gen {
+		delete(r.inflight, gen.key)
+	}
+}
+
+// generationKey 计算对话输入与生成参数的去重键
+func generationKey(messages []*schema.Message, opts *GenerateOptions) string {
+	h := sha256.New()
+	for _, m := range messages {
+		fmt.Fprintf(h, "%s\x00%s\x00", m.Role, m.Content)
+	}
+	io.WriteString(h, opts.key())
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// QueuePosition 返回生成在 StreamPipeline 中的排队位置，0 表示已开始生成
+func (r *GenerationRegistry) QueuePosition(gen *Generation) int {
+	return r.pipeline.Position(gen.request)
+}
+
+// Get 按 ID 查找租户 tenant 发起的生成，其他租户的生成视为不存在
+func (r *GenerationRegistry) Get(id, tenant string) (*Generation, bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	gen, ok := r.generations[id]
+	if !ok || gen.tenant != tenant {
+		return nil, false
+	}
+	return gen, true
+}
+
+// Attach 登记一个订阅者，取消待执行的回收
+func (r *GenerationRegistry) Attach(gen *Generation) {
+	gen.mu.Lock()
+	defer gen.mu.Unlock()
+	gen.subscribers++
+	if gen.idleTimer != nil {
+		gen.idleTimer.Stop()
+		gen.idleTimer = nil
+	}
+}
+
+// Detach 注销一个订阅者，最后一个订阅者离开后开始宽限期计时
+func (r *GenerationRegistry) Detach(gen *Generation) {
+	gen.mu.Lock()
+	gen.subscribers--
+	gen.mu.Unlock()
+	r.scheduleIdle(gen)
+}
+
+// Cancel 在生成没有其他订阅者时立即取消，返回是否已取消；
+// 去重共享的生成仍有其他订阅者时不受影响，由调用方自行停止接收
+func (r *GenerationRegistry) Cancel(gen *Generation) bool {
+	gen.mu.Lock()
+	idle := gen.subscribers == 0
+	gen.mu.Unlock()
+	if idle {
+		gen.cancel()
+	}
+	return idle
+}
+
+// scheduleIdle 在没有订阅者时启动宽限期计时，到期后取消生成并释放回放缓冲区
+func (r *GenerationRegistry) scheduleIdle(gen *Generation) {
+	gen.mu.Lock()
+	defer gen.mu.Unlock()
+	if gen.subscribers > 0 || gen.idleTimer != nil {
+		return
+	}
+	gen.idleTimer = time.AfterFunc(r.gracePeriod, func() {
+		gen.mu.Lock()
+		idle := gen.subscribers == 0
+		gen.mu.Unlock()
+		if !idle {
+			return
+		}
+		gen.cancel()
+		r.mu.Lock()
+		delete(r.generations, gen.ID)
+		r.mu.Unlock()
+	})
+}
+
+// formatEventID 生成 SSE 事件 ID，格式为 <generationID>:<序号>
+func formatEventID(genID string, seq int) string {
+	return fmt.Sprintf("%s:%d", genID, seq)
+}
+
+// parseEventID 解析 Last-Event-ID，返回生成 ID 与最后收到的序号
+func parseEventID(id string) (string, int, bool) {
+	i := strings.LastIndexByte(id, ':')
+	if i <= 0 {
+		return "", 0, false
+	}
+	seq, err := strconv.Atoi(id[i+1:])
+	if err != nil || seq < 0 {
+		return "", 0, false
+	}
+	return id[:i], seq, true
+}
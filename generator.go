@@ -0,0 +1,243 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/cloudwego/eino-ext/components/model/ollama"
+	"github.com/cloudwego/eino-ext/components/model/openai"
+	"github.com/cloudwego/eino/components/model"
+	"github.com/cloudwego/eino/schema"
+)
+
+// ModelGenerator 流式生成器接口，SSEHandler 与 StreamPipeline 均通过它获取 token
+type ModelGenerator interface {
+	// Stream 根据对话消息与生成参数按分片流式输出生成结果，ctx 取消后应尽快关闭返回的 channel。
+	// opts 不为 nil；max_tokens 与停止序列另由 StreamPipeline 统一兜底
+	Stream(ctx context.Context, messages []*schema.Message, opts *GenerateOptions) <-chan Chunk
+}
+
+// Chunk 生成器输出的一个分片
+type Chunk struct {
+	Content   string
+	ToolCalls []schema.ToolCall
+	Usage     *schema.TokenUsage // 后端在流末尾返回的用量统计
+	Err       error              // 非空表示生成失败，且是 channel 中的最后一个分片
+
+	FinishReason DoneReason // 因 max_tokens 或停止序列提前结束时设置
+	// 内容过滤对 Content 所做的处置，由 filterStream 设置
+	Moderations []Moderation
+	// Content 对应的上游 token 数，由 filterStream 设置；为 0 时 Content 按一个 token 计
+	Tokens int
+	// 多后端路由在生成中途切换了后端，之后的分片来自新后端
+	Failover *Failover
+}
+
+// isEmpty 分片不携带任何需要下发的信息
+func (c Chunk) isEmpty() bool {
+	return c.Content == "" && len(c.ToolCalls) == 0 && c.Usage == nil && c.Err == nil &&
+		c.FinishReason == "" && len(c.Moderations) == 0 && c.Failover == nil && c.Tokens == 0
+}
+
+// tokenCount Content 计入用量的 token 数
+func (c Chunk) tokenCount() int {
+	if c.Tokens > 0 {
+		return c.Tokens
+	}
+	if c.Content != "" {
+		return 1
+	}
+	return 0
+}
+
+// GeneratorType 定义支持的生成器类型
+type GeneratorType string
+
+const (
+	GeneratorMock   GeneratorType = "mock"
+	GeneratorOllama GeneratorType = "ollama"
+	GeneratorOpenAI GeneratorType = "openai"
+	// 模拟按步批处理的后端，用于评估连续批处理对延迟的影响，见 BatchingConfig
+	GeneratorMockBatched GeneratorType = "mock_batched"
+	// 在多个后端之间路由并故障切换，见 RouterConfig
+	GeneratorRouter GeneratorType = "router"
+)
+
+// NewGenerator 根据配置创建对应的生成器
+func NewGenerator(ctx context.Context, cfg GeneratorConfig) (ModelGenerator, error) {
+	switch cfg.Type {
+	case GeneratorMock, "":
+		return &MockGenerator{bufferSize: cfg.BufferSize}, nil
+	case GeneratorMockBatched:
+		b := cfg.Batching
+		mock := NewMockBatchedGenerator(b.step(), b.perSequence(), cfg.BufferSize)
+		if b.MaxBatch <= 1 {
+			return mock, nil
+		}
+		return NewContinuousBatcher(mock, b.MaxBatch, cfg.BufferSize), nil
+	case GeneratorOllama:
+		chatModel, err := ollama.NewChatModel(ctx, &ollama.ChatModelConfig{
+			BaseURL: cfg.BaseURL,
+			Model:   cfg.Model,
+		})
+		if err != nil {
+			return nil, err
+		}
+		return &ChatModelGenerator{
+			chatModel:  chatModel,
+			bufferSize: cfg.BufferSize,
+			withSeed:   ollama.WithSeed,
+			probe:      &httpProbe{url: strings.TrimSuffix(cfg.BaseURL, "/") + "/api/tags"},
+		}, nil
+	case GeneratorOpenAI:
+		chatModel, err := openai.NewChatModel(ctx, &openai.ChatModelConfig{
+			BaseURL: cfg.BaseURL,
+			Model:   cfg.Model,
+			APIKey:  cfg.APIKey,
+		})
+		if err != nil {
+			return nil, err
+		}
+		probe := &httpProbe{url: strings.TrimSuffix(cfg.BaseURL, "/") + "/models", header: http.Header{}}
+		if cfg.APIKey != "" {
+			probe.header.Set("Authorization", "Bearer "+cfg.APIKey)
+		}
+		return &ChatModelGenerator{chatModel: chatModel, bufferSize: cfg.BufferSize, probe: probe}, nil
+	case GeneratorRouter:
+		backends := make([]RouterBackend, 0, len(cfg.Router.Backends))
+		for _, b := range cfg.Router.Backends {
+			if b.BufferSize == 0 {
+				b.BufferSize = cfg.BufferSize
+			}
+			if b.Type == GeneratorMockBatched && b.Batching == (BatchingConfig{}) {
+				b.Batching = cfg.Batching
+			}
+			gen, err := NewGenerator(ctx, b.GeneratorConfig)
+			if err != nil {
+				return nil, fmt.Errorf("后端 %s: %w", b.Name, err)
+			}
+			backends = append(backends, RouterBackend{Name: b.Name, Weight: b.Weight, Generator: gen})
+		}
+		if len(backends) == 0 {
+			return nil, errors.New("generator.router.backends 为空")
+		}
+		return NewRouter(cfg.Router, backends, cfg.BufferSize), nil
+	default:
+		return nil, fmt.Errorf("unsupported generator type: %s", cfg.Type)
+	}
+}
+
+// ChatModelGenerator 基于 eino ChatModel.Stream 的生成器
+type ChatModelGenerator struct {
+	chatModel  model.BaseChatModel
+	bufferSize int
+	withSeed   func(int) model.Option // 后端支持按请求设置 seed 时非空
+	probe      HealthProber
+}
+
+// Probe 请求后端的模型列表接口，供路由做健康检查
+func (g *ChatModelGenerator) Probe(ctx context.Context) error {
+	return g.probe.Probe(ctx)
+}
+
+// callOptions 将生成参数转换为 eino 调用选项
+func (g *ChatModelGenerator) callOptions(opts *GenerateOptions) []model.Option {
+	var callOpts []model.Option
+	if opts.MaxTokens > 0 {
+		callOpts = append(callOpts, model.WithMaxTokens(opts.MaxTokens))
+	}
+	if len(opts.Stop) > 0 {
+		callOpts = append(callOpts, model.WithStop(opts.Stop))
+	}
+	if opts.Temperature != nil {
+		callOpts = append(callOpts, model.WithTemperature(*opts.Temperature))
+	}
+	if opts.TopP != nil {
+		callOpts = append(callOpts, model.WithTopP(*opts.TopP))
+	}
+	if opts.Model != "" {
+		callOpts = append(callOpts, model.WithModel(opts.Model))
+	}
+	if opts.Seed != nil && g.withSeed != nil {
+		callOpts = append(callOpts, g.withSeed(*opts.Seed))
+	}
+	return callOpts
+}
+
+func (g *ChatModelGenerator) Stream(ctx context.Context, messages []*schema.Message, opts *GenerateOptions) <-chan Chunk {
+	out := make(chan Chunk, g.bufferSize)
+	go func() {
+		defer close(out)
+
+		reader, err := g.chatModel.Stream(ctx, messages, g.callOptions(opts)...)
+		if err != nil {
+			warnf("模型调用失败: %v", err)
+			out <- Chunk{Err: err}
+			return
+		}
+		defer reader.Close()
+
+		for {
+			msg, err := reader.Recv()
+			if errors.Is(err, io.EOF) {
+				return
+			}
+			if err != nil {
+				warnf("模型流读取失败: %v", err)
+				if ctx.Err() == nil {
+					out <- Chunk{Err: err}
+				}
+				return
+			}
+
+			chunk := Chunk{Content: msg.Content, ToolCalls: msg.ToolCalls}
+			if msg.ResponseMeta != nil {
+				chunk.Usage = msg.ResponseMeta.Usage
+			}
+			// 跳过只携带元信息的空分片
+			if chunk.Content == "" && len(chunk.ToolCalls) == 0 && chunk.Usage == nil {
+				continue
+			}
+
+			select {
+			case <-ctx.Done():
+				debugf("生成中断")
+				return
+			case out <- chunk:
+			}
+		}
+	}()
+	return out
+}
+
+// 模拟大模型生成器，无需任何外部服务即可离线运行；除 max_tokens 外的采样参数均被忽略
+type MockGenerator struct {
+	bufferSize int
+}
+
+func (m *MockGenerator) Stream(ctx context.Context, messages []*schema.Message, opts *GenerateOptions) <-chan Chunk {
+	length := 50 // 默认模拟50个token生成
+	if opts.MaxTokens > 0 {
+		length = opts.MaxTokens
+	}
+
+	out := make(chan Chunk, m.bufferSize)
+	go func() {
+		defer close(out)
+		for i := 0; i < length; i++ {
+			select {
+			case <-ctx.Done():
+				debugf("生成中断")
+				return
+			case <-time.After(100 * time.Millisecond): // 模拟计算延迟
+				out <- Chunk{Content: fmt.Sprintf("token-%d", i)}
+			}
+		}
+	}()
+	return out
+}
@@ -0,0 +1,713 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"ai-answer-demo/tracing"
+
+	"github.com/cloudwego/eino/schema"
+)
+
+// 异步生成任务：无法保持 SSE 长连接的调用方（如批处理系统）以 POST /jobs 提交生成，
+// 之后轮询 GET /jobs/{id} 获取状态与已生成的内容，或由回调地址接收最终结果。
+// 每个任务一个 JSON 文件，服务重启后未完成的任务重新执行，未送达的回调继续重试。
+//
+// 回调以 POST 发送任务的 JSON，请求头携带签名，接收方按同样方式计算并比较：
+//
+//	X-Job-Timestamp: <unix 秒>
+//	X-Job-Signature: sha256=<hex(HMAC-SHA256(secret, timestamp + "." + body))>
+//
+// 回调至少送达一次，重试与重启后可能重复，接收方应按 X-Job-ID 去重
+
+// JobStatus 任务状态
+type JobStatus string
+
+const (
+	JobQueued    JobStatus = "queued"
+	JobRunning   JobStatus = "running"
+	JobSucceeded JobStatus = "succeeded"
+	JobFailed    JobStatus = "failed"
+	JobCancelled JobStatus = "cancelled"
+)
+
+// CallbackState 回调的投递状态
+type CallbackState string
+
+const (
+	CallbackPending   CallbackState = "pending"
+	CallbackDelivered CallbackState = "delivered"
+	CallbackFailed    CallbackState = "failed" // 重试次数用尽或接收方返回不可重试的错误
+)
+
+// 回调请求头
+const (
+	JobIDHeader        = "X-Job-ID"
+	JobTimestampHeader = "X-Job-Timestamp"
+	JobSignatureHeader = "X-Job-Signature"
+	JobAttemptHeader   = "X-Job-Attempt"
+)
+
+// ErrJobNotFound 任务不存在
+var ErrJobNotFound = errors.New("job not found")
+
+// Job 一个异步生成任务，同时是任务文件、GET /jobs/{id} 响应与回调请求体的格式
+type Job struct {
+	ID          string          `json:"id"`
+	Status      JobStatus       `json:"status"`
+	Prompt      string          `json:"prompt,omitempty"`
+	Messages    []ChatMessage   `json:"messages,omitempty"`
+	Options     GenerateOptions `json:"options"`
+	Fixture     string          `json:"fixture,omitempty"`
+	CallbackURL string          `json:"callback_url,omitempty"`
+	Tenant      string          `json:"tenant"`
+	Class       PriorityClass   `json:"class"`
+
+	// 正在执行的生成，可通过 GET /stream/{id}/subscribe 实时订阅
+	GenerationID string `json:"generation_id,omitempty"`
+	// 执行中为已生成的部分，结束后为完整结果
+	Output       string               `json:"output"`
+	FinishReason DoneReason           `json:"finish_reason,omitempty"`
+	Usage        *ChatCompletionUsage `json:"usage,omitempty"`
+	Error        string               `json:"error,omitempty"`
+	// 执行次数，服务重启时中断的任务会重新执行
+	Runs     int          `json:"runs"`
+	Callback *JobCallback `json:"callback,omitempty"`
+
+	CreatedAt  time.Time  `json:"created_at"`
+	StartedAt  *time.Time `json:"started_at,omitempty"`
+	FinishedAt *time.Time `json:"finished_at,omitempty"`
+}
+
+// JobCallback 回调的投递记录
+type JobCallback struct {
+	State       CallbackState `json:"state"`
+	Attempts    int           `json:"attempts"`
+	LastError   string        `json:"last_error,omitempty"`
+	NextAttempt *time.Time    `json:"next_attempt_at,omitempty"`
+}
+
+func (j *Job) finished() bool {
+	return j.Status == JobSucceeded || j.Status == JobFailed || j.Status == JobCancelled
+}
+
+// messages 返回生成器输入
+func (j *Job) messages() []*schema.Message {
+	if len(j.Messages) == 0 {
+		return promptMessages(j.Prompt)
+	}
+	messages, _ := toSchemaMessages(j.Messages)
+	return messages
+}
+
+// jobEntry 内存中的任务：执行中的生成与所属密钥及其用量不落盘
+type jobEntry struct {
+	job   Job
+	gen   *Generation
+	key   *APIKey
+	usage *keyUsage
+}
+
+// JobManager 任务队列：按提交顺序执行，同时执行的任务数受 JobsConfig.Concurrency 限制，
+// 生成本身仍经由 StreamPipeline 按优先级与租户排队
+type JobManager struct {
+	dir       string
+	cfg       JobsConfig
+	secret    []byte
+	registry  *GenerationRegistry
+	lifecycle *ServerLifecycle
+	guard     *callbackGuard
+	client    *http.Client
+
+	mu      sync.Mutex
+	jobs    map[string]*jobEntry
+	queue   []*jobEntry
+	running int
+	wake    chan struct{}
+
+	// 开始排空时取消：不再开始新任务，回调的重试等待中止，未完成的部分留待重启后继续
+	ctx    context.Context
+	cancel context.CancelFunc
+
+	finished  map[JobStatus]*Counter
+	callbacks map[CallbackState]*Counter
+	retries   *Counter
+}
+
+// NewJobManager 加载任务目录，恢复未完成的任务与未送达的回调。
+// auth 非空时恢复的任务重新关联所属密钥，继续计入其每日额度
+func NewJobManager(cfg JobsConfig, registry *GenerationRegistry, lifecycle *ServerLifecycle, auth *Authenticator) (*JobManager, error) {
+	if err := os.MkdirAll(cfg.Dir, 0755); err != nil {
+		return nil, err
+	}
+	cfg.Concurrency = max(cfg.Concurrency, 1)
+	ctx, cancel := context.WithCancel(context.Background())
+	guard := newCallbackGuard(cfg.CallbackAllowedHosts)
+	m := &JobManager{
+		dir:       cfg.Dir,
+		cfg:       cfg,
+		secret:    []byte(cfg.CallbackSecret),
+		registry:  registry,
+		lifecycle: lifecycle,
+		guard:     guard,
+		client:    guard.client(time.Duration(cfg.CallbackTimeoutMs) * time.Millisecond),
+		jobs:      make(map[string]*jobEntry),
+		wake:      make(chan struct{}, 1),
+		ctx:       ctx,
+		cancel:    cancel,
+		finished:  make(map[JobStatus]*Counter),
+		callbacks: make(map[CallbackState]*Counter),
+	}
+	for _, s := range []JobStatus{JobSucceeded, JobFailed, JobCancelled} {
+		m.finished[s] = metrics.Registry.NewCounter("stream_jobs_finished_total", "Async generation jobs finished, by status.", "status", string(s))
+	}
+	for _, s := range []CallbackState{CallbackDelivered, CallbackFailed} {
+		m.callbacks[s] = metrics.Registry.NewCounter("stream_job_callbacks_total", "Job callbacks that reached a final state, by outcome.", "outcome", string(s))
+	}
+	m.retries = metrics.Registry.NewCounter("stream_job_callback_retries_total", "Job callback attempts that failed and were scheduled for retry.")
+	for _, s := range []JobStatus{JobQueued, JobRunning} {
+		metrics.Registry.NewGaugeFunc("stream_jobs", "Async generation jobs not yet finished, by status.", func() float64 {
+			return float64(m.count(s))
+		}, "status", string(s))
+	}
+
+	if err := m.load(auth); err != nil {
+		return nil, err
+	}
+	go func() {
+		<-lifecycle.Draining()
+		cancel()
+	}()
+	go m.dispatch()
+	go m.sweep()
+	return m, nil
+}
+
+// load 读取任务目录：排队中与执行中的任务按提交时间重新排队，待投递的回调继续投递。
+// 启用认证时所属密钥已被删除的任务不再执行，直接标记为失败
+func (m *JobManager) load(auth *Authenticator) error {
+	paths, err := filepath.Glob(filepath.Join(m.dir, "*.json"))
+	if err != nil {
+		return err
+	}
+	var pending []*jobEntry
+	for _, path := range paths {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		e := &jobEntry{}
+		if err := json.Unmarshal(data, &e.job); err != nil {
+			warnf("跳过无法解析的任务文件 %s: %v", path, err)
+			continue
+		}
+		m.jobs[e.job.ID] = e
+		if !e.job.finished() && auth != nil {
+			var ok bool
+			if e.key, e.usage, ok = auth.Lookup(e.job.Tenant); !ok {
+				m.orphan(e)
+			}
+		}
+		switch {
+		case !e.job.finished():
+			e.job.Status, e.job.GenerationID, e.job.Output = JobQueued, "", ""
+			m.queue = append(m.queue, e)
+		case e.job.Callback != nil && e.job.Callback.State == CallbackPending:
+			pending = append(pending, e)
+		}
+	}
+	sort.Slice(m.queue, func(i, j int) bool { return m.queue[i].job.CreatedAt.Before(m.queue[j].job.CreatedAt) })
+	if len(m.jobs) > 0 {
+		infof("已加载 %d 个任务，%d 个待执行，%d 个回调待投递", len(m.jobs), len(m.queue), len(pending))
+	}
+	for _, e := range pending {
+		go m.deliver(e)
+	}
+	return nil
+}
+
+// orphan 将所属密钥已被删除的未完成任务标记为失败
+func (m *JobManager) orphan(e *jobEntry) {
+	now := time.Now()
+	e.job.Status, e.job.GenerationID, e.job.Output = JobFailed, "", ""
+	e.job.FinishReason, e.job.Error = DoneError, "提交任务的 API Key 已被删除"
+	e.job.FinishedAt = &now
+	if e.job.CallbackURL != "" {
+		e.job.Callback = &JobCallback{State: CallbackPending}
+	}
+	if err := m.save(e); err != nil {
+		warnf("保存任务 %s 失败: %v", e.job.ID, err)
+	}
+	m.finished[JobFailed].Inc()
+}
+
+func (m *JobManager) path(id string) string {
+	return filepath.Join(m.dir, id+".json")
+}
+
+// save 写入任务文件，调用方持有 m.mu。先写临时文件再重命名，避免进程中断时留下半个文件
+func (m *JobManager) save(e *jobEntry) error {
+	data, err := json.MarshalIndent(&e.job, "", "  ")
+	if err == nil {
+		path := m.path(e.job.ID)
+		tmp := path + ".tmp"
+		if err = os.WriteFile(tmp, data, 0644); err == nil {
+			err = os.Rename(tmp, path)
+		}
+	}
+	if err != nil {
+		errorf("保存任务 %s 失败: %v", e.job.ID, err)
+	}
+	return err
+}
+
+// Submit 保存并排队一个新任务，任务文件写入失败时不排队
+func (m *JobManager) Submit(job Job, key *APIKey, usage *keyUsage) (Job, error) {
+	if m.ctx.Err() != nil {
+		return Job{}, ErrShuttingDown
+	}
+	job.ID = newID("job-")
+	job.Status = JobQueued
+	job.CreatedAt = time.Now()
+	e := &jobEntry{job: job, key: key, usage: usage}
+
+	m.mu.Lock()
+	if err := m.save(e); err != nil {
+		m.mu.Unlock()
+		return Job{}, err
+	}
+	m.jobs[job.ID] = e
+	m.queue = append(m.queue, e)
+	m.mu.Unlock()
+	m.signal()
+	return job, nil
+}
+
+// Get 返回任务的快照，执行中的任务带有已生成的部分
+func (m *JobManager) Get(id string) (Job, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	e, ok := m.jobs[id]
+	if !ok {
+		return Job{}, ErrJobNotFound
+	}
+	job := e.job
+	if e.gen != nil && job.Status == JobRunning {
+		job.Output, _ = e.gen.Result()
+	}
+	return job, nil
+}
+
+func (m *JobManager) count(status JobStatus) int {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if status == JobQueued {
+		return len(m.queue)
+	}
+	return m.running
+}
+
+func (m *JobManager) signal() {
+	select {
+	case m.wake <- struct{}{}:
+	default:
+	}
+}
+
+// dispatch 在并发上限内按顺序取出任务执行，开始排空后停止
+func (m *JobManager) dispatch() {
+	for {
+		m.mu.Lock()
+		for m.ctx.Err() == nil && m.running < m.cfg.Concurrency && len(m.queue) > 0 {
+			e := m.queue[0]
+			m.queue = m.queue[1:]
+			m.running++
+			go m.run(e)
+		}
+		m.mu.Unlock()
+		select {
+		case <-m.ctx.Done():
+			return
+		case <-m.wake:
+		}
+	}
+}
+
+// run 执行一个任务。StreamPipeline 繁忙时任务回到队首，稍后重试
+func (m *JobManager) run(e *jobEntry) {
+	defer func() {
+		m.mu.Lock()
+		m.running--
+		m.mu.Unlock()
+		m.signal()
+	}()
+	end, ok := m.lifecycle.Begin()
+	if !ok {
+		// 服务开始关闭，任务留在队列中，重启后执行；同时停止分派，不必等待 Draining 通知
+		m.mu.Lock()
+		m.queue = append([]*jobEntry{e}, m.queue...)
+		m.mu.Unlock()
+		m.cancel()
+		return
+	}
+	defer end()
+
+	m.mu.Lock()
+	job := e.job
+	m.mu.Unlock()
+	opts := job.Options
+	opts.Fixture = job.Fixture
+	// 任务可能排队很久，按开始执行时的剩余额度截断
+	opts.Budget = quotaBudget(e.key, e.usage)
+
+	ctx, span := tracing.Start(context.Background(), "job.run", tracing.String("job.id", job.ID), tracing.Int("job.run", job.Runs+1))
+	defer span.End()
+	gen, err := m.registry.Start(ctx, job.messages(), &opts, Admission{Class: job.Class, Tenant: job.Tenant, Client: "job " + job.ID})
+	if err != nil {
+		if !errors.Is(err, ErrShuttingDown) {
+			// 留在队首，等 pipeline 有空位后重试
+			time.AfterFunc(m.registry.pipeline.RetryAfter(), func() {
+				m.mu.Lock()
+				m.queue = append([]*jobEntry{e}, m.queue...)
+				m.mu.Unlock()
+				m.signal()
+			})
+		}
+		return
+	}
+	// 登记为订阅者，生成不会因没有客户端连接而在宽限期后被回收
+	m.registry.Attach(gen)
+	defer m.registry.Detach(gen)
+
+	now := time.Now()
+	m.mu.Lock()
+	e.gen = gen
+	e.job.Status, e.job.GenerationID, e.job.StartedAt = JobRunning, gen.ID, &now
+	e.job.Runs++
+	m.save(e)
+	m.mu.Unlock()
+
+	<-gen.Done()
+	output, reason := gen.Result()
+	usage, errMsg := generationSummary(gen)
+
+	m.mu.Lock()
+	e.gen = nil
+	if reason == DoneCancelled && m.ctx.Err() != nil {
+		// 服务关闭中断了生成，重启后重新执行
+		e.job.Status, e.job.GenerationID, e.job.StartedAt = JobQueued, "", nil
+		m.save(e)
+		m.mu.Unlock()
+		return
+	}
+	finishedAt := time.Now()
+	e.job.Output, e.job.FinishReason, e.job.Usage, e.job.Error = output, reason, usage, errMsg
+	e.job.FinishedAt = &finishedAt
+	switch reason {
+	case DoneError:
+		e.job.Status = JobFailed
+	case DoneCancelled:
+		e.job.Status = JobCancelled
+	default:
+		e.job.Status = JobSucceeded
+	}
+	if e.job.CallbackURL != "" {
+		e.job.Callback = &JobCallback{State: CallbackPending}
+	}
+	m.save(e)
+	status := e.job.Status
+	m.mu.Unlock()
+
+	m.finished[status].Inc()
+	span.SetAttrs(tracing.String("generation.id", gen.ID), tracing.String("job.status", string(status)))
+	if e.usage != nil && usage != nil {
+		e.usage.consume(int64(usage.CompletionTokens))
+	}
+	if e.job.CallbackURL != "" {
+		go m.deliver(e)
+	}
+}
+
+// generationSummary 从生成的事件中取出用量与错误信息
+func generationSummary(gen *Generation) (*ChatCompletionUsage, string) {
+	events, _, _ := gen.Next(0)
+	var usage *ChatCompletionUsage
+	var errMsg string
+	for _, ev := range events {
+		switch ev.Type {
+		case EventUsage:
+			var data UsageData
+			if json.Unmarshal(ev.Data, &data) == nil {
+				usage = &ChatCompletionUsage{PromptTokens: data.PromptTokens, CompletionTokens: data.CompletionTokens, TotalTokens: data.TotalTokens}
+			}
+		case EventError:
+			var data ErrorData
+			if json.Unmarshal(ev.Data, &data) == nil {
+				errMsg = data.Message
+			}
+		}
+	}
+	return usage, errMsg
+}
+
+// callbackBackoff 第 attempt 次投递失败后的等待时间：1s 起指数增长，最长 5 分钟
+func callbackBackoff(attempt int) time.Duration {
+	return min(time.Second<<min(attempt-1, 16), 5*time.Minute)
+}
+
+// deliver 投递回调直到成功、遇到不可重试的错误或次数用尽；排空开始后停止等待，重启后继续
+func (m *JobManager) deliver(e *jobEntry) {
+	for {
+		m.mu.Lock()
+		cb := e.job.Callback
+		if cb == nil || cb.State != CallbackPending {
+			m.mu.Unlock()
+			return
+		}
+		var wait time.Duration
+		if cb.NextAttempt != nil {
+			wait = time.Until(*cb.NextAttempt)
+		}
+		m.mu.Unlock()
+
+		if wait > 0 {
+			select {
+			case <-m.ctx.Done():
+				return
+			case <-time.After(wait):
+			}
+		}
+
+		m.mu.Lock()
+		job := e.job
+		job.Callback = nil
+		attempt := cb.Attempts + 1
+		m.mu.Unlock()
+		retry, err := m.post(job, attempt)
+
+		m.mu.Lock()
+		cb.Attempts = attempt
+		cb.NextAttempt = nil
+		switch {
+		case err == nil:
+			cb.State, cb.LastError = CallbackDelivered, ""
+		case !retry || attempt >= m.cfg.CallbackMaxAttempts:
+			cb.State, cb.LastError = CallbackFailed, err.Error()
+		default:
+			next := time.Now().Add(callbackBackoff(attempt))
+			cb.LastError, cb.NextAttempt = err.Error(), &next
+		}
+		m.save(e)
+		state := cb.State
+		m.mu.Unlock()
+
+		switch state {
+		case CallbackPending:
+			m.retries.Inc()
+			warnf("任务 %s 回调第 %d 次投递失败，稍后重试: %v", job.ID, attempt, err)
+		case CallbackFailed:
+			m.callbacks[state].Inc()
+			errorf("任务 %s 回调投递失败，不再重试: %v", job.ID, err)
+			return
+		default:
+			m.callbacks[state].Inc()
+			return
+		}
+	}
+}
+
+// post 发送一次回调，返回失败时是否值得重试：网络错误、5xx、408 与 429 重试，其余 4xx 不重试
+func (m *JobManager) post(job Job, attempt int) (bool, error) {
+	body, err := json.Marshal(&job)
+	if err != nil {
+		return false, err
+	}
+	req, err := http.NewRequest(http.MethodPost, job.CallbackURL, bytes.NewReader(body))
+	if err != nil {
+		return false, err
+	}
+	ts := strconv.FormatInt(time.Now().Unix(), 10)
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(JobIDHeader, job.ID)
+	req.Header.Set(JobAttemptHeader, strconv.Itoa(attempt))
+	req.Header.Set(JobTimestampHeader, ts)
+	req.Header.Set(JobSignatureHeader, "sha256="+signCallback(m.secret, ts, body))
+
+	resp, err := m.client.Do(req)
+	if err != nil {
+		return true, err
+	}
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+	resp.Body.Close()
+	if resp.StatusCode/100 == 2 {
+		return false, nil
+	}
+	retry := resp.StatusCode >= 500 || resp.StatusCode == http.StatusRequestTimeout || resp.StatusCode == http.StatusTooManyRequests
+	return retry, fmt.Errorf("回调地址返回 %s", resp.Status)
+}
+
+// signCallback 计算回调签名：hex(HMAC-SHA256(secret, timestamp + "." + body))
+func signCallback(secret []byte, timestamp string, body []byte) string {
+	mac := hmac.New(sha256.New, secret)
+	io.WriteString(mac, timestamp)
+	mac.Write([]byte{'.'})
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// sweep 每小时删除超过保留时间的已结束任务；回调仍待投递的任务保留
+func (m *JobManager) sweep() {
+	if m.cfg.RetentionHours <= 0 {
+		return
+	}
+	retention := time.Duration(m.cfg.RetentionHours) * time.Hour
+	ticker := time.NewTicker(time.Hour)
+	defer ticker.Stop()
+	for {
+		m.mu.Lock()
+		for id, e := range m.jobs {
+			j := &e.job
+			if !j.finished() || j.FinishedAt == nil || time.Since(*j.FinishedAt) < retention {
+				continue
+			}
+			if j.Callback != nil && j.Callback.State == CallbackPending {
+				continue
+			}
+			if err := os.Remove(m.path(id)); err != nil && !errors.Is(err, os.ErrNotExist) {
+				errorf("删除任务文件失败: %v", err)
+				continue
+			}
+			delete(m.jobs, id)
+		}
+		m.mu.Unlock()
+		select {
+		case <-m.ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// JobsHandler 异步任务接口
+type JobsHandler struct {
+	jobs *JobManager
+}
+
+// Register 在 mux 上注册任务路由，auth 非空时所有路由都要求认证
+func (h *JobsHandler) Register(mux *http.ServeMux, auth *Authenticator) {
+	mux.Handle("POST /jobs", auth.Require(http.HandlerFunc(h.Create)))
+	mux.Handle("GET /jobs/{id}", auth.Require(http.HandlerFunc(h.Get)))
+}
+
+// CreateJobRequest POST /jobs 请求体：prompt 与 messages 二选一，可携带生成参数与回调地址
+type CreateJobRequest struct {
+	Prompt      string        `json:"prompt"`
+	Messages    []ChatMessage `json:"messages"`
+	CallbackURL string        `json:"callback_url"`
+	GenerateOptions
+}
+
+// Create 处理 POST /jobs：校验后保存并排队，返回 202 与任务
+func (h *JobsHandler) Create(w http.ResponseWriter, r *http.Request) {
+	var req CreateJobRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeJSONError(w, http.StatusBadRequest, "请求体不是合法的 JSON: "+err.Error())
+		return
+	}
+	if (strings.TrimSpace(req.Prompt) == "") == (len(req.Messages) == 0) {
+		writeJSONError(w, http.StatusBadRequest, "prompt 与 messages 必须且只能提供一个")
+		return
+	}
+	if len(req.Messages) > 0 {
+		if _, err := toSchemaMessages(req.Messages); err != nil {
+			writeJSONError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+	}
+	if req.CallbackURL != "" {
+		if err := h.checkCallbackURL(r.Context(), req.CallbackURL); err != nil {
+			writeJSON(w, http.StatusBadRequest, map[string]string{"error": err.Error(), "field": "callback_url"})
+			return
+		}
+	}
+	req.Fixture = r.Header.Get(FixtureHeader)
+	if err := h.jobs.registry.pipeline.ValidateOptions(&req.GenerateOptions); err != nil {
+		writeOptionError(w, err)
+		return
+	}
+	adm, err := admissionFromRequest(r)
+	if err != nil {
+		writeOptionError(w, err)
+		return
+	}
+	// 任务默认以 batch 优先级排队，可通过 X-Priority 请求头在密钥允许的范围内指定
+	if r.Header.Get(PriorityHeader) == "" {
+		adm.Class = PriorityBatch
+	}
+	// 额度已由 auth.Require 在准入时检查
+	key, usage := APIKeyFromContext(r.Context()), usageFromContext(r.Context())
+
+	opts := req.GenerateOptions
+	opts.Fixture = ""
+	job, err := h.jobs.Submit(Job{
+		Prompt:      req.Prompt,
+		Messages:    req.Messages,
+		Options:     opts,
+		Fixture:     req.Fixture,
+		CallbackURL: req.CallbackURL,
+		Tenant:      adm.Tenant,
+		Class:       adm.Class,
+	}, key, usage)
+	if errors.Is(err, ErrShuttingDown) {
+		rejectSubmit(w, err, h.jobs.registry.pipeline)
+		return
+	}
+	if err != nil {
+		writeJSONError(w, http.StatusInternalServerError, "保存任务失败: "+err.Error())
+		return
+	}
+	w.Header().Set("Location", "/jobs/"+job.ID)
+	writeJSON(w, http.StatusAccepted, job)
+}
+
+// checkCallbackURL 回调地址必须是 http(s) 绝对地址且解析到公网地址，且服务端已配置签名密钥
+func (h *JobsHandler) checkCallbackURL(ctx context.Context, raw string) error {
+	if len(h.jobs.secret) == 0 {
+		return errors.New("服务端未配置 jobs.callback_secret，不接受回调地址")
+	}
+	u, err := url.Parse(raw)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Hostname() == "" {
+		return errors.New("callback_url 必须是 http 或 https 绝对地址")
+	}
+	_, err = h.jobs.guard.resolve(ctx, u.Hostname())
+	return err
+}
+
+// Get 处理 GET /jobs/{id}。启用认证时只能查看本密钥提交的任务
+func (h *JobsHandler) Get(w http.ResponseWriter, r *http.Request) {
+	job, err := h.jobs.Get(r.PathValue("id"))
+	if key := APIKeyFromContext(r.Context()); err == nil && key != nil && job.Tenant != key.ID {
+		err = ErrJobNotFound
+	}
+	if err != nil {
+		writeJSONError(w, http.StatusNotFound, err.Error())
+		return
+	}
+	writeJSON(w, http.StatusOK, job)
+}
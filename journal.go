@@ -0,0 +1,342 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/cloudwego/eino/schema"
+)
+
+// ErrRecordNotFound 日志中没有该 ID 的记录
+var ErrRecordNotFound = errors.New("journal record not found")
+
+// JournalRecord 一次生成的完整记录，对应日志文件中的一行
+type JournalRecord struct {
+	ID        string             `json:"id"`
+	Tenant    string             `json:"tenant"` // 发起生成的租户，只有同一租户可以通过 /replay 查看
+	StartedAt time.Time          `json:"started_at"`
+	Prompt    string             `json:"prompt"` // 最后一条用户消息，便于检索
+	Messages  []JournalMessage   `json:"messages"`
+	Options   *GenerateOptions   `json:"options"`
+	Fixture   string             `json:"fixture,omitempty"`
+	Tokens    []JournalToken     `json:"tokens"`
+	ToolCalls []JournalToolCall  `json:"tool_calls,omitempty"`
+	Usage     *schema.TokenUsage `json:"usage,omitempty"`
+	Outcome   DoneReason         `json:"outcome"`
+	Error     string             `json:"error,omitempty"`
+	QueueMs   int64              `json:"queue_ms"`   // 排队等待时间
+	TTFTMs    int64              `json:"ttft_ms"`    // 开始生成到首个 token，无 token 时为 0
+	LatencyMs int64              `json:"latency_ms"` // 开始生成到结束
+}
+
+// JournalMessage 对话输入中的一条消息
+type JournalMessage struct {
+	Role    string `json:"role"`
+	Content string `json:"content"`
+}
+
+// JournalToken 一个 token 及其相对开始生成的时间（毫秒）
+type JournalToken struct {
+	T    int64  `json:"t"`
+	Text string `json:"text"`
+	// 内容过滤把多个上游 token 合为一段时的 token 数，省略表示 1
+	N int `json:"n,omitempty"`
+}
+
+// count 该段文本代表的上游 token 数
+func (t JournalToken) count() int {
+	return max(t.N, 1)
+}
+
+// JournalToolCall 一次工具调用及其相对开始生成的时间（毫秒）
+type JournalToolCall struct {
+	T         int64  `json:"t"`
+	ID        string `json:"id,omitempty"`
+	Name      string `json:"name,omitempty"`
+	Arguments string `json:"arguments,omitempty"`
+}
+
+// Journal 以 JSONL 追加写入每次生成的记录
+type Journal struct {
+	path string
+
+	mu   sync.Mutex
+	file *os.File
+}
+
+// OpenJournal 打开（必要时创建）日志文件
+func OpenJournal(path string) (*Journal, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return nil, err
+	}
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		return nil, err
+	}
+	return &Journal{path: path, file: f}, nil
+}
+
+// Append 写入一条记录，失败时只记录日志，不影响生成
+func (j *Journal) Append(rec *JournalRecord) {
+	data, err := json.Marshal(rec)
+	if err != nil {
+		errorf("编码生成记录失败: %v", err)
+		return
+	}
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	if _, err := j.file.Write(append(data, '\n')); err != nil {
+		errorf("写入生成记录失败: %v", err)
+	}
+}
+
+// Close 关闭日志文件
+func (j *Journal) Close() error {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	return j.file.Close()
+}
+
+// FindRecord 在日志文件中按 ID 查找记录，同一 ID 出现多次时返回最后一条
+func FindRecord(path, id string) (*JournalRecord, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	needle := []byte(`"id":` + strconv.Quote(id))
+	var found *JournalRecord
+	r := bufio.NewReader(f)
+	for {
+		line, err := r.ReadBytes('\n')
+		// 先按字节过滤，只解码可能匹配的行
+		if len(line) > 0 && bytes.Contains(line, needle) {
+			var rec JournalRecord
+			if json.Unmarshal(line, &rec) == nil && rec.ID == id {
+				found = &rec
+			}
+		}
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+	if found == nil {
+		return nil, ErrRecordNotFound
+	}
+	return found, nil
+}
+
+// journalEntry 记录进行中的一次生成
+type journalEntry struct {
+	journal *Journal
+	rec     *JournalRecord
+	start   time.Time
+}
+
+// begin 在 worker 开始处理请求时创建记录，j 为 nil 时返回 nil
+func (j *Journal) begin(req *StreamRequest, messages []*schema.Message, opts *GenerateOptions) *journalEntry {
+	if j == nil {
+		return nil
+	}
+	now := time.Now()
+	rec := &JournalRecord{
+		ID:        req.ID,
+		Tenant:    req.Tenant,
+		StartedAt: now,
+		Options:   opts,
+		Fixture:   opts.Fixture,
+		Tokens:    []JournalToken{},
+		QueueMs:   now.Sub(req.submitted).Milliseconds(),
+	}
+	for _, m := range messages {
+		rec.Messages = append(rec.Messages, JournalMessage{Role: string(m.Role), Content: m.Content})
+		if m.Role == schema.User {
+			rec.Prompt = m.Content
+		}
+	}
+	return &journalEntry{journal: j, rec: rec, start: now}
+}
+
+// chunk 记录一个已下发的分片
+func (e *journalEntry) chunk(c Chunk) {
+	if e == nil {
+		return
+	}
+	t := time.Since(e.start).Milliseconds()
+	if c.Content != "" || c.Tokens > 0 {
+		if len(e.rec.Tokens) == 0 {
+			e.rec.TTFTMs = t
+		}
+		tok := JournalToken{T: t, Text: c.Content}
+		if n := c.tokenCount(); n > 1 {
+			tok.N = n
+		}
+		e.rec.Tokens = append(e.rec.Tokens, tok)
+	}
+	for _, call := range c.ToolCalls {
+		e.rec.ToolCalls = append(e.rec.ToolCalls, JournalToolCall{T: t, ID: call.ID, Name: call.Function.Name, Arguments: call.Function.Arguments})
+	}
+	if c.Usage != nil {
+		e.rec.Usage = c.Usage
+	}
+	if c.Err != nil {
+		e.rec.Error = c.Err.Error()
+	}
+}
+
+// finish 写入结束原因与耗时
+func (e *journalEntry) finish(outcome DoneReason) {
+	if e == nil {
+		return
+	}
+	e.rec.Outcome = outcome
+	e.rec.LatencyMs = time.Since(e.start).Milliseconds()
+	e.journal.Append(e.rec)
+}
+
+// Replay 按记录中的原始时间间隔重新产出事件，speed 为回放倍速；ctx 取消时返回 false
+func (rec *JournalRecord) Replay(ctx context.Context, speed float64, emit func(StreamEvent)) bool {
+	if speed <= 0 {
+		speed = 1
+	}
+	start := time.Now()
+	wait := func(t int64) bool {
+		d := time.Duration(float64(t)*float64(time.Millisecond)/speed) - time.Since(start)
+		if d <= 0 {
+			return ctx.Err() == nil
+		}
+		timer := time.NewTimer(d)
+		defer timer.Stop()
+		select {
+		case <-ctx.Done():
+			return false
+		case <-timer.C:
+			return true
+		}
+	}
+
+	// 按时间合并 token 与工具调用
+	calls := rec.ToolCalls
+	for i, tok := range rec.Tokens {
+		for len(calls) > 0 && calls[0].T <= tok.T {
+			if !wait(calls[0].T) {
+				return false
+			}
+			emit(NewStreamEvent(EventToolCall, calls[0].data(len(rec.ToolCalls)-len(calls))))
+			calls = calls[1:]
+		}
+		if !wait(tok.T) {
+			return false
+		}
+		emit(NewStreamEvent(EventToken, &TokenData{V: EventProtocolVersion, Index: i, Content: tok.Text}))
+	}
+	for len(calls) > 0 {
+		if !wait(calls[0].T) {
+			return false
+		}
+		emit(NewStreamEvent(EventToolCall, calls[0].data(len(rec.ToolCalls)-len(calls))))
+		calls = calls[1:]
+	}
+	if !wait(rec.LatencyMs) {
+		return false
+	}
+
+	if rec.Error != "" {
+		emit(NewStreamEvent(EventError, &ErrorData{V: EventProtocolVersion, Code: ErrCodeGeneration, Message: rec.Error}))
+	}
+	completion := 0
+	for _, tok := range rec.Tokens {
+		completion += tok.count()
+	}
+	usage := &UsageData{V: EventProtocolVersion, CompletionTokens: completion, TotalTokens: completion}
+	if rec.Usage != nil {
+		usage.PromptTokens = rec.Usage.PromptTokens
+		usage.CompletionTokens = rec.Usage.CompletionTokens
+		usage.TotalTokens = rec.Usage.TotalTokens
+	}
+	emit(NewStreamEvent(EventUsage, usage))
+	emit(NewStreamEvent(EventDone, &DoneData{V: EventProtocolVersion, Reason: rec.Outcome}))
+	return true
+}
+
+func (c JournalToolCall) data(index int) *ToolCallData {
+	return &ToolCallData{V: EventProtocolVersion, Index: index, ID: c.ID, Name: c.Name, Arguments: c.Arguments}
+}
+
+// parseSpeed 解析回放倍速，取值范围 (0, 100]，为空时为 1
+func parseSpeed(v string) (float64, error) {
+	if v == "" {
+		return 1, nil
+	}
+	speed, err := strconv.ParseFloat(v, 64)
+	if err != nil || speed <= 0 || speed > 100 {
+		return 0, &OptionError{Field: "speed", Message: "取值范围为 (0, 100]"}
+	}
+	return speed, nil
+}
+
+// ReplayHandler 处理 GET /replay/{id}：以 SSE 按原始节奏重放日志中的一次生成。
+// 只能重放本租户的记录，其他租户的记录视为不存在
+type ReplayHandler struct {
+	path string
+}
+
+func (h *ReplayHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if _, ok := w.(http.Flusher); !ok {
+		http.Error(w, "Streaming unsupported", http.StatusInternalServerError)
+		return
+	}
+	speed, err := parseSpeed(r.URL.Query().Get("speed"))
+	if err != nil {
+		writeOptionError(w, err)
+		return
+	}
+	rec, err := FindRecord(h.path, r.PathValue("id"))
+	if err == nil && rec.Tenant != tenantFromContext(r.Context()) {
+		err = ErrRecordNotFound
+	}
+	if errors.Is(err, ErrRecordNotFound) {
+		writeJSONError(w, http.StatusNotFound, "记录不存在")
+		return
+	}
+	if err != nil {
+		writeJSONError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	rc := http.NewResponseController(w)
+	rec.Replay(r.Context(), speed, func(ev StreamEvent) {
+		writeEvent(w, "", ev)
+		rc.Flush()
+	})
+}
+
+// runReplay 实现 -replay 命令：将记录以 SSE 帧格式按原始节奏输出到 out
+func runReplay(out io.Writer, path, id string, speed float64) error {
+	rec, err := FindRecord(path, id)
+	if err != nil {
+		return fmt.Errorf("查找记录 %s: %w", id, err)
+	}
+	rec.Replay(context.Background(), speed, func(ev StreamEvent) {
+		writeEvent(out, "", ev)
+	})
+	return nil
+}
@@ -0,0 +1,261 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"os/signal"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"ai-answer-demo/sseclient"
+)
+
+// loadgen：SSE 压测工具。按目标到达速率向 /stream 发起 N 个并发连接，解析事件并统计
+// 首 token 延迟（TTFT）、token 间延迟（ITL）分位数、错误率、取消率与吞吐。
+// 服务端使用默认的 mock 生成器即可完全离线运行：
+//
+//	go run . &
+//	go run ./loadgen -n 500 -rate 100 -json report.json
+
+// Outcome 单条流的结果
+type Outcome string
+
+const (
+	OutcomeOK        Outcome = "ok"        // 收到 done，原因为 stop 或 length
+	OutcomeError     Outcome = "error"     // 连接失败、非 200 响应、error 事件或 done(error)
+	OutcomeCancelled Outcome = "cancelled" // done(cancelled)，或超过 -timeout 后客户端主动断开
+	OutcomeRejected  Outcome = "rejected"  // 503 / 429，服务端拒绝准入
+)
+
+// StreamResult 单条流的测量结果
+type StreamResult struct {
+	Outcome  Outcome
+	Status   int
+	Err      string
+	TTFT     time.Duration
+	ITLs     []time.Duration
+	Tokens   int
+	Duration time.Duration
+}
+
+// config 命令行参数
+type config struct {
+	url        string
+	n          int
+	rate       float64
+	prompt     string
+	maxTokens  int
+	timeout    time.Duration
+	apiKey     string
+	jsonOut    string
+	keepalives bool
+	unique     bool
+}
+
+func main() {
+	var cfg config
+	flag.StringVar(&cfg.url, "url", "http://localhost:8080/stream", "SSE 接口地址")
+	flag.IntVar(&cfg.n, "n", 100, "总连接数")
+	flag.Float64Var(&cfg.rate, "rate", 50, "目标到达速率（每秒新建连接数），0 表示同时发起")
+	flag.StringVar(&cfg.prompt, "prompt", "hello", "请求的 prompt")
+	flag.IntVar(&cfg.maxTokens, "max-tokens", 0, "每个请求的 max_tokens，0 表示使用服务端默认值")
+	flag.DurationVar(&cfg.timeout, "timeout", 2*time.Minute, "单条流的最长时间，超时后客户端断开并计为取消")
+	flag.StringVar(&cfg.apiKey, "api-key", "", "以 Authorization: Bearer 携带的 API Key")
+	flag.StringVar(&cfg.jsonOut, "json", "", "JSON 报告输出路径，- 表示标准输出")
+	flag.BoolVar(&cfg.keepalives, "keepalive", false, "复用 TCP 连接（默认每条流独立连接，更接近真实客户端）")
+	flag.BoolVar(&cfg.unique, "unique", true, "在 prompt 后追加序号，避免服务端把相同输入的请求合并为同一个生成")
+	flag.Parse()
+
+	if cfg.n <= 0 {
+		log.Fatal("-n 必须大于 0")
+	}
+
+	// Ctrl+C 时取消所有进行中的流，仍然输出已收集的结果
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	start := time.Now()
+	results := run(ctx, &cfg)
+	report := buildReport(&cfg, results, time.Since(start))
+
+	// JSON 输出到标准输出时，表格改写到标准错误，便于管道处理
+	table := os.Stdout
+	if cfg.jsonOut == "-" {
+		table = os.Stderr
+	}
+	printTable(table, report)
+	if cfg.jsonOut != "" {
+		if err := writeJSON(cfg.jsonOut, report); err != nil {
+			log.Fatalf("写入 JSON 报告失败: %v", err)
+		}
+	}
+}
+
+// run 按到达速率发起全部连接并等待结束
+func run(ctx context.Context, cfg *config) []StreamResult {
+	transport := &http.Transport{
+		Proxy:               http.ProxyFromEnvironment,
+		DialContext:         (&net.Dialer{Timeout: 10 * time.Second}).DialContext,
+		MaxIdleConnsPerHost: cfg.n,
+		DisableKeepAlives:   !cfg.keepalives,
+		DisableCompression:  true,
+	}
+	client := &http.Client{Transport: transport}
+
+	if _, err := buildURL(cfg, 0); err != nil {
+		log.Fatalf("无效的 -url: %v", err)
+	}
+
+	results := make([]StreamResult, cfg.n)
+	var wg sync.WaitGroup
+	var interval time.Duration
+	if cfg.rate > 0 {
+		interval = time.Duration(float64(time.Second) / cfg.rate)
+	}
+
+	begin := time.Now()
+	launched := 0
+	for i := 0; i < cfg.n; i++ {
+		// 按计划时间发起，避免 sleep 误差累积
+		if wait := time.Until(begin.Add(time.Duration(i) * interval)); wait > 0 {
+			select {
+			case <-ctx.Done():
+			case <-time.After(wait):
+			}
+		}
+		if ctx.Err() != nil {
+			break
+		}
+		launched++
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			target, _ := buildURL(cfg, i)
+			results[i] = runStream(ctx, client, cfg, target)
+		}(i)
+	}
+	wg.Wait()
+	return results[:launched]
+}
+
+// buildURL 生成第 i 个请求的地址
+func buildURL(cfg *config, i int) (string, error) {
+	u, err := url.Parse(cfg.url)
+	if err != nil {
+		return "", err
+	}
+	q := u.Query()
+	prompt := q.Get("prompt")
+	if prompt == "" {
+		prompt = cfg.prompt
+	}
+	if cfg.unique {
+		prompt += " #" + strconv.Itoa(i)
+	}
+	q.Set("prompt", prompt)
+	if cfg.maxTokens > 0 {
+		q.Set("max_tokens", strconv.Itoa(cfg.maxTokens))
+	}
+	u.RawQuery = q.Encode()
+	return u.String(), nil
+}
+
+// runStream 建立一条 SSE 连接并读取到 done 事件或连接结束
+func runStream(ctx context.Context, client *http.Client, cfg *config, target string) (res StreamResult) {
+	ctx, cancel := context.WithTimeout(ctx, cfg.timeout)
+	defer cancel()
+
+	start := time.Now()
+	defer func() { res.Duration = time.Since(start) }()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, target, nil)
+	if err != nil {
+		return StreamResult{Outcome: OutcomeError, Err: err.Error()}
+	}
+	req.Header.Set("Accept", "text/event-stream")
+	if cfg.apiKey != "" {
+		req.Header.Set("Authorization", "Bearer "+cfg.apiKey)
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		res.Outcome, res.Err = classifyErr(ctx, err)
+		return res
+	}
+	defer resp.Body.Close()
+	res.Status = resp.StatusCode
+	switch {
+	case resp.StatusCode == http.StatusServiceUnavailable || resp.StatusCode == http.StatusTooManyRequests:
+		res.Outcome = OutcomeRejected
+		return res
+	case resp.StatusCode != http.StatusOK:
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		res.Outcome, res.Err = OutcomeError, fmt.Sprintf("HTTP %d: %s", resp.StatusCode, strings.TrimSpace(string(body)))
+		return res
+	}
+
+	var last time.Time
+	events := sseclient.NewReader(resp.Body)
+	for res.Outcome == "" {
+		ev, err := events.Next()
+		if err != nil {
+			if err == io.EOF {
+				err = io.ErrUnexpectedEOF // 连接在 done 之前结束
+			}
+			res.Outcome, res.Err = classifyErr(ctx, err)
+			break
+		}
+		now := time.Now()
+		switch ev.Type {
+		case "token":
+			if res.Tokens == 0 {
+				res.TTFT = now.Sub(start)
+			} else {
+				res.ITLs = append(res.ITLs, now.Sub(last))
+			}
+			last = now
+			res.Tokens++
+		case "error":
+			res.Err = ev.Data
+		case "done":
+			var done struct {
+				Reason string `json:"reason"`
+			}
+			json.Unmarshal([]byte(ev.Data), &done)
+			switch {
+			case done.Reason == "cancelled":
+				res.Outcome = OutcomeCancelled
+			case done.Reason == "error" || res.Err != "":
+				res.Outcome = OutcomeError
+			default:
+				res.Outcome = OutcomeOK
+			}
+		}
+	}
+	return res
+}
+
+// classifyErr 超时或被中断视为客户端取消，其余视为错误
+func classifyErr(ctx context.Context, err error) (Outcome, string) {
+	if ctx.Err() != nil {
+		return OutcomeCancelled, ctx.Err().Error()
+	}
+	// 去掉 url.Error 中各请求不同的地址，便于按错误归类
+	var ue *url.Error
+	if errors.As(err, &ue) {
+		err = ue.Err
+	}
+	return OutcomeError, err.Error()
+}
@@ -0,0 +1,180 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"text/tabwriter"
+	"time"
+)
+
+// Report 压测报告，延迟单位为毫秒
+type Report struct {
+	URL      string  `json:"url"`
+	Requests int     `json:"requests"`
+	Rate     float64 `json:"target_rate"`
+	WallMs   float64 `json:"wall_ms"`
+
+	OK        int `json:"ok"`
+	Errors    int `json:"errors"`
+	Cancelled int `json:"cancelled"`
+	Rejected  int `json:"rejected"`
+
+	ErrorRate  float64 `json:"error_rate"`
+	CancelRate float64 `json:"cancel_rate"`
+	RejectRate float64 `json:"reject_rate"`
+
+	Tokens           int     `json:"tokens"`
+	TokensPerSecond  float64 `json:"tokens_per_second"`
+	StreamsPerSecond float64 `json:"streams_per_second"` // 成功完成的流
+
+	TTFT     Percentiles `json:"ttft_ms"`
+	ITL      Percentiles `json:"itl_ms"`
+	Duration Percentiles `json:"stream_duration_ms"`
+
+	// 出现次数最多的错误信息，便于定位
+	TopErrors map[string]int `json:"top_errors,omitempty"`
+}
+
+// Percentiles 延迟分布
+type Percentiles struct {
+	Count int     `json:"count"`
+	Mean  float64 `json:"mean"`
+	P50   float64 `json:"p50"`
+	P90   float64 `json:"p90"`
+	P95   float64 `json:"p95"`
+	P99   float64 `json:"p99"`
+	Max   float64 `json:"max"`
+}
+
+func buildReport(cfg *config, results []StreamResult, wall time.Duration) *Report {
+	r := &Report{URL: cfg.url, Requests: len(results), Rate: cfg.rate, WallMs: ms(wall), TopErrors: map[string]int{}}
+
+	var ttfts, itls, durations []time.Duration
+	for _, res := range results {
+		switch res.Outcome {
+		case OutcomeOK:
+			r.OK++
+			durations = append(durations, res.Duration)
+		case OutcomeError:
+			r.Errors++
+		case OutcomeCancelled:
+			r.Cancelled++
+		case OutcomeRejected:
+			r.Rejected++
+		}
+		if res.Err != "" && res.Outcome == OutcomeError {
+			r.TopErrors[truncate(res.Err, 120)]++
+		}
+		if res.Tokens > 0 {
+			ttfts = append(ttfts, res.TTFT)
+		}
+		itls = append(itls, res.ITLs...)
+		r.Tokens += res.Tokens
+	}
+	if n := float64(len(results)); n > 0 {
+		r.ErrorRate = float64(r.Errors) / n
+		r.CancelRate = float64(r.Cancelled) / n
+		r.RejectRate = float64(r.Rejected) / n
+	}
+	if secs := wall.Seconds(); secs > 0 {
+		r.TokensPerSecond = float64(r.Tokens) / secs
+		r.StreamsPerSecond = float64(r.OK) / secs
+	}
+	r.TTFT = percentiles(ttfts)
+	r.ITL = percentiles(itls)
+	r.Duration = percentiles(durations)
+	if len(r.TopErrors) == 0 {
+		r.TopErrors = nil
+	}
+	return r
+}
+
+func percentiles(ds []time.Duration) Percentiles {
+	if len(ds) == 0 {
+		return Percentiles{}
+	}
+	sort.Slice(ds, func(i, j int) bool { return ds[i] < ds[j] })
+	var sum time.Duration
+	for _, d := range ds {
+		sum += d
+	}
+	// 最近秩法
+	at := func(q float64) float64 {
+		i := int(q*float64(len(ds))+0.999999) - 1
+		return ms(ds[max(0, min(i, len(ds)-1))])
+	}
+	return Percentiles{
+		Count: len(ds),
+		Mean:  ms(sum / time.Duration(len(ds))),
+		P50:   at(0.50),
+		P90:   at(0.90),
+		P95:   at(0.95),
+		P99:   at(0.99),
+		Max:   ms(ds[len(ds)-1]),
+	}
+}
+
+func ms(d time.Duration) float64 {
+	return float64(d.Microseconds()) / 1000
+}
+
+func truncate(s string, n int) string {
+	if len(s) <= n {
+		return s
+	}
+	return s[:n] + "..."
+}
+
+// maxErrorLines 表格中最多列出的错误种类
+const maxErrorLines = 10
+
+// printTable 输出人类可读的报告
+func printTable(w io.Writer, r *Report) {
+	fmt.Fprintf(w, "目标: %s  请求: %d  到达速率: %.1f/s  总耗时: %.2fs\n", r.URL, r.Requests, r.Rate, r.WallMs/1000)
+	fmt.Fprintf(w, "成功: %d  错误: %d (%.1f%%)  取消: %d (%.1f%%)  拒绝: %d (%.1f%%)\n",
+		r.OK, r.Errors, r.ErrorRate*100, r.Cancelled, r.CancelRate*100, r.Rejected, r.RejectRate*100)
+	fmt.Fprintf(w, "吞吐: %.1f tokens/s  %.2f streams/s  共 %d tokens\n\n", r.TokensPerSecond, r.StreamsPerSecond, r.Tokens)
+
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', tabwriter.AlignRight)
+	fmt.Fprintln(tw, "延迟 (ms)\tcount\tmean\tp50\tp90\tp95\tp99\tmax\t")
+	for _, row := range []struct {
+		name string
+		p    Percentiles
+	}{{"TTFT", r.TTFT}, {"ITL", r.ITL}, {"流总时长", r.Duration}} {
+		fmt.Fprintf(tw, "%s\t%d\t%.1f\t%.1f\t%.1f\t%.1f\t%.1f\t%.1f\t\n", row.name, row.p.Count, row.p.Mean, row.p.P50, row.p.P90, row.p.P95, row.p.P99, row.p.Max)
+	}
+	tw.Flush()
+
+	if len(r.TopErrors) > 0 {
+		msgs := make([]string, 0, len(r.TopErrors))
+		for msg := range r.TopErrors {
+			msgs = append(msgs, msg)
+		}
+		sort.Slice(msgs, func(i, j int) bool { return r.TopErrors[msgs[i]] > r.TopErrors[msgs[j]] })
+		fmt.Fprintln(w, "\n错误:")
+		for i, msg := range msgs {
+			if i == maxErrorLines {
+				fmt.Fprintf(w, "  ... 另有 %d 种错误\n", len(msgs)-i)
+				break
+			}
+			fmt.Fprintf(w, "  %5d  %s\n", r.TopErrors[msg], msg)
+		}
+	}
+}
+
+// writeJSON 写出 JSON 报告，path 为 - 时写到标准输出
+func writeJSON(path string, r *Report) error {
+	data, err := json.MarshalIndent(r, "", "  ")
+	if err != nil {
+		return err
+	}
+	data = append(data, '\n')
+	if path == "-" {
+		_, err = os.Stdout.Write(data)
+		return err
+	}
+	return os.WriteFile(path, data, 0644)
+}
@@ -0,0 +1,67 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"strings"
+	"sync/atomic"
+)
+
+// LogLevel 日志级别，可通过 PATCH /admin/runtime 在运行时调整
+type LogLevel int32
+
+const (
+	LogDebug LogLevel = iota
+	LogInfo
+	LogWarn
+	LogError
+)
+
+var logLevelNames = []string{"debug", "info", "warn", "error"}
+
+func (l LogLevel) String() string {
+	if l < LogDebug || l > LogError {
+		return fmt.Sprintf("LogLevel(%d)", int32(l))
+	}
+	return logLevelNames[l]
+}
+
+// ParseLogLevel 解析 debug、info、warn、error，不区分大小写
+func ParseLogLevel(s string) (LogLevel, error) {
+	for i, name := range logLevelNames {
+		if strings.EqualFold(s, name) {
+			return LogLevel(i), nil
+		}
+	}
+	return 0, fmt.Errorf("未知的日志级别 %q，可选 %s", s, strings.Join(logLevelNames, "、"))
+}
+
+// logLevel 当前日志级别，低于它的日志不输出
+var logLevel atomic.Int32
+
+func init() {
+	logLevel.Store(int32(LogInfo))
+}
+
+// SetLogLevel 设置日志级别
+func SetLogLevel(l LogLevel) {
+	logLevel.Store(int32(l))
+}
+
+// CurrentLogLevel 返回当前日志级别
+func CurrentLogLevel() LogLevel {
+	return LogLevel(logLevel.Load())
+}
+
+// logf 级别不低于当前级别时以 "[level] " 为前缀写入标准 logger
+func logf(l LogLevel, format string, args ...any) {
+	if l < CurrentLogLevel() {
+		return
+	}
+	log.Printf("["+l.String()+"] "+format, args...)
+}
+
+func debugf(format string, args ...any) { logf(LogDebug, format, args...) }
+func infof(format string, args ...any)  { logf(LogInfo, format, args...) }
+func warnf(format string, args ...any)  { logf(LogWarn, format, args...) }
+func errorf(format string, args ...any) { logf(LogError, format, args...) }
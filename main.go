@@ -2,28 +2,166 @@ package main
 
 import (
 	"context"
+	"errors"
+	"flag"
 	"fmt"
 	"log"
+	"math"
 	"net/http"
 	"os"
-	"os/signal"
-	"syscall"
+	"strconv"
+	"strings"
 	"time"
+
+	"ai-answer-demo/tracing"
+
+	"github.com/cloudwego/eino/schema"
 )
 
 type SSEHandler struct {
-	model *ModelGenerator
+	registry *GenerationRegistry
 }
 
 func main() {
-	model := &ModelGenerator{bufferSize: 10}
-	handler := &SSEHandler{model: model}
+	configPath := flag.String("config", "server_config.json", "服务配置文件路径")
+	replayID := flag.String("replay", "", "按原始节奏重放生成记录中的指定 ID 后退出")
+	replaySpeed := flag.Float64("replay-speed", 1, "-replay 的回放倍速")
+	flag.Parse()
+
+	cfg, err := LoadConfig(*configPath)
+	if err != nil {
+		log.Fatalf("加载配置失败: %v", err)
+	}
+	level, _ := ParseLogLevel(cfg.LogLevel)
+	SetLogLevel(level)
+	if *replayID != "" {
+		if err := runReplay(os.Stdout, cfg.Journal.Path, *replayID, *replaySpeed); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+
+	if cfg.Tracing.Export != "" {
+		exporter, err := tracing.NewExporter(cfg.Tracing.Export)
+		if err != nil {
+			log.Fatalf("创建 trace 导出失败: %v", err)
+		}
+		tracer := tracing.NewTracer(cfg.Tracing.ServiceName, cfg.Tracing.SampleRatio, exporter)
+		tracing.SetDefault(tracer)
+		defer func() {
+			ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+			defer cancel()
+			if err := tracer.Shutdown(ctx); err != nil {
+				warnf("导出剩余 trace 失败: %v", err)
+			}
+		}()
+		metrics.Registry.NewGaugeFunc("trace_spans_dropped", "Number of finished spans dropped because the export queue was full.", func() float64 {
+			return float64(tracer.Dropped())
+		})
+		infof("链路追踪已开启，导出到 %s", cfg.Tracing.Export)
+	}
+
+	model, err := NewGenerator(context.Background(), cfg.Generator)
+	if err != nil {
+		log.Fatalf("创建生成器失败: %v", err)
+	}
+	if router, ok := model.(*Router); ok {
+		defer router.Close()
+		infof("多后端路由已开启，策略 %s，后端: %s", cfg.Generator.Router.Strategy, strings.Join(router.Names(), ", "))
+	}
+	if cfg.Dev.Enabled {
+		// 开发模式：前端与集成测试可通过 X-Fixture 请求头回放固定的脚本
+		model = NewScriptedGenerator(model, cfg.Dev.FixturesDir, cfg.Generator.BufferSize)
+		infof("开发模式已开启，回放脚本目录: %s", cfg.Dev.FixturesDir)
+	}
+	// 所有 HTTP 流式请求经由有界 worker 池准入
+	pipeline := NewStreamPipeline(model, cfg.Pipeline.MaxQueue, cfg.Generator.AllowedModels(), cfg.Pipeline.Classes)
+	filter, err := NewContentFilter(cfg.Filter)
+	if err != nil {
+		log.Fatalf("创建内容过滤失败: %v", err)
+	}
+	if filter != nil {
+		pipeline.SetContentFilter(filter)
+		infof("内容过滤已开启，规则: %s", strings.Join(filter.Rules(), ", "))
+	}
+	if cfg.Journal.Path != "" {
+		journal, err := OpenJournal(cfg.Journal.Path)
+		if err != nil {
+			log.Fatalf("打开生成记录失败: %v", err)
+		}
+		defer journal.Close()
+		pipeline.SetJournal(journal)
+	}
+	pipeline.StartWorkers(cfg.Pipeline.Workers)
+	metrics.Registry.NewGaugeFunc("stream_workers", "Target number of pipeline workers, adjustable through PATCH /admin/runtime.", func() float64 {
+		return float64(pipeline.Workers())
+	})
+	metrics.Registry.NewGaugeFunc("stream_queue_depth", "Number of stream requests waiting for a worker.", func() float64 {
+		return float64(pipeline.QueueDepth())
+	})
+	for _, class := range priorityClasses {
+		metrics.Registry.NewGaugeFunc("stream_class_queue_depth", "Number of stream requests waiting for a worker, by priority class.", func() float64 {
+			return float64(pipeline.ClassQueueDepth(class))
+		}, "class", string(class))
+	}
+
+	lifecycle := NewServerLifecycle(pipeline)
+	registry := NewGenerationRegistry(pipeline, lifecycle, time.Duration(cfg.ResumeGraceSeconds)*time.Second, cfg.DedupePrompts, cfg.Delivery)
+	handler := &SSEHandler{registry: registry}
+
+	// API Key 认证，未配置密钥文件时不启用
+	auth, err := NewAuthenticator(cfg.Auth.KeysFile)
+	if err != nil {
+		log.Fatalf("加载密钥文件失败: %v", err)
+	}
+	if auth != nil {
+		go auth.WatchReload()
+	}
 
 	mux := http.NewServeMux()
-	mux.Handle("/stream", SafeStream(handler))
+	mux.Handle("/stream", SafeStream(auth.Require(handler)))
+	// 多个客户端订阅同一个进行中的生成
+	mux.Handle("GET /stream/{id}/subscribe", SafeStream(auth.Require(http.HandlerFunc(handler.Subscribe))))
+
+	// WebSocket 传输：同一连接上并发多次生成，支持按 ID 取消与追问
+	mux.Handle("GET /ws", auth.Require(&WSHandler{registry: registry}))
+
+	// OpenAI 兼容接口，与 /stream 共用同一个生成器
+	openaiHandler := &OpenAIHandler{pipeline: pipeline, lifecycle: lifecycle, models: cfg.Generator.ModelNames(), delivery: cfg.Delivery}
+	mux.Handle("POST /v1/chat/completions", SafeStream(auth.Require(http.HandlerFunc(openaiHandler.ChatCompletions))))
+	mux.Handle("GET /v1/models", auth.Require(http.HandlerFunc(openaiHandler.ListModels)))
+
+	// 服务端多轮对话会话
+	sessionStore, err := NewSessionStore(cfg.Sessions)
+	if err != nil {
+		log.Fatalf("创建会话存储失败: %v", err)
+	}
+	NewSessionHandler(sessionStore, registry).Register(mux, auth)
+
+	// 异步生成任务，结果通过轮询或回调获取
+	if cfg.Jobs.Dir != "" {
+		jobs, err := NewJobManager(cfg.Jobs, registry, lifecycle, auth)
+		if err != nil {
+			log.Fatalf("加载任务目录失败: %v", err)
+		}
+		(&JobsHandler{jobs: jobs}).Register(mux, auth)
+	}
+
+	// 按原始节奏重放生成记录
+	if cfg.Journal.Path != "" && cfg.Journal.ReplayEndpoint {
+		mux.Handle("GET /replay/{id}", SafeStream(auth.Require(&ReplayHandler{path: cfg.Journal.Path})))
+	}
+
+	// 管理接口，未配置管理令牌时不开放
+	if cfg.Admin.Token != "" {
+		(&AdminHandler{token: cfg.Admin.Token, pipeline: pipeline}).Register(mux)
+	}
+
+	// Prometheus 文本格式指标
+	mux.Handle("GET /metrics", metrics.Registry)
 
 	server := &http.Server{
-		Addr:    ":8080",
+		Addr:    cfg.Addr,
 		Handler: mux,
 		// 调优参数
 		ReadHeaderTimeout: 5 * time.Second,
@@ -33,158 +171,343 @@ func main() {
 	// 启动监控
 	go monitorConnections()
 
-	log.Fatal(server.ListenAndServe())
+	StartServer(server, lifecycle, time.Duration(cfg.DrainTimeoutSeconds)*time.Second)
 }
 
 func (h *SSEHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
-	// 零拷贝写入：通过直接操作http.Flusher减少内存复制次数
-	flusher, ok := w.(http.Flusher)
-	if !ok {
+	// 事件直接写入 ResponseWriter 的缓冲区，由 sseWriter 决定何时 flush（见 flush_bench_test.go）
+	if _, ok := w.(http.Flusher); !ok {
 		http.Error(w, "Streaming unsupported", http.StatusInternalServerError)
 		return
 	}
 
+	// 断线重连：EventSource 会自动携带 Last-Event-ID，无法设置请求头的客户端可改用查询参数
+	lastEventID := r.Header.Get("Last-Event-ID")
+	if lastEventID == "" {
+		lastEventID = r.URL.Query().Get("last_event_id")
+	}
+
+	var gen *Generation
+	from := 0
+	if genID, seq, ok := parseEventID(lastEventID); ok {
+		if g, found := h.registry.Get(genID, tenantFromContext(r.Context())); found {
+			gen, from = g, seq+1
+		}
+	}
+	if gen == nil {
+		opts, err := ParseQueryOptions(r.URL.Query())
+		if err == nil {
+			opts.Fixture = r.Header.Get(FixtureHeader)
+			err = h.registry.pipeline.ValidateOptions(opts)
+		}
+		var adm Admission
+		if err == nil {
+			adm, err = admissionFromRequest(r)
+		}
+		if err != nil {
+			writeOptionError(w, err)
+			return
+		}
+
+		applyQuota(r.Context(), opts)
+		gen, err = h.registry.Start(r.Context(), promptMessages(r.URL.Query().Get("prompt")), opts, adm)
+		if err != nil {
+			rejectSubmit(w, err, h.registry.pipeline)
+			return
+		}
+	}
+
+	serveGeneration(w, r, h.registry, gen, from)
+}
+
+// Subscribe 处理 GET /stream/{id}/subscribe：先回放已生成的事件，再实时推送后续事件。
+// 只能订阅本租户发起的生成，其他租户的生成返回 404
+func (h *SSEHandler) Subscribe(w http.ResponseWriter, r *http.Request) {
+	if _, ok := w.(http.Flusher); !ok {
+		http.Error(w, "Streaming unsupported", http.StatusInternalServerError)
+		return
+	}
+
+	gen, found := h.registry.Get(r.PathValue("id"), tenantFromContext(r.Context()))
+	if !found {
+		http.Error(w, "generation not found", http.StatusNotFound)
+		return
+	}
+
+	from := 0
+	if genID, seq, ok := parseEventID(r.Header.Get("Last-Event-ID")); ok && genID == gen.ID {
+		from = seq + 1
+	}
+	serveGeneration(w, r, h.registry, gen, from)
+}
+
+// serveGeneration 以 SSE 写出生成的事件，from 为起始事件序号（断线续传时非零）。
+// 事件按 DeliveryConfig 合并 flush，写出超时或滞后超出预算的慢客户端会被断开，
+// 生成本身不受影响，客户端可携带 Last-Event-ID 重连续传
+func serveGeneration(w http.ResponseWriter, r *http.Request, registry *GenerationRegistry, gen *Generation, from int) {
+	registry.Attach(gen)
+	defer registry.Detach(gen)
+	end, ok := registry.lifecycle.Begin()
+	if !ok {
+		rejectSubmit(w, ErrShuttingDown, registry.pipeline)
+		return
+	}
+	defer end()
+
+	tracker := metrics.TrackStream(r.Context())
+	cancelled := true
+	defer func() { tracker.Finish(cancelled) }()
+
 	w.Header().Set("Content-Type", "text/event-stream")
 	w.Header().Set("Cache-Control", "no-cache")
 	w.Header().Set("Connection", "keep-alive")
+	// 其他客户端可通过该 ID 订阅同一个生成
+	w.Header().Set("X-Generation-ID", gen.ID)
+
+	ctx := r.Context()
+	sw := newSSEWriter(w, registry.delivery)
+	defer func() { metrics.Flushes.Add(uint64(sw.flushes)) }()
+	queued := func(ev StreamEvent) {
+		sw.Write("", ev)
+		sw.Flush()
+	}
+	if !waitQueued(ctx, registry, gen, queued) {
+		return
+	}
 
-	ctx, cancel := context.WithCancel(r.Context())
-	defer cancel()
+	draining := registry.lifecycle.Draining()
+	var flushAt <-chan time.Time
+	seq := from
+	for {
+		events, done, changed := gen.Next(seq)
+		if len(events) > 0 && sw.Lagging(events[0], time.Now()) {
+			metrics.SlowClientsLagging.Inc()
+			sw.Write("", NewStreamEvent(EventError, &ErrorData{V: EventProtocolVersion, Code: ErrCodeSlowConsumer, Message: "客户端读取过慢，连接已断开，可携带 Last-Event-ID 重连续传"}))
+			sw.Flush()
+			return
+		}
+		for _, ev := range events {
+			if err := sw.Write(formatEventID(gen.ID, seq), ev); err != nil {
+				countWriteTimeout(err)
+				return
+			}
+			if ev.Type == EventToken {
+				tracker.Token(ev.tokens)
+			}
+			seq++
+		}
+		if done {
+			if err := sw.Flush(); err != nil {
+				countWriteTimeout(err)
+				return
+			}
+			cancelled = false
+			return
+		}
+		wait, err := sw.MaybeFlush(time.Now())
+		if err != nil {
+			countWriteTimeout(err)
+			return
+		}
+		if wait > 0 && flushAt == nil {
+			flushAt = time.After(wait)
+		}
 
-	for token := range h.model.Stream(ctx, r.URL.Query().Get("prompt")) {
-		fmt.Fprintf(w, "data: %s\n\n", token)
-		flusher.Flush() // 关键：立即发送到客户端
+		select {
+		case <-ctx.Done():
+			return
+		case <-changed:
+		case <-flushAt:
+			// 合并等待到期，回到循环开头由 MaybeFlush 写出
+			flushAt = nil
+		case <-draining:
+			// 服务开始关闭：告知客户端当前生成会在截止时间前继续，之后不要在本连接上重连
+			sw.Write("", NewStreamEvent(EventShutdown, &ShutdownData{
+				V:             EventProtocolVersion,
+				DrainDeadline: registry.lifecycle.DrainDeadline().Format(time.RFC3339),
+			}))
+			sw.Flush()
+			draining = nil
+		}
 	}
 }
 
+// queuedGrace 提交后等待空闲 worker 接手的时间，期间开始生成的请求不推送 queued 事件
+const queuedGrace = 50 * time.Millisecond
+
+// waitQueued 在生成排队期间通过 send 推送 queued 事件告知排队位置，客户端断开时返回 false。
+// 刚提交的请求即使有空闲 worker 也要片刻才被取走，先等待 queuedGrace，仍在排队才推送
+func waitQueued(ctx context.Context, registry *GenerationRegistry, gen *Generation, send func(StreamEvent)) bool {
+	select {
+	case <-ctx.Done():
+		return false
+	case <-gen.request.Started():
+		return true
+	case <-time.After(queuedGrace):
+	}
+
+	ticker := time.NewTicker(500 * time.Millisecond)
+	defer ticker.Stop()
+
+	last := 0
+	for {
+		pos := registry.QueuePosition(gen)
+		if pos == 0 {
+			return true
+		}
+		if pos != last {
+			send(NewStreamEvent(EventQueued, &QueuedData{V: EventProtocolVersion, Position: pos}))
+			last = pos
+		}
+
+		select {
+		case <-ctx.Done():
+			return false
+		case <-gen.request.Started():
+			return true
+		case <-ticker.C:
+		}
+	}
+}
+
+// rejectSubmit 请求未被 StreamPipeline 接收时返回 503 并通过 Retry-After 提示重试时间
+func rejectSubmit(w http.ResponseWriter, err error, pipeline *StreamPipeline) {
+	metrics.RequestsRejected.Inc()
+	setRetryAfter(w, pipeline.RetryAfter())
+	http.Error(w, submitErrorMessage(err), http.StatusServiceUnavailable)
+}
+
+func submitErrorMessage(err error) string {
+	if errors.Is(err, ErrShuttingDown) {
+		return "服务正在关闭，请稍后重试"
+	}
+	return "服务繁忙，请稍后重试"
+}
+
+// writeOptionError 生成参数不合法时返回 400
+func writeOptionError(w http.ResponseWriter, err error) {
+	var optErr *OptionError
+	if errors.As(err, &optErr) {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": optErr.Message, "field": optErr.Field})
+		return
+	}
+	writeJSONError(w, http.StatusBadRequest, err.Error())
+}
+
+// setRetryAfter 以向上取整的秒数设置 Retry-After 响应头
+func setRetryAfter(w http.ResponseWriter, d time.Duration) {
+	w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(d.Seconds()))))
+}
+
+// promptMessages 将单轮 prompt 包装为对话消息
+func promptMessages(prompt string) []*schema.Message {
+	return []*schema.Message{schema.UserMessage(prompt)}
+}
+
 func monitorConnections() {
 	ticker := time.NewTicker(10 * time.Second)
 	for range ticker.C {
-		// 获取当前连接数（需与前面metrics集成）
-		log.Printf("活跃连接数: %d", getActiveConnections())
+		debugf("活跃连接数: %d", getActiveConnections())
 	}
 }
 
 // 获取活跃连接数
 func getActiveConnections() int {
-	// 这里实现获取连接数的逻辑
-	// 由于是示例代码，暂时返回固定值
-	return 0
-}
-
-// 流式请求结构体
-type StreamRequest struct {
-	ID     string
-	Ctx    context.Context
-	Prompt string
-}
-
-// 流式响应结构体
-type StreamResponse struct {
-	ID     string
-	Tokens []string
-}
-
-type StreamPipeline struct {
-	inputChan  chan *StreamRequest
-	outputChan chan *StreamResponse
-	model      *ModelGenerator
-}
-
-func (p *StreamPipeline) StartWorkers(num int) {
-	for i := 0; i < num; i++ {
-		go func() {
-			for req := range p.inputChan {
-				ctx, cancel := context.WithCancel(req.Ctx)
-
-				// 二级缓冲管道
-				intermediate := make(chan string, 10)
-				go func() {
-					defer close(intermediate)
-					for token := range p.model.Stream(ctx, req.Prompt) {
-						intermediate <- token
-					}
-				}()
-
-				// 组装最终响应
-				res := &StreamResponse{ID: req.ID}
-				for token := range intermediate {
-					res.Tokens = append(res.Tokens, token)
-					if len(res.Tokens)%5 == 0 { // 每5个token发送一次
-						p.outputChan <- res
-						res = &StreamResponse{ID: req.ID}
-					}
-				}
-				cancel()
-			}
-		}()
-	}
+	return int(metrics.ActiveStreams.Value())
 }
 
 func SafeStream(h http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		sw := &streamWriter{ResponseWriter: w}
+
+		// 整个请求的 span，请求头携带 traceparent 时接续上游的 trace
+		route := strings.TrimPrefix(r.Pattern, r.Method+" ")
+		ctx, span := tracing.StartKind(tracing.Extract(r.Context(), r.Header), tracing.KindServer, r.Method+" "+route,
+			tracing.String("http.request.method", r.Method),
+			tracing.String("http.route", route),
+			tracing.String("url.path", r.URL.Path))
+		r = r.WithContext(ctx)
+		defer func() {
+			// 未写出任何响应（如连接已断开）时不记录状态码
+			if sw.status != 0 {
+				span.SetAttrs(tracing.Int("http.response.status_code", sw.status))
+			}
+			if sw.status >= 500 {
+				span.SetStatus(tracing.StatusError, http.StatusText(sw.status))
+			}
+			span.End()
+		}()
+
 		defer func() {
-			if r := recover(); r != nil {
-				log.Printf("流式异常: %v", r)
-				w.WriteHeader(http.StatusInternalServerError)
+			if v := recover(); v != nil {
+				errorf("流式异常: %v", v)
+				metrics.PanicsRecovered.Inc()
+				span.RecordError(fmt.Errorf("panic: %v", v))
+				sw.recoverPanic(v)
 			}
 		}()
 
-		// 连接状态检测
-		if _, err := w.Write(nil); err != nil {
-			log.Printf("连接已断开: %v", err)
+		// 连接状态检测：不写入响应体，避免提前提交 200 状态码导致后续错误码失效
+		if err := r.Context().Err(); err != nil {
+			debugf("连接已断开: %v", err)
 			return
 		}
 
-		h.ServeHTTP(w, r)
+		h.ServeHTTP(sw, r)
 	})
 }
 
-func StartServer() {
-	server := &http.Server{
-		Addr: ":8080",
-		Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-			// 流式处理器
-		}),
+// streamWriter 记录响应头是否已发送，以便 panic 时选择合适的错误返回方式
+type streamWriter struct {
+	http.ResponseWriter
+	wroteHeader bool
+	status      int
+}
+
+// commit 记录响应头已发送及其状态码
+func (w *streamWriter) commit(code int) {
+	if !w.wroteHeader {
+		w.wroteHeader = true
+		w.status = code
 	}
+}
 
-	// 优雅关闭
-	go func() {
-		sig := make(chan os.Signal, 1)
-		signal.Notify(sig, syscall.SIGINT, syscall.SIGTERM)
-		<-sig
+func (w *streamWriter) WriteHeader(code int) {
+	w.commit(code)
+	w.ResponseWriter.WriteHeader(code)
+}
 
-		ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
-		defer cancel()
+func (w *streamWriter) Write(b []byte) (int, error) {
+	w.commit(http.StatusOK)
+	return w.ResponseWriter.Write(b)
+}
 
-		if err := server.Shutdown(ctx); err != nil {
-			log.Printf("关闭错误: %v", err)
-		}
-	}()
+func (w *streamWriter) Flush() {
+	w.FlushError()
+}
 
-	if err := server.ListenAndServe(); err != http.ErrServerClosed {
-		log.Fatal(err)
-	}
+// FlushError 供 http.ResponseController 取得 flush 的错误
+func (w *streamWriter) FlushError() error {
+	w.commit(http.StatusOK)
+	return http.NewResponseController(w.ResponseWriter).Flush()
 }
 
-// 模拟大模型生成器
-type ModelGenerator struct {
-	bufferSize int
+// Unwrap 供 http.ResponseController 访问底层连接
+func (w *streamWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
 }
 
-func (m *ModelGenerator) Stream(ctx context.Context, prompt string) <-chan string {
-	out := make(chan string, m.bufferSize)
-	go func() {
-		defer close(out)
-		for i := 0; i < 50; i++ { // 模拟50个token生成
-			select {
-			case <-ctx.Done():
-				log.Println("生成中断")
-				return
-			case <-time.After(100 * time.Millisecond): // 模拟计算延迟
-				out <- fmt.Sprintf("token-%d", i)
-			}
-		}
-	}()
-	return out
+// recoverPanic 响应头未发送时返回 500；SSE 流已开始时改为下发 error 与 done 事件
+func (w *streamWriter) recoverPanic(v any) {
+	if !w.wroteHeader {
+		w.commit(http.StatusInternalServerError)
+		http.Error(w.ResponseWriter, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+	if !strings.HasPrefix(w.Header().Get("Content-Type"), "text/event-stream") {
+		return
+	}
+	writeEvent(w.ResponseWriter, "", NewStreamEvent(EventError, &ErrorData{V: EventProtocolVersion, Code: ErrCodeInternal, Message: fmt.Sprint(v)}))
+	writeEvent(w.ResponseWriter, "", NewStreamEvent(EventDone, &DoneData{V: EventProtocolVersion, Reason: DoneError}))
+	w.Flush()
 }
@@ -0,0 +1,337 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"runtime"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// 本文件实现一个精简的 Prometheus 文本格式导出器，不依赖外部客户端库
+
+// Counter 单调递增计数器
+type Counter struct {
+	v atomic.Uint64
+}
+
+func (c *Counter) Inc()          { c.v.Add(1) }
+func (c *Counter) Add(n uint64)  { c.v.Add(n) }
+func (c *Counter) Value() uint64 { return c.v.Load() }
+
+// Gauge 可增可减的瞬时值
+type Gauge struct {
+	v atomic.Int64
+}
+
+func (g *Gauge) Inc()         { g.v.Add(1) }
+func (g *Gauge) Dec()         { g.v.Add(-1) }
+func (g *Gauge) Set(n int64)  { g.v.Store(n) }
+func (g *Gauge) Value() int64 { return g.v.Load() }
+
+// Histogram 固定桶的直方图，桶上界单位为秒
+type Histogram struct {
+	mu      sync.Mutex
+	buckets []float64
+	counts  []uint64
+	sum     float64
+	count   uint64
+}
+
+func newHistogram(buckets []float64) *Histogram {
+	sort.Float64s(buckets)
+	return &Histogram{buckets: buckets, counts: make([]uint64, len(buckets))}
+}
+
+// Observe 记录一次观测值
+func (h *Histogram) Observe(v float64) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for i, upper := range h.buckets {
+		if v <= upper {
+			h.counts[i]++
+		}
+	}
+	h.sum += v
+	h.count++
+}
+
+// ObserveDuration 以秒为单位记录耗时
+func (h *Histogram) ObserveDuration(d time.Duration) {
+	h.Observe(d.Seconds())
+}
+
+type metricSeries struct {
+	labels string // 已格式化的标签，如 {outcome="completed"}
+	value  any
+}
+
+type metricFamily struct {
+	name   string
+	help   string
+	typ    string
+	series []metricSeries
+}
+
+// MetricsRegistry 指标注册表，实现 http.Handler 以输出 Prometheus 文本格式
+type MetricsRegistry struct {
+	mu       sync.Mutex
+	families []*metricFamily
+	index    map[string]*metricFamily
+}
+
+func NewMetricsRegistry() *MetricsRegistry {
+	return &MetricsRegistry{index: make(map[string]*metricFamily)}
+}
+
+func (r *MetricsRegistry) register(name, help, typ string, value any, labels []string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	f, ok := r.index[name]
+	if !ok {
+		f = &metricFamily{name: name, help: help, typ: typ}
+		r.families = append(r.families, f)
+		r.index[name] = f
+	}
+	f.series = append(f.series, metricSeries{labels: formatLabels(labels), value: value})
+}
+
+// NewCounter 注册计数器，labels 为交替出现的键值对
+func (r *MetricsRegistry) NewCounter(name, help string, labels ...string) *Counter {
+	c := &Counter{}
+	r.register(name, help, "counter", c, labels)
+	return c
+}
+
+// NewGauge 注册瞬时值
+func (r *MetricsRegistry) NewGauge(name, help string, labels ...string) *Gauge {
+	g := &Gauge{}
+	r.register(name, help, "gauge", g, labels)
+	return g
+}
+
+// NewGaugeFunc 注册在采集时才计算的瞬时值
+func (r *MetricsRegistry) NewGaugeFunc(name, help string, fn func() float64, labels ...string) {
+	r.register(name, help, "gauge", fn, labels)
+}
+
+// NewHistogram 注册直方图
+func (r *MetricsRegistry) NewHistogram(name, help string, buckets []float64, labels ...string) *Histogram {
+	h := newHistogram(buckets)
+	r.register(name, help, "histogram", h, labels)
+	return h
+}
+
+func (r *MetricsRegistry) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	r.WriteText(w)
+}
+
+// WriteText 按 Prometheus 文本格式输出全部指标
+func (r *MetricsRegistry) WriteText(w io.Writer) {
+	r.mu.Lock()
+	families := append([]*metricFamily(nil), r.families...)
+	r.mu.Unlock()
+
+	var b strings.Builder
+	for _, f := range families {
+		fmt.Fprintf(&b, "# HELP %s %s\n", f.name, f.help)
+		fmt.Fprintf(&b, "# TYPE %s %s\n", f.name, f.typ)
+		for _, s := range f.series {
+			switch v := s.value.(type) {
+			case *Counter:
+				fmt.Fprintf(&b, "%s%s %d\n", f.name, s.labels, v.Value())
+			case *Gauge:
+				fmt.Fprintf(&b, "%s%s %d\n", f.name, s.labels, v.Value())
+			case func() float64:
+				fmt.Fprintf(&b, "%s%s %s\n", f.name, s.labels, formatFloat(v()))
+			case *Histogram:
+				writeHistogram(&b, f.name, s.labels, v)
+			}
+		}
+	}
+	io.WriteString(w, b.String())
+}
+
+func writeHistogram(b *strings.Builder, name, labels string, h *Histogram) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for i, upper := range h.buckets {
+		fmt.Fprintf(b, "%s_bucket%s %d\n", name, withLabel(labels, "le", formatFloat(upper)), h.counts[i])
+	}
+	fmt.Fprintf(b, "%s_bucket%s %d\n", name, withLabel(labels, "le", "+Inf"), h.count)
+	fmt.Fprintf(b, "%s_sum%s %s\n", name, labels, formatFloat(h.sum))
+	fmt.Fprintf(b, "%s_count%s %d\n", name, labels, h.count)
+}
+
+func formatLabels(kv []string) string {
+	if len(kv) == 0 {
+		return ""
+	}
+	pairs := make([]string, 0, len(kv)/2)
+	for i := 0; i+1 < len(kv); i += 2 {
+		pairs = append(pairs, fmt.Sprintf("%s=%q", kv[i], kv[i+1]))
+	}
+	return "{" + strings.Join(pairs, ",") + "}"
+}
+
+// withLabel 在已格式化的标签中追加一个标签
+func withLabel(labels, key, value string) string {
+	pair := fmt.Sprintf("%s=%q", key, value)
+	if labels == "" {
+		return "{" + pair + "}"
+	}
+	return labels[:len(labels)-1] + "," + pair + "}"
+}
+
+func formatFloat(v float64) string {
+	if math.IsInf(v, 1) {
+		return "+Inf"
+	}
+	return fmt.Sprintf("%g", v)
+}
+
+// StreamMetrics 流式服务的核心指标
+type StreamMetrics struct {
+	Registry *MetricsRegistry
+
+	ActiveStreams    *Gauge
+	RequestsTotal    *Counter
+	RequestsRejected *Counter
+	TokensEmitted    *Counter
+	TimeToFirstToken *Histogram
+	InterTokenDelay  *Histogram
+	StreamsCompleted *Counter
+	StreamsCancelled *Counter
+	PanicsRecovered  *Counter
+	AdminCancels     *Counter
+
+	DedupedGenerations *Counter
+
+	Flushes                 *Counter
+	SlowClientsLagging      *Counter
+	SlowClientsWriteTimeout *Counter
+
+	BatchSteps *Counter
+	BatchSize  *Histogram
+
+	// 按优先级区分的排队指标，键为 PriorityClass
+	QueueWait     map[PriorityClass]*Histogram
+	QueueRejected map[PriorityClass]*Counter
+
+	// 内容过滤的处置次数，键为规则名
+	Moderations map[string]*Counter
+
+	// 多后端路由切换后端的次数，按是否已输出 token 区分
+	FailoversBeforeFirstToken *Counter
+	FailoversMidStream        *Counter
+}
+
+// latencyBuckets 覆盖 1ms ~ 30s 的延迟桶
+var latencyBuckets = []float64{0.001, 0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30}
+
+func NewStreamMetrics() *StreamMetrics {
+	r := NewMetricsRegistry()
+	m := &StreamMetrics{
+		Registry:         r,
+		ActiveStreams:    r.NewGauge("stream_active_streams", "Number of streams currently being served."),
+		RequestsTotal:    r.NewCounter("stream_requests_total", "Total number of stream requests received."),
+		RequestsRejected: r.NewCounter("stream_rejected_total", "Stream requests rejected because the queue was full."),
+		TokensEmitted:    r.NewCounter("stream_tokens_emitted_total", "Total number of tokens written to clients."),
+		TimeToFirstToken: r.NewHistogram("stream_time_to_first_token_seconds", "Time from request start to the first token written.", append([]float64(nil), latencyBuckets...)),
+		InterTokenDelay:  r.NewHistogram("stream_inter_token_latency_seconds", "Delay between consecutive tokens written to a client.", append([]float64(nil), latencyBuckets...)),
+		StreamsCompleted: r.NewCounter("stream_finished_total", "Streams finished, by outcome.", "outcome", "completed"),
+		StreamsCancelled: r.NewCounter("stream_finished_total", "Streams finished, by outcome.", "outcome", "client_cancelled"),
+		PanicsRecovered:  r.NewCounter("stream_panics_total", "Panics recovered by SafeStream and generators."),
+		AdminCancels:     r.NewCounter("stream_admin_cancels_total", "Streams cancelled through DELETE /admin/streams/{id}."),
+
+		DedupedGenerations: r.NewCounter("stream_deduplicated_total", "Stream requests that joined an identical in-flight generation."),
+
+		Flushes:                 r.NewCounter("stream_flushes_total", "Flushes of SSE responses after coalescing."),
+		SlowClientsLagging:      r.NewCounter("stream_slow_client_disconnects_total", "Slow clients disconnected, by cause.", "cause", "lag_budget"),
+		SlowClientsWriteTimeout: r.NewCounter("stream_slow_client_disconnects_total", "Slow clients disconnected, by cause.", "cause", "write_timeout"),
+
+		BatchSteps: r.NewCounter("stream_batch_steps_total", "Forward steps executed by the continuous batcher."),
+		BatchSize:  r.NewHistogram("stream_batch_size", "Number of sequences in each continuous batching step.", []float64{1, 2, 4, 8, 16, 32, 64, 128}),
+
+		FailoversBeforeFirstToken: r.NewCounter("stream_failovers_total", "Generations moved to another router backend, by whether tokens had been emitted.", "kind", "before_first_token"),
+		FailoversMidStream:        r.NewCounter("stream_failovers_total", "Generations moved to another router backend, by whether tokens had been emitted.", "kind", "mid_stream"),
+	}
+	m.QueueWait = make(map[PriorityClass]*Histogram)
+	m.QueueRejected = make(map[PriorityClass]*Counter)
+	for _, class := range priorityClasses {
+		m.QueueWait[class] = r.NewHistogram("stream_queue_wait_seconds", "Time stream requests spent queued before a worker picked them up, by priority class.", append([]float64(nil), latencyBuckets...), "class", string(class))
+		m.QueueRejected[class] = r.NewCounter("stream_queue_rejected_total", "Stream requests rejected because the queue or the class limit was full, by priority class.", "class", string(class))
+	}
+	m.Moderations = make(map[string]*Counter)
+	for _, rule := range filterRuleNames {
+		m.Moderations[rule] = r.NewCounter("stream_moderations_total", "Content redacted by the filter stage, by rule.", "rule", rule)
+	}
+	r.NewGaugeFunc("go_goroutines", "Number of goroutines that currently exist.", func() float64 {
+		return float64(runtime.NumGoroutine())
+	})
+	return m
+}
+
+func (m *StreamMetrics) queueWait(class PriorityClass) *Histogram {
+	return m.QueueWait[priorityClasses[class.rank()]]
+}
+
+func (m *StreamMetrics) queueRejected(class PriorityClass) *Counter {
+	return m.QueueRejected[priorityClasses[class.rank()]]
+}
+
+func (m *StreamMetrics) moderation(rule string) *Counter {
+	return m.Moderations[rule]
+}
+
+// metrics 进程级指标实例
+var metrics = NewStreamMetrics()
+
+// StreamTracker 记录单个流的生命周期指标
+type StreamTracker struct {
+	m      *StreamMetrics
+	start  time.Time
+	last   time.Time
+	tokens int
+	usage  *keyUsage // 请求所属 API Key 的用量，未启用认证时为 nil
+}
+
+// TrackStream 开始跟踪一个流，实际写出的 token 同时计入请求所属 API Key 的每日用量
+func (m *StreamMetrics) TrackStream(ctx context.Context) *StreamTracker {
+	m.RequestsTotal.Inc()
+	m.ActiveStreams.Inc()
+	return &StreamTracker{m: m, start: time.Now(), usage: usageFromContext(ctx)}
+}
+
+// Token 记录一段已写出的、代表 n 个上游 token 的文本
+func (t *StreamTracker) Token(n int) {
+	now := time.Now()
+	if t.tokens == 0 {
+		t.m.TimeToFirstToken.ObserveDuration(now.Sub(t.start))
+	} else {
+		t.m.InterTokenDelay.ObserveDuration(now.Sub(t.last))
+	}
+	t.last = now
+	t.tokens += n
+	t.m.TokensEmitted.Add(uint64(n))
+	if t.usage != nil {
+		t.usage.consume(int64(n))
+	}
+}
+
+// Finish 结束跟踪，cancelled 表示客户端在生成结束前断开
+func (t *StreamTracker) Finish(cancelled bool) {
+	t.m.ActiveStreams.Dec()
+	if cancelled {
+		t.m.StreamsCancelled.Inc()
+	} else {
+		t.m.StreamsCompleted.Inc()
+	}
+}
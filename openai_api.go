@@ -0,0 +1,355 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/cloudwego/eino/schema"
+)
+
+// errGenerationCancelled 生成被服务端取消（如关闭时排空超时）
+var errGenerationCancelled = errors.New("生成已被服务端取消")
+
+// OpenAIHandler 提供 OpenAI Chat Completions 兼容接口
+type OpenAIHandler struct {
+	pipeline  *StreamPipeline
+	lifecycle *ServerLifecycle
+	models    []string // 第一个为默认模型
+	delivery  DeliveryConfig
+}
+
+// ChatMessage OpenAI 格式的对话消息
+type ChatMessage struct {
+	Role      string            `json:"role"`
+	Content   string            `json:"content"`
+	ToolCalls []schema.ToolCall `json:"tool_calls,omitempty"`
+}
+
+// ChatCompletionRequest /v1/chat/completions 请求体
+type ChatCompletionRequest struct {
+	Messages []ChatMessage `json:"messages"`
+	Stream   bool          `json:"stream"`
+	GenerateOptions
+}
+
+// ChatCompletionChoice 非流式响应中的候选结果
+type ChatCompletionChoice struct {
+	Index        int         `json:"index"`
+	Message      ChatMessage `json:"message"`
+	FinishReason string      `json:"finish_reason"`
+}
+
+// ChatCompletionUsage token 用量统计
+type ChatCompletionUsage struct {
+	PromptTokens     int `json:"prompt_tokens"`
+	CompletionTokens int `json:"completion_tokens"`
+	TotalTokens      int `json:"total_tokens"`
+}
+
+// ChatCompletionResponse 非流式响应
+type ChatCompletionResponse struct {
+	ID      string                 `json:"id"`
+	Object  string                 `json:"object"`
+	Created int64                  `json:"created"`
+	Model   string                 `json:"model"`
+	Choices []ChatCompletionChoice `json:"choices"`
+	Usage   ChatCompletionUsage    `json:"usage"`
+}
+
+// ChatCompletionDelta 流式分片中的增量内容
+type ChatCompletionDelta struct {
+	Role      string            `json:"role,omitempty"`
+	Content   string            `json:"content,omitempty"`
+	ToolCalls []schema.ToolCall `json:"tool_calls,omitempty"`
+}
+
+// ChatCompletionChunkChoice 流式分片中的候选结果
+type ChatCompletionChunkChoice struct {
+	Index        int                 `json:"index"`
+	Delta        ChatCompletionDelta `json:"delta"`
+	FinishReason *string             `json:"finish_reason"`
+}
+
+// ChatCompletionChunk 流式响应分片
+type ChatCompletionChunk struct {
+	ID      string                      `json:"id"`
+	Object  string                      `json:"object"`
+	Created int64                       `json:"created"`
+	Model   string                      `json:"model"`
+	Choices []ChatCompletionChunkChoice `json:"choices"`
+}
+
+// ModelInfo /v1/models 中的模型描述
+type ModelInfo struct {
+	ID      string `json:"id"`
+	Object  string `json:"object"`
+	Created int64  `json:"created"`
+	OwnedBy string `json:"owned_by"`
+}
+
+// ModelList /v1/models 响应
+type ModelList struct {
+	Object string      `json:"object"`
+	Data   []ModelInfo `json:"data"`
+}
+
+// ChatCompletions 处理 POST /v1/chat/completions
+func (h *OpenAIHandler) ChatCompletions(w http.ResponseWriter, r *http.Request) {
+	var req ChatCompletionRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeOpenAIError(w, http.StatusBadRequest, "invalid_request_error", "请求体不是合法的 JSON: "+err.Error())
+		return
+	}
+	messages, err := toSchemaMessages(req.Messages)
+	if err != nil {
+		writeOpenAIError(w, http.StatusBadRequest, "invalid_request_error", err.Error())
+		return
+	}
+	req.Fixture = r.Header.Get(FixtureHeader)
+	if err := h.pipeline.ValidateOptions(&req.GenerateOptions); err != nil {
+		writeOpenAIError(w, http.StatusBadRequest, "invalid_request_error", err.Error())
+		return
+	}
+	applyQuota(r.Context(), &req.GenerateOptions)
+	adm, err := admissionFromRequest(r)
+	if err != nil {
+		writeOpenAIError(w, http.StatusBadRequest, "invalid_request_error", err.Error())
+		return
+	}
+	modelName := h.models[0]
+	if req.Model != "" {
+		modelName = req.Model
+	}
+
+	ctx, cancel := context.WithCancel(r.Context())
+	defer cancel()
+
+	end, ok := h.lifecycle.Begin()
+	if !ok {
+		metrics.RequestsRejected.Inc()
+		setRetryAfter(w, h.pipeline.RetryAfter())
+		writeOpenAIError(w, http.StatusServiceUnavailable, "server_overloaded", submitErrorMessage(ErrShuttingDown))
+		return
+	}
+	defer end()
+
+	id := newID("chatcmpl-")
+	created := time.Now().Unix()
+	streamReq := &StreamRequest{ID: id, Ctx: ctx, Messages: messages, Options: &req.GenerateOptions, Class: adm.Class, Tenant: adm.Tenant, Client: adm.Client, Output: make(chan *StreamResponse, 10)}
+	if err := h.pipeline.Submit(streamReq); err != nil {
+		metrics.RequestsRejected.Inc()
+		setRetryAfter(w, h.pipeline.RetryAfter())
+		writeOpenAIError(w, http.StatusServiceUnavailable, "server_overloaded", submitErrorMessage(err))
+		return
+	}
+
+	tracker := metrics.TrackStream(r.Context())
+	defer func() { tracker.Finish(r.Context().Err() != nil) }()
+
+	if !req.Stream {
+		var content strings.Builder
+		var toolCalls []schema.ToolCall
+		var usage *schema.TokenUsage
+		var reason DoneReason
+		completionTokens := 0
+		for res := range streamReq.Output {
+			for i, token := range res.Tokens {
+				content.WriteString(token)
+				tracker.Token(res.TokenCounts[i])
+				completionTokens += res.TokenCounts[i]
+			}
+			toolCalls = append(toolCalls, res.ToolCalls...)
+			if res.Usage != nil {
+				usage = res.Usage
+			}
+			if res.FinishReason != "" {
+				reason = res.FinishReason
+			}
+			if res.Err != nil {
+				err = res.Err
+			}
+		}
+		if err != nil {
+			writeOpenAIError(w, http.StatusBadGateway, "server_error", err.Error())
+			return
+		}
+		if reason == DoneCancelled {
+			writeOpenAIError(w, http.StatusServiceUnavailable, "server_error", errGenerationCancelled.Error())
+			return
+		}
+		writeJSON(w, http.StatusOK, &ChatCompletionResponse{
+			ID:      id,
+			Object:  "chat.completion",
+			Created: created,
+			Model:   modelName,
+			Choices: []ChatCompletionChoice{{
+				Message:      ChatMessage{Role: string(schema.Assistant), Content: content.String(), ToolCalls: toolCalls},
+				FinishReason: finishReason(toolCalls, reason),
+			}},
+			Usage: completionUsage(usage, completionTokens),
+		})
+		return
+	}
+
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		writeOpenAIError(w, http.StatusInternalServerError, "server_error", "Streaming unsupported")
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+
+	chunk := func(delta ChatCompletionDelta, finishReason *string) *ChatCompletionChunk {
+		return &ChatCompletionChunk{
+			ID:      id,
+			Object:  "chat.completion.chunk",
+			Created: created,
+			Model:   modelName,
+			Choices: []ChatCompletionChunkChoice{{Delta: delta, FinishReason: finishReason}},
+		}
+	}
+
+	// 首个分片只携带角色，与 OpenAI 行为保持一致
+	writeOpenAIChunk(w, chunk(ChatCompletionDelta{Role: string(schema.Assistant)}, nil))
+	flusher.Flush()
+
+	// 该接口直接消费生成器输出，没有回放缓冲区；写出超时后取消生成，避免慢客户端长期占用 worker
+	rc := http.NewResponseController(w)
+	writeTimeout := h.delivery.writeTimeout()
+
+	var toolCalls []schema.ToolCall
+	var doneReason DoneReason
+	for res := range streamReq.Output {
+		if writeTimeout > 0 {
+			rc.SetWriteDeadline(time.Now().Add(writeTimeout))
+		}
+		for i, token := range res.Tokens {
+			writeOpenAIChunk(w, chunk(ChatCompletionDelta{Content: token}, nil))
+			tracker.Token(res.TokenCounts[i])
+		}
+		if len(res.ToolCalls) > 0 {
+			toolCalls = append(toolCalls, res.ToolCalls...)
+			writeOpenAIChunk(w, chunk(ChatCompletionDelta{ToolCalls: res.ToolCalls}, nil))
+		}
+		if res.FinishReason != "" {
+			doneReason = res.FinishReason
+		}
+		if res.Err != nil {
+			err = res.Err
+		}
+		if ferr := rc.Flush(); ferr != nil && ctx.Err() == nil {
+			countWriteTimeout(ferr)
+			cancel()
+		}
+	}
+
+	// 客户端已断开时无需再发送结束标记
+	if ctx.Err() != nil {
+		return
+	}
+	if err == nil && doneReason == DoneCancelled {
+		err = errGenerationCancelled
+	}
+	// 流中途出错时按 OpenAI 的流式错误格式下发并结束
+	if err != nil {
+		data, _ := json.Marshal(map[string]any{"error": map[string]any{"message": err.Error(), "type": "server_error"}})
+		fmt.Fprintf(w, "data: %s\n\n", data)
+		flusher.Flush()
+		return
+	}
+	reason := finishReason(toolCalls, doneReason)
+	writeOpenAIChunk(w, chunk(ChatCompletionDelta{}, &reason))
+	fmt.Fprint(w, "data: [DONE]\n\n")
+	flusher.Flush()
+}
+
+// finishReason 转换为 OpenAI 的 finish_reason：tool_calls / length / stop
+func finishReason(toolCalls []schema.ToolCall, reason DoneReason) string {
+	if len(toolCalls) > 0 {
+		return "tool_calls"
+	}
+	if reason == DoneLength {
+		return "length"
+	}
+	return "stop"
+}
+
+// completionUsage 优先使用后端返回的用量，否则按输出 token 数估算
+func completionUsage(usage *schema.TokenUsage, completionTokens int) ChatCompletionUsage {
+	if usage != nil {
+		return ChatCompletionUsage{
+			PromptTokens:     usage.PromptTokens,
+			CompletionTokens: usage.CompletionTokens,
+			TotalTokens:      usage.TotalTokens,
+		}
+	}
+	return ChatCompletionUsage{CompletionTokens: completionTokens, TotalTokens: completionTokens}
+}
+
+// ListModels 处理 GET /v1/models
+func (h *OpenAIHandler) ListModels(w http.ResponseWriter, r *http.Request) {
+	list := &ModelList{Object: "list", Data: []ModelInfo{}}
+	for _, name := range h.models {
+		list.Data = append(list.Data, ModelInfo{
+			ID:      name,
+			Object:  "model",
+			Created: time.Now().Unix(),
+			OwnedBy: "ai-answer-demo",
+		})
+	}
+	writeJSON(w, http.StatusOK, list)
+}
+
+// toSchemaMessages 将 OpenAI 消息转换为 eino 消息并校验角色
+func toSchemaMessages(in []ChatMessage) ([]*schema.Message, error) {
+	if len(in) == 0 {
+		return nil, fmt.Errorf("messages 不能为空")
+	}
+	messages := make([]*schema.Message, 0, len(in))
+	for i, m := range in {
+		switch schema.RoleType(m.Role) {
+		case schema.System, schema.User, schema.Assistant:
+		default:
+			return nil, fmt.Errorf("messages[%d].role 不支持: %q", i, m.Role)
+		}
+		messages = append(messages, &schema.Message{Role: schema.RoleType(m.Role), Content: m.Content})
+	}
+	return messages, nil
+}
+
+func writeOpenAIChunk(w http.ResponseWriter, chunk *ChatCompletionChunk) {
+	data, _ := json.Marshal(chunk)
+	fmt.Fprintf(w, "data: %s\n\n", data)
+}
+
+// writeOpenAIError 按 OpenAI 错误格式返回
+func writeOpenAIError(w http.ResponseWriter, status int, errType, message string) {
+	writeJSON(w, status, map[string]any{
+		"error": map[string]any{
+			"message": message,
+			"type":    errType,
+		},
+	})
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+// newID 生成带前缀的随机 ID
+func newID(prefix string) string {
+	b := make([]byte, 12)
+	rand.Read(b)
+	return prefix + hex.EncodeToString(b)
+}
@@ -0,0 +1,269 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// 生成参数的取值上限
+const (
+	MaxTokensLimit   = 8192
+	MaxStopSequences = 4
+	MaxStopLength    = 64
+)
+
+// GenerateOptions 单次生成的请求参数，零值表示使用生成器默认值
+type GenerateOptions struct {
+	MaxTokens   int           `json:"max_tokens,omitempty"`
+	Stop        StopSequences `json:"stop,omitempty"`
+	Temperature *float32      `json:"temperature,omitempty"`
+	TopP        *float32      `json:"top_p,omitempty"`
+	Seed        *int          `json:"seed,omitempty"`
+	Model       string        `json:"model,omitempty"`
+
+	// Fixture 开发模式下由 X-Fixture 请求头指定的回放脚本，不从请求体读取
+	Fixture string `json:"-"`
+	// Budget 请求所属 API Key 当日剩余的 token 额度，由 limitStream 截断输出，不传给生成器；0 表示不限制
+	Budget int `json:"-"`
+}
+
+// StopSequences 停止序列，JSON 中兼容 OpenAI 的字符串或字符串数组两种写法
+type StopSequences []string
+
+func (s *StopSequences) UnmarshalJSON(data []byte) error {
+	var one string
+	if err := json.Unmarshal(data, &one); err == nil {
+		*s = StopSequences{one}
+		return nil
+	}
+	var many []string
+	if err := json.Unmarshal(data, &many); err != nil {
+		return fmt.Errorf("stop 必须是字符串或字符串数组")
+	}
+	*s = many
+	return nil
+}
+
+// OptionError 参数校验错误，Field 为出错的参数名
+type OptionError struct {
+	Field   string
+	Message string
+}
+
+func (e *OptionError) Error() string {
+	return e.Field + ": " + e.Message
+}
+
+// ParseQueryOptions 从查询参数解析生成参数，stop 可重复出现
+func ParseQueryOptions(q url.Values) (*GenerateOptions, error) {
+	opts := &GenerateOptions{Model: q.Get("model"), Stop: q["stop"]}
+
+	if v := q.Get("max_tokens"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil {
+			return nil, &OptionError{Field: "max_tokens", Message: "必须是整数"}
+		}
+		opts.MaxTokens = n
+	}
+	if v := q.Get("seed"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil {
+			return nil, &OptionError{Field: "seed", Message: "必须是整数"}
+		}
+		opts.Seed = &n
+	}
+	var err error
+	if opts.Temperature, err = parseFloatParam(q, "temperature"); err != nil {
+		return nil, err
+	}
+	if opts.TopP, err = parseFloatParam(q, "top_p"); err != nil {
+		return nil, err
+	}
+	return opts, nil
+}
+
+func parseFloatParam(q url.Values, name string) (*float32, error) {
+	v := q.Get(name)
+	if v == "" {
+		return nil, nil
+	}
+	f, err := strconv.ParseFloat(v, 32)
+	if err != nil {
+		return nil, &OptionError{Field: name, Message: "必须是数字"}
+	}
+	f32 := float32(f)
+	return &f32, nil
+}
+
+// Validate 校验参数取值范围，models 非空时 model 必须在其中。
+// NaN 与任何数比较都为 false，需单独排除
+func (o *GenerateOptions) Validate(models []string) error {
+	if o.MaxTokens < 0 || o.MaxTokens > MaxTokensLimit {
+		return &OptionError{Field: "max_tokens", Message: fmt.Sprintf("取值范围为 0~%d，0 表示使用默认值", MaxTokensLimit)}
+	}
+	if len(o.Stop) > MaxStopSequences {
+		return &OptionError{Field: "stop", Message: fmt.Sprintf("最多 %d 个停止序列", MaxStopSequences)}
+	}
+	for _, s := range o.Stop {
+		if s == "" || len(s) > MaxStopLength {
+			return &OptionError{Field: "stop", Message: fmt.Sprintf("停止序列长度须为 1~%d 字节", MaxStopLength)}
+		}
+	}
+	if o.Temperature != nil && (isNaN(*o.Temperature) || *o.Temperature < 0 || *o.Temperature > 2) {
+		return &OptionError{Field: "temperature", Message: "取值范围为 0~2"}
+	}
+	if o.TopP != nil && (isNaN(*o.TopP) || *o.TopP <= 0 || *o.TopP > 1) {
+		return &OptionError{Field: "top_p", Message: "取值范围为 (0, 1]"}
+	}
+	if o.Model != "" && len(models) > 0 && !slices.Contains(models, o.Model) {
+		return &OptionError{Field: "model", Message: fmt.Sprintf("不可用，可选值: %s", strings.Join(models, ", "))}
+	}
+	return nil
+}
+
+func isNaN(f float32) bool {
+	return math.IsNaN(float64(f))
+}
+
+// key 返回参数的规范化表示，用于生成去重
+func (o *GenerateOptions) key() string {
+	data, _ := json.Marshal(o)
+	return string(data) + "\x00" + o.Fixture + "\x00" + strconv.Itoa(o.Budget)
+}
+
+// tokenLimit 返回 max_tokens 与额度中较小的上限，0 表示不限制
+func (o *GenerateOptions) tokenLimit() int {
+	if o.Budget > 0 && (o.MaxTokens == 0 || o.Budget < o.MaxTokens) {
+		return o.Budget
+	}
+	return o.MaxTokens
+}
+
+// stopMatcher 在流式文本中查找停止序列。停止序列可能跨越 token 边界，
+// 因此可能构成停止序列前缀的尾部 token 会整个暂存，待后续 token 到达后再决定是否输出。
+// 输出总是由完整的 token 组成，返回的 token 数供下游计量
+type stopMatcher struct {
+	stops   []string
+	pending string
+	bounds  []int // pending 中各 token 的结束位置，递增
+}
+
+// Push 追加一个 token，返回可以安全输出的文本及其包含的 token 数，以及是否命中停止序列。
+// 命中时返回停止序列之前的文本，token 数只计含有这段文本的 token
+func (m *stopMatcher) Push(s string) (string, int, bool) {
+	m.pending += s
+	m.bounds = append(m.bounds, len(m.pending))
+
+	idx := -1
+	for _, stop := range m.stops {
+		if i := strings.Index(m.pending, stop); i >= 0 && (idx < 0 || i < idx) {
+			idx = i
+		}
+	}
+	if idx >= 0 {
+		n, start := 0, 0
+		for _, b := range m.bounds {
+			if start < idx {
+				n++
+			}
+			start = b
+		}
+		out := m.pending[:idx]
+		m.pending, m.bounds = "", nil
+		return out, n, true
+	}
+
+	hold := 0
+	for _, stop := range m.stops {
+		for k := min(len(stop)-1, len(m.pending)); k > hold; k-- {
+			if strings.HasSuffix(m.pending, stop[:k]) {
+				hold = k
+				break
+			}
+		}
+	}
+	// 截断点对齐到 token 边界，暂存的前缀所在的 token 整个留到下一次
+	n, cut := 0, 0
+	for _, b := range m.bounds {
+		if b > len(m.pending)-hold {
+			break
+		}
+		n, cut = n+1, b
+	}
+	out := m.pending[:cut]
+	m.pending = m.pending[cut:]
+	m.bounds = m.bounds[n:]
+	for i := range m.bounds {
+		m.bounds[i] -= cut
+	}
+	return out, n, false
+}
+
+// Flush 流结束时输出暂存的文本及其包含的 token 数
+func (m *stopMatcher) Flush() (string, int) {
+	out, n := m.pending, len(m.bounds)
+	m.pending, m.bounds = "", nil
+	return out, n
+}
+
+// limitStream 对任意生成器的输出统一施加 max_tokens、额度与停止序列限制，
+// 触发限制后调用 cancel 终止上游生成，并在最后一个分片中标记结束原因。
+// 停止序列暂存的 token 随之后输出的分片计入 Chunk.Tokens
+func limitStream(in <-chan Chunk, opts *GenerateOptions, cancel func()) <-chan Chunk {
+	limit := opts.tokenLimit()
+	if limit == 0 && len(opts.Stop) == 0 {
+		return in
+	}
+
+	out := make(chan Chunk, cap(in))
+	go func() {
+		defer close(out)
+		// 提前结束后继续排空上游，避免生成器阻塞在发送上
+		defer func() {
+			for range in {
+			}
+		}()
+
+		matcher := &stopMatcher{stops: opts.Stop}
+		tokens := 0
+		for chunk := range in {
+			if chunk.Content != "" {
+				tokens++
+				content, n, stopped := matcher.Push(chunk.Content)
+				chunk.Content, chunk.Tokens = content, n
+				if stopped {
+					chunk.FinishReason = DoneStop
+					out <- chunk
+					cancel()
+					return
+				}
+				if limit > 0 && tokens >= limit {
+					rest, n := matcher.Flush()
+					chunk.Content += rest
+					chunk.Tokens += n
+					chunk.FinishReason = DoneLength
+					out <- chunk
+					cancel()
+					return
+				}
+			}
+			if chunk.Err != nil {
+				rest, n := matcher.Flush()
+				chunk.Content += rest
+				chunk.Tokens += n
+			}
+			if !chunk.isEmpty() {
+				out <- chunk
+			}
+		}
+		if rest, n := matcher.Flush(); rest != "" {
+			out <- Chunk{Content: rest, Tokens: n}
+		}
+	}()
+	return out
+}
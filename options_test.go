@@ -0,0 +1,73 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestLimitStreamStop(t *testing.T) {
+	for _, tc := range []struct {
+		name      string
+		chunks    []string
+		stops     []string
+		maxTokens int
+		want      string
+		reason    DoneReason
+		tokens    int // 输出文本计入的 token 数
+	}{
+		{name: "跨两个 token", chunks: []string{"Hello EN", "D more"}, stops: []string{"END"}, want: "Hello ", reason: DoneStop, tokens: 1},
+		{name: "跨三个 token", chunks: []string{"a<", "|e", "nd|>b"}, stops: []string{"<|end|>"}, want: "a", reason: DoneStop, tokens: 1},
+		{name: "暂存的前缀最终不匹配", chunks: []string{"abc E", "Nx", "yz"}, stops: []string{"END"}, want: "abc ENxyz", tokens: 3},
+		{name: "前缀重叠的多个停止序列", chunks: []string{"1x", "y", "a2"}, stops: []string{"xyz", "xya"}, want: "1", reason: DoneStop, tokens: 1},
+		{name: "停止序列位于开头", chunks: []string{"EN", "D", "x"}, stops: []string{"END"}, want: "", reason: DoneStop, tokens: 0},
+		{name: "暂存时达到 max_tokens", chunks: []string{"hi E", "N", "more"}, stops: []string{"END"}, maxTokens: 2, want: "hi EN", reason: DoneLength, tokens: 2},
+		{name: "只有 max_tokens", chunks: []string{"a", "b", "c"}, maxTokens: 2, want: "ab", reason: DoneLength, tokens: 2},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			in := make(chan Chunk, len(tc.chunks))
+			for _, c := range tc.chunks {
+				in <- Chunk{Content: c}
+			}
+			close(in)
+			cancelled := false
+			out := limitStream(in, &GenerateOptions{MaxTokens: tc.maxTokens, Stop: tc.stops}, func() { cancelled = true })
+
+			var got strings.Builder
+			var reason DoneReason
+			tokens := 0
+			for c := range out {
+				if c.Content != "" && c.Tokens == 0 {
+					t.Errorf("分片 %q 未携带 token 数", c.Content)
+				}
+				got.WriteString(c.Content)
+				tokens += c.tokenCount()
+				if c.FinishReason != "" {
+					reason = c.FinishReason
+				}
+			}
+			if got.String() != tc.want || reason != tc.reason || tokens != tc.tokens {
+				t.Errorf("输出 %q（%q，%d 个 token），期望 %q（%q，%d 个 token）", got.String(), reason, tokens, tc.want, tc.reason, tc.tokens)
+			}
+			if cancelled != (tc.reason != "") {
+				t.Errorf("cancel 调用 = %v", cancelled)
+			}
+		})
+	}
+}
+
+func TestStopMatcherHoldsWholeTokens(t *testing.T) {
+	m := &stopMatcher{stops: []string{"END"}}
+	if out, n, stopped := m.Push("abc"); out != "abc" || n != 1 || stopped {
+		t.Fatalf("Push(abc) = %q, %d, %v", out, n, stopped)
+	}
+	// 末尾的 E 可能是停止序列的前缀，所在的 token 整个暂存
+	if out, n, _ := m.Push("xyE"); out != "" || n != 0 {
+		t.Fatalf("Push(xyE) = %q, %d，期望整个 token 暂存", out, n)
+	}
+	if out, n, _ := m.Push("!"); out != "xyE!" || n != 2 {
+		t.Fatalf("Push(!) = %q, %d，期望释放两个 token", out, n)
+	}
+	if out, n := m.Flush(); out != "" || n != 0 {
+		t.Fatalf("Flush() = %q, %d", out, n)
+	}
+}
@@ -0,0 +1,489 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+	"unicode/utf8"
+
+	"ai-answer-demo/tracing"
+
+	"github.com/cloudwego/eino/schema"
+)
+
+var (
+	// ErrQueueFull 排队请求数已达上限
+	ErrQueueFull = errors.New("stream queue is full")
+	// ErrShuttingDown 服务正在关闭，不再接收新的请求
+	ErrShuttingDown = errors.New("server is shutting down")
+)
+
+// 流式请求结构体
+type StreamRequest struct {
+	ID       string
+	Ctx      context.Context
+	Prompt   string
+	Messages []*schema.Message // 非空时优先于 Prompt
+	Options  *GenerateOptions  // 为空时使用生成器默认参数
+	// 排队优先级与所属租户，决定在 fairQueue 中的出队顺序；为空时为 standard 与 anonymous
+	Class  PriorityClass
+	Tenant string
+	// 客户端地址，仅用于 /admin/streams 展示
+	Client string
+
+	// Output 非空时该请求的响应逐 token 写入此 channel，处理结束后关闭；
+	// 为空时按 BatchPolicy 攒批写入 StreamPipeline 的共享输出（见 Responses）
+	Output chan *StreamResponse
+
+	started   chan struct{}
+	submitted time.Time
+	waitSpan  *tracing.Span      // 排队等待，worker 取出时结束
+	cancel    context.CancelFunc // 取消 Ctx，供 StreamPipeline.Cancel 使用
+	tokens    atomic.Int64       // 已生成的 token 数
+}
+
+// Started 返回请求被 worker 取出时关闭的 channel
+func (r *StreamRequest) Started() <-chan struct{} {
+	return r.started
+}
+
+// 流式响应结构体。同一请求的响应按 Seq 顺序下发，最后一个响应 Final 为 true，
+// 携带结束原因、用量与错误，之后不会再有该请求的响应
+type StreamResponse struct {
+	ID     string
+	Seq    int // 同一请求内从 0 连续递增
+	Tokens []string
+	// 与 Tokens 一一对应，每段文本代表的上游 token 数；内容过滤可能把多个 token 合为一段
+	TokenCounts []int
+	ToolCalls   []schema.ToolCall
+	// 多后端路由切换的后端，下发时排在 Moderations 与 Tokens 之前
+	Failovers []Failover
+	// 内容过滤的处置，对应本响应的第一个 token，下发时排在 Tokens 之前
+	Moderations []Moderation
+
+	Final        bool
+	FinishReason DoneReason
+	Usage        *schema.TokenUsage
+	Err          error
+	ErrCode      string // Err 非空时的错误码，见 ErrCodeGeneration
+}
+
+// StreamPipeline 有界的流式 worker 池：请求在 fairQueue 中按优先级与租户公平排队，
+// worker 数即最大并发生成数
+type StreamPipeline struct {
+	queue      *fairQueue
+	outputChan chan *StreamResponse
+	model      ModelGenerator
+	models     []string       // 允许按请求选择的模型，为空时不限制
+	journal    *Journal       // 非空时记录每次生成
+	filter     *ContentFilter // 非空时对生成内容做过滤
+	batch      BatchPolicy
+
+	stopCtx   context.Context // CancelAll 后取消，所有请求的生成都会随之中断
+	cancelAll context.CancelFunc
+	draining  atomic.Bool
+
+	workers     atomic.Int64 // 目标 worker 数，见 SetWorkers
+	resizeMu    sync.Mutex
+	avgDuration atomic.Int64 // 单次生成耗时的滑动平均（纳秒），用于估算 Retry-After
+
+	mu     sync.Mutex
+	active map[string]*StreamRequest // 排队中与进行中的请求，按 ID 索引
+}
+
+// NewStreamPipeline 创建 worker 池，classes 为各优先级的调度参数，为空时各优先级权重相同
+func NewStreamPipeline(model ModelGenerator, maxQueue int, models []string, classes map[PriorityClass]PriorityClassConfig) *StreamPipeline {
+	stopCtx, cancelAll := context.WithCancel(context.Background())
+	return &StreamPipeline{
+		queue:      newFairQueue(maxQueue, classes),
+		outputChan: make(chan *StreamResponse, maxQueue),
+		model:      model,
+		models:     models,
+		stopCtx:    stopCtx,
+		cancelAll:  cancelAll,
+		active:     make(map[string]*StreamRequest),
+	}
+}
+
+// SetJournal 设置生成记录日志，需在 StartWorkers 之前调用
+func (p *StreamPipeline) SetJournal(j *Journal) {
+	p.journal = j
+}
+
+// SetContentFilter 设置内容过滤，需在 StartWorkers 之前调用
+func (p *StreamPipeline) SetContentFilter(f *ContentFilter) {
+	p.filter = f
+}
+
+// SetBatchPolicy 设置共享输出的攒批策略，默认逐 token 下发，需在 StartWorkers 之前调用
+func (p *StreamPipeline) SetBatchPolicy(policy BatchPolicy) {
+	p.batch = policy
+}
+
+// Responses 未设置 Output 的请求的共享输出，各请求的响应交错到达，按 ID 与 Seq 区分。
+// 使用共享输出时调用方必须持续读取，否则 worker 会阻塞在下发上
+func (p *StreamPipeline) Responses() <-chan *StreamResponse {
+	return p.outputChan
+}
+
+// StopAccepting 停止接收新请求，已排队与进行中的请求不受影响
+func (p *StreamPipeline) StopAccepting() {
+	p.draining.Store(true)
+}
+
+// CancelAll 中断所有排队与进行中的生成，被中断的请求以 DoneCancelled 结束
+func (p *StreamPipeline) CancelAll() {
+	p.cancelAll()
+}
+
+// Stop 停止接收新请求、中断进行中的生成并让全部 worker 退出，排队中的请求不再处理。
+// 用于测试与基准结束时回收 worker，服务关闭走 StopAccepting 与 CancelAll
+func (p *StreamPipeline) Stop() {
+	p.StopAccepting()
+	p.CancelAll()
+	p.SetWorkers(0)
+}
+
+// ValidateOptions 校验生成参数，返回 *OptionError
+func (p *StreamPipeline) ValidateOptions(opts *GenerateOptions) error {
+	if err := opts.Validate(p.models); err != nil {
+		return err
+	}
+	if opts.Fixture != "" {
+		scripted, ok := p.model.(*ScriptedGenerator)
+		if !ok {
+			return &OptionError{Field: "fixture", Message: "回放脚本仅在开发模式下可用"}
+		}
+		if _, err := scripted.Load(opts.Fixture); err != nil {
+			return &OptionError{Field: "fixture", Message: err.Error()}
+		}
+	}
+	return nil
+}
+
+// Submit 非阻塞地提交请求，队列已满时返回 ErrQueueFull，关闭中返回 ErrShuttingDown
+func (p *StreamPipeline) Submit(req *StreamRequest) error {
+	if p.draining.Load() {
+		return ErrShuttingDown
+	}
+	if req.Class == "" {
+		req.Class = PriorityStandard
+	}
+	if req.Tenant == "" {
+		req.Tenant = anonymousTenant
+	}
+	req.started = make(chan struct{})
+	req.submitted = time.Now()
+	req.Ctx, req.cancel = context.WithCancel(req.Ctx)
+	_, req.waitSpan = tracing.Start(req.Ctx, "pipeline.wait",
+		tracing.String("generation.id", req.ID),
+		tracing.String("queue.class", string(req.Class)),
+		tracing.String("queue.tenant", req.Tenant),
+		tracing.Int("queue.depth", p.queue.Len()))
+
+	p.mu.Lock()
+	p.active[req.ID] = req
+	p.mu.Unlock()
+	if err := p.queue.Push(req); err != nil {
+		p.untrack(req)
+		metrics.queueRejected(req.Class).Inc()
+		req.waitSpan.RecordError(err)
+		req.waitSpan.End()
+		return err
+	}
+	return nil
+}
+
+// untrack 请求结束后移出 active 并释放其 context
+func (p *StreamPipeline) untrack(req *StreamRequest) {
+	req.cancel()
+	p.mu.Lock()
+	if p.active[req.ID] == req {
+		delete(p.active, req.ID)
+	}
+	p.mu.Unlock()
+}
+
+// StreamInfo 一个排队中或进行中的请求，供 /admin/streams 展示
+type StreamInfo struct {
+	ID           string        `json:"id"`
+	State        string        `json:"state"` // queued / running
+	Client       string        `json:"client,omitempty"`
+	Tenant       string        `json:"tenant"`
+	Class        PriorityClass `json:"class"`
+	PromptPrefix string        `json:"prompt_prefix"`
+	Tokens       int64         `json:"tokens"`
+	AgeSeconds   float64       `json:"age_seconds"`
+	SubmittedAt  time.Time     `json:"submitted_at"`
+}
+
+// promptPrefixRunes StreamInfo.PromptPrefix 的最大字符数
+const promptPrefixRunes = 64
+
+// Streams 返回排队中与进行中的请求，按提交时间从早到晚排序
+func (p *StreamPipeline) Streams() []StreamInfo {
+	p.mu.Lock()
+	reqs := make([]*StreamRequest, 0, len(p.active))
+	for _, req := range p.active {
+		reqs = append(reqs, req)
+	}
+	p.mu.Unlock()
+
+	now := time.Now()
+	list := make([]StreamInfo, 0, len(reqs))
+	for _, req := range reqs {
+		info := StreamInfo{
+			ID:           req.ID,
+			State:        "queued",
+			Client:       req.Client,
+			Tenant:       req.Tenant,
+			Class:        req.Class,
+			PromptPrefix: promptPrefix(req),
+			Tokens:       req.tokens.Load(),
+			AgeSeconds:   now.Sub(req.submitted).Seconds(),
+			SubmittedAt:  req.submitted,
+		}
+		select {
+		case <-req.started:
+			info.State = "running"
+		default:
+		}
+		list = append(list, info)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].SubmittedAt.Before(list[j].SubmittedAt) })
+	return list
+}
+
+// promptPrefix 返回最后一条用户消息（没有时为最后一条消息）的开头
+func promptPrefix(req *StreamRequest) string {
+	text := req.Prompt
+	for i := len(req.Messages) - 1; i >= 0; i-- {
+		if text == "" || req.Messages[i].Role == schema.User {
+			text = req.Messages[i].Content
+		}
+		if req.Messages[i].Role == schema.User {
+			break
+		}
+	}
+	if utf8.RuneCountInString(text) <= promptPrefixRunes {
+		return text
+	}
+	runes := []rune(text)
+	return string(runes[:promptPrefixRunes]) + "…"
+}
+
+// Cancel 取消指定请求的 context：排队中的请求在被取出时直接结束，进行中的生成中断，
+// 两者都以 DoneCancelled 结束。请求不存在或已结束时返回 false
+func (p *StreamPipeline) Cancel(id string) bool {
+	p.mu.Lock()
+	req, ok := p.active[id]
+	p.mu.Unlock()
+	if ok {
+		req.cancel()
+	}
+	return ok
+}
+
+// Position 返回请求的排队位置，1 表示下一个被处理，0 表示已开始处理
+func (p *StreamPipeline) Position(req *StreamRequest) int {
+	select {
+	case <-req.started:
+		return 0
+	default:
+	}
+	return p.queue.Position(req)
+}
+
+// QueueDepth 返回当前排队的请求数
+func (p *StreamPipeline) QueueDepth() int {
+	return p.queue.Len()
+}
+
+// ClassQueueDepth 返回某优先级当前排队的请求数
+func (p *StreamPipeline) ClassQueueDepth(class PriorityClass) int {
+	return p.queue.ClassLen(class)
+}
+
+// RetryAfter 根据排队长度与平均生成耗时估算客户端的重试等待时间
+func (p *StreamPipeline) RetryAfter() time.Duration {
+	avg := time.Duration(p.avgDuration.Load())
+	if avg <= 0 {
+		avg = time.Second
+	}
+	workers := p.workers.Load()
+	if workers <= 0 {
+		workers = 1
+	}
+	d := avg * time.Duration(int64(p.QueueDepth())/workers+1)
+	if d < time.Second {
+		d = time.Second
+	}
+	return d
+}
+
+func (p *StreamPipeline) StartWorkers(num int) {
+	p.resizeMu.Lock()
+	defer p.resizeMu.Unlock()
+	p.workers.Add(int64(num))
+	for i := 0; i < num; i++ {
+		go p.worker()
+	}
+}
+
+// SetWorkers 在运行时调整 worker 数。增加时立即启动新的 worker；减少时空闲的 worker 立即退出，
+// 忙碌的在完成当前生成后退出，进行中的生成不受影响
+func (p *StreamPipeline) SetWorkers(num int) {
+	p.resizeMu.Lock()
+	defer p.resizeMu.Unlock()
+	delta := num - int(p.workers.Swap(int64(num)))
+	if delta > 0 {
+		// 尚未退出的 worker 优先保留，不足的部分再启动
+		for i := p.queue.Unretire(delta); i < delta; i++ {
+			go p.worker()
+		}
+	} else if delta < 0 {
+		p.queue.Retire(-delta)
+	}
+}
+
+// Workers 返回目标 worker 数
+func (p *StreamPipeline) Workers() int {
+	return int(p.workers.Load())
+}
+
+func (p *StreamPipeline) worker() {
+	for {
+		req := p.queue.Pop()
+		if req == nil {
+			return
+		}
+		close(req.started)
+		metrics.queueWait(req.Class).ObserveDuration(time.Since(req.submitted))
+		req.waitSpan.End()
+
+		start := time.Now()
+		p.process(req)
+		p.observeDuration(time.Since(start))
+	}
+}
+
+func (p *StreamPipeline) process(req *StreamRequest) {
+	out, policy := p.outputChan, p.batch
+	if req.Output != nil {
+		out, policy = req.Output, interactiveBatch
+		defer close(req.Output)
+	}
+	b := newBatcher(req.ID, policy, out)
+	defer p.untrack(req)
+
+	ctx, cancel := context.WithCancel(req.Ctx)
+	defer cancel()
+	// 排队期间客户端已放弃，直接结束
+	if ctx.Err() != nil {
+		b.finish(DoneCancelled, nil)
+		return
+	}
+	// 服务关闭时中断生成，以 DoneCancelled 结束
+	defer context.AfterFunc(p.stopCtx, cancel)()
+
+	messages := req.Messages
+	if messages == nil {
+		messages = promptMessages(req.Prompt)
+	}
+	opts := req.Options
+	if opts == nil {
+		opts = &GenerateOptions{}
+	}
+	entry := p.journal.begin(req, messages, opts)
+
+	// 生成器调用的 span，ctx 携带它，生成器内部的 span 挂在其下
+	attrs := []tracing.Attr{tracing.String("generation.id", req.ID), tracing.Int("gen_ai.request.max_tokens", opts.MaxTokens)}
+	if opts.Model != "" {
+		attrs = append(attrs, tracing.String("gen_ai.request.model", opts.Model))
+	}
+	ctx, span := tracing.Start(ctx, "generator.stream", attrs...)
+	defer span.End()
+
+	// 二级缓冲管道
+	intermediate := make(chan Chunk, 10)
+	go func() {
+		defer close(intermediate)
+		for chunk := range filterStream(limitStream(p.model.Stream(ctx, messages, opts), opts, cancel), p.filter) {
+			intermediate <- chunk
+		}
+	}()
+
+	// 组装响应：token 按策略攒批，工具调用立即下发，用量、错误与结束原因随最后一个响应下发
+	outcome, explicit := DoneStop, false
+	var genErr error
+	tokens := 0
+loop:
+	for {
+		select {
+		case chunk, ok := <-intermediate:
+			if !ok {
+				break loop
+			}
+			entry.chunk(chunk)
+			if chunk.FinishReason != "" {
+				outcome, explicit = chunk.FinishReason, true
+			}
+			if chunk.Err != nil {
+				outcome, explicit = DoneError, true
+				genErr = chunk.Err
+			}
+			if chunk.Usage != nil {
+				b.usage = chunk.Usage
+			}
+			if f := chunk.Failover; f != nil {
+				span.AddEvent("failover", tracing.String("router.from", f.From), tracing.String("router.to", f.To), tracing.Int("router.tokens", f.Tokens))
+				b.addFailover(*f)
+			}
+			for _, m := range chunk.Moderations {
+				metrics.moderation(m.Rule).Inc()
+				span.AddEvent("moderation", tracing.String("moderation.rule", m.Rule))
+			}
+			if len(chunk.Moderations) > 0 {
+				b.addModerations(chunk.Moderations)
+			}
+			if chunk.Content != "" || chunk.Tokens > 0 {
+				if tokens == 0 {
+					span.AddEvent("first_token")
+				}
+				n := chunk.tokenCount()
+				tokens += n
+				req.tokens.Add(int64(n))
+				b.addToken(chunk.Content, n)
+			}
+			if len(chunk.ToolCalls) > 0 {
+				b.addToolCalls(chunk.ToolCalls)
+			}
+		case <-b.Deadline():
+			b.flush()
+		}
+	}
+	// 提前结束（停止序列、max_tokens）也会取消 ctx，只有未给出结束原因时才视为被取消
+	if !explicit && ctx.Err() != nil {
+		outcome = DoneCancelled
+	}
+	entry.finish(outcome)
+	span.SetAttrs(tracing.Int("gen_ai.response.tokens", tokens), tracing.String("gen_ai.response.finish_reason", string(outcome)))
+	if b.usage != nil {
+		span.SetAttrs(tracing.Int("gen_ai.usage.input_tokens", b.usage.PromptTokens), tracing.Int("gen_ai.usage.output_tokens", b.usage.CompletionTokens))
+	}
+	span.RecordError(genErr)
+	b.finish(outcome, genErr)
+}
+
+// observeDuration 以 1/8 权重更新平均生成耗时
+func (p *StreamPipeline) observeDuration(d time.Duration) {
+	old := p.avgDuration.Load()
+	if old == 0 {
+		p.avgDuration.Store(int64(d))
+		return
+	}
+	p.avgDuration.Store(old + (int64(d)-old)/8)
+}
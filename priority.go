@@ -0,0 +1,334 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"sync"
+)
+
+// PriorityClass 排队优先级
+type PriorityClass string
+
+const (
+	PriorityInteractive PriorityClass = "interactive"
+	PriorityStandard    PriorityClass = "standard"
+	PriorityBatch       PriorityClass = "batch"
+)
+
+// priorityClasses 按优先级从高到低排列
+var priorityClasses = []PriorityClass{PriorityInteractive, PriorityStandard, PriorityBatch}
+
+// PriorityHeader 请求指定优先级的请求头，只能等于或低于 API Key 的优先级
+const PriorityHeader = "X-Priority"
+
+// anonymousTenant 未启用认证时所有请求同属的租户
+const anonymousTenant = "anonymous"
+
+// rank 优先级序号，越小越优先；未知取值按 standard 处理
+func (c PriorityClass) rank() int {
+	for i, class := range priorityClasses {
+		if c == class {
+			return i
+		}
+	}
+	return 1
+}
+
+// ParsePriorityClass 解析优先级，空字符串返回 standard
+func ParsePriorityClass(v string) (PriorityClass, error) {
+	if v == "" {
+		return PriorityStandard, nil
+	}
+	for _, class := range priorityClasses {
+		if PriorityClass(v) == class {
+			return class, nil
+		}
+	}
+	return "", &OptionError{Field: "priority", Message: "取值为 interactive、standard 或 batch"}
+}
+
+// PriorityClassConfig 一个优先级的调度参数
+type PriorityClassConfig struct {
+	// 调度权重：各优先级都有请求排队时，按权重比例分配出队机会
+	Weight int `json:"weight"`
+	// 该优先级最多排队的请求数，0 表示只受总排队上限限制
+	MaxQueue int `json:"max_queue"`
+}
+
+// Admission 请求的排队身份：优先级与所属租户
+type Admission struct {
+	Class  PriorityClass
+	Tenant string
+	// 客户端地址，仅用于 /admin/streams 展示
+	Client string
+}
+
+// admissionFromRequest 根据 API Key 与 X-Priority 请求头确定排队身份。
+// 未指定时使用 API Key 的优先级；请求头高于 API Key 的优先级时降为 API Key 的优先级，
+// 防止调用方自行抬高优先级
+func admissionFromRequest(r *http.Request) (Admission, error) {
+	adm := Admission{Class: PriorityStandard, Tenant: tenantFromContext(r.Context()), Client: r.RemoteAddr}
+	key := APIKeyFromContext(r.Context())
+	if key != nil {
+		if key.Priority != "" {
+			adm.Class = key.Priority
+		}
+	}
+	if v := r.Header.Get(PriorityHeader); v != "" {
+		class, err := ParsePriorityClass(v)
+		if err != nil {
+			return adm, err
+		}
+		if key == nil || class.rank() >= adm.Class.rank() {
+			adm.Class = class
+		}
+	}
+	return adm, nil
+}
+
+// tenantFromContext 返回请求所属的租户：API Key 的 ID，未启用认证时为 anonymousTenant
+func tenantFromContext(ctx context.Context) string {
+	if key := APIKeyFromContext(ctx); key != nil {
+		return key.ID
+	}
+	return anonymousTenant
+}
+
+// fairQueue 替代单一 FIFO 的排队结构：优先级之间按权重做 stride 调度，
+// 同一优先级内各租户轮流出队（等权重的 stride 调度），同一租户内保持先进先出。
+// 调度基于虚拟时间：刚开始排队的优先级或租户从当前虚拟时间起算，不能积攒空闲期间的份额
+type fairQueue struct {
+	mu       sync.Mutex
+	nonEmpty *sync.Cond
+
+	classes []*classQueue // 按 rank 索引
+	vtime   float64
+	size    int
+	max     int
+	retire  int // 待退出的 worker 数，Pop 优先让调用方退出
+}
+
+// classQueue 一个优先级的排队状态
+type classQueue struct {
+	class  PriorityClass
+	stride float64
+	max    int
+
+	pass    float64
+	vtime   float64
+	size    int
+	tenants map[string]*tenantQueue
+	active  []*tenantQueue // 有请求排队的租户，按开始排队的先后排列
+}
+
+// tenantQueue 一个租户在某优先级中的请求
+type tenantQueue struct {
+	id   string
+	pass float64
+	reqs []*StreamRequest
+}
+
+func newFairQueue(max int, classes map[PriorityClass]PriorityClassConfig) *fairQueue {
+	q := &fairQueue{max: max}
+	q.nonEmpty = sync.NewCond(&q.mu)
+	for _, class := range priorityClasses {
+		cfg := classes[class]
+		weight := cfg.Weight
+		if weight <= 0 {
+			weight = 1
+		}
+		q.classes = append(q.classes, &classQueue{
+			class:   class,
+			stride:  1 / float64(weight),
+			max:     cfg.MaxQueue,
+			tenants: make(map[string]*tenantQueue),
+		})
+	}
+	return q
+}
+
+// Retire 让 n 个 worker 退出：空闲的立即从 Pop 返回 nil，忙碌的在下一次 Pop 时返回 nil
+func (q *fairQueue) Retire(n int) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.retire += n
+	q.nonEmpty.Broadcast()
+}
+
+// Unretire 撤销至多 n 个尚未生效的退出，返回撤销的个数
+func (q *fairQueue) Unretire(n int) int {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	n = min(n, q.retire)
+	q.retire -= n
+	return n
+}
+
+// Push 请求入队，总排队数或该优先级排队数已达上限时返回 ErrQueueFull
+func (q *fairQueue) Push(req *StreamRequest) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	c := q.classes[req.Class.rank()]
+	if q.size >= q.max || (c.max > 0 && c.size >= c.max) {
+		return ErrQueueFull
+	}
+
+	if c.size == 0 {
+		c.pass = max(c.pass, q.vtime)
+	}
+	t, ok := c.tenants[req.Tenant]
+	if !ok {
+		t = &tenantQueue{id: req.Tenant, pass: c.vtime}
+		c.tenants[req.Tenant] = t
+		c.active = append(c.active, t)
+	}
+	t.reqs = append(t.reqs, req)
+	c.size++
+	q.size++
+	q.nonEmpty.Signal()
+	return nil
+}
+
+// Pop 按调度顺序取出下一个请求，队列为空时阻塞；有待退出的 worker 时返回 nil（见 Retire）
+func (q *fairQueue) Pop() *StreamRequest {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	for q.size == 0 && q.retire == 0 {
+		q.nonEmpty.Wait()
+	}
+	if q.retire > 0 {
+		q.retire--
+		return nil
+	}
+
+	c := q.nextClass()
+	q.vtime = c.pass
+	c.pass += c.stride
+
+	t := c.nextTenant()
+	c.vtime = t.pass
+	t.pass++
+
+	req := t.reqs[0]
+	t.reqs[0] = nil
+	t.reqs = t.reqs[1:]
+	if len(t.reqs) == 0 {
+		// 租户不再排队时移除，再次排队时从当时的虚拟时间起算
+		delete(c.tenants, t.id)
+		for i, a := range c.active {
+			if a == t {
+				c.active = append(c.active[:i], c.active[i+1:]...)
+				break
+			}
+		}
+	}
+	c.size--
+	q.size--
+	return req
+}
+
+// nextClass 返回 pass 最小的非空优先级，相同时取优先级高的
+func (q *fairQueue) nextClass() *classQueue {
+	var next *classQueue
+	for _, c := range q.classes {
+		if c.size > 0 && (next == nil || c.pass < next.pass) {
+			next = c
+		}
+	}
+	return next
+}
+
+// nextTenant 返回 pass 最小的租户，相同时取先开始排队的
+func (c *classQueue) nextTenant() *tenantQueue {
+	next := c.active[0]
+	for _, t := range c.active[1:] {
+		if t.pass < next.pass {
+			next = t
+		}
+	}
+	return next
+}
+
+// Len 当前排队的请求数
+func (q *fairQueue) Len() int {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return q.size
+}
+
+// ClassLen 某优先级当前排队的请求数
+func (q *fairQueue) ClassLen(class PriorityClass) int {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return q.classes[class.rank()].size
+}
+
+// Position 返回请求按当前调度顺序的排队位置，1 表示下一个出队，不在队列中时返回 0。
+// 在状态副本上模拟出队过程，排队上限通常只有几十，开销可以忽略
+func (q *fairQueue) Position(req *StreamRequest) int {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	c := q.classes[req.Class.rank()]
+	t, ok := c.tenants[req.Tenant]
+	if !ok {
+		return 0
+	}
+	index := -1
+	for i, r := range t.reqs {
+		if r == req {
+			index = i
+			break
+		}
+	}
+	if index < 0 {
+		return 0
+	}
+
+	type simTenant struct {
+		t    *tenantQueue
+		pass float64
+		left int
+	}
+	type simClass struct {
+		pass, stride float64
+		left         int
+		tenants      []*simTenant
+	}
+	classes := make([]*simClass, len(q.classes))
+	var target *simTenant
+	for i, qc := range q.classes {
+		sc := &simClass{pass: qc.pass, stride: qc.stride, left: qc.size}
+		for _, qt := range qc.active {
+			st := &simTenant{t: qt, pass: qt.pass, left: len(qt.reqs)}
+			if qt == t {
+				target = st
+			}
+			sc.tenants = append(sc.tenants, st)
+		}
+		classes[i] = sc
+	}
+
+	for pos := 1; ; pos++ {
+		var sc *simClass
+		for _, c := range classes {
+			if c.left > 0 && (sc == nil || c.pass < sc.pass) {
+				sc = c
+			}
+		}
+		sc.pass += sc.stride
+		sc.left--
+
+		var st *simTenant
+		for _, t := range sc.tenants {
+			if t.left > 0 && (st == nil || t.pass < st.pass) {
+				st = t
+			}
+		}
+		st.pass++
+		if st == target && len(st.t.reqs)-st.left == index {
+			return pos
+		}
+		st.left--
+	}
+}
@@ -0,0 +1,166 @@
+package main
+
+import (
+	"fmt"
+	"math/rand"
+	"testing"
+)
+
+var testClasses = map[PriorityClass]PriorityClassConfig{
+	PriorityInteractive: {Weight: 8},
+	PriorityStandard:    {Weight: 4},
+	PriorityBatch:       {Weight: 1},
+}
+
+// pushN 向 q 推入 n 个请求，ID 为 <tenant>-<class>-<序号>
+func pushN(t *testing.T, q *fairQueue, class PriorityClass, tenant string, n int) {
+	t.Helper()
+	for i := 0; i < n; i++ {
+		req := &StreamRequest{ID: fmt.Sprintf("%s-%s-%d", tenant, class, i), Class: class, Tenant: tenant}
+		if err := q.Push(req); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+// popN 取出 n 个请求，按 "<class>/<tenant>" 计数
+func popN(q *fairQueue, n int) map[string]int {
+	counts := make(map[string]int)
+	for i := 0; i < n; i++ {
+		req := q.Pop()
+		counts[string(req.Class)+"/"+req.Tenant]++
+	}
+	return counts
+}
+
+// TestFairQueueClassWeights 先排队的大量 batch 请求不会挡住 interactive，也不会被 interactive 饿死
+func TestFairQueueClassWeights(t *testing.T) {
+	q := newFairQueue(1000, testClasses)
+	pushN(t, q, PriorityBatch, "a", 100)
+	if got := popN(q, 1); got["batch/a"] != 1 {
+		t.Fatalf("只有 batch 排队时应出队 batch，得到 %v", got)
+	}
+	pushN(t, q, PriorityInteractive, "a", 100)
+	// 权重 8:1，batch 最多连续等待 9 次 interactive 出队
+	run, batch := 0, 0
+	for i := 0; i < 90; i++ {
+		if q.Pop().Class == PriorityBatch {
+			run = 0
+			batch++
+			continue
+		}
+		if run++; run > 9 {
+			t.Fatalf("第 %d 次出队：interactive 已连续出队 %d 次，batch 被饿死", i+1, run)
+		}
+	}
+	if batch != 9 {
+		t.Errorf("90 次出队中 batch %d 次，期望 9", batch)
+	}
+}
+
+// TestFairQueueTenants 同一优先级内各租户轮流出队，积压多的租户不能挤占其他租户
+func TestFairQueueTenants(t *testing.T) {
+	q := newFairQueue(1000, testClasses)
+	pushN(t, q, PriorityStandard, "big", 20)
+	pushN(t, q, PriorityStandard, "small", 3)
+	var order []string
+	for i := 0; i < 8; i++ {
+		order = append(order, q.Pop().Tenant)
+	}
+	want := []string{"big", "small", "big", "small", "big", "small", "big", "big"}
+	if fmt.Sprint(order) != fmt.Sprint(want) {
+		t.Errorf("出队顺序 %v，期望 %v", order, want)
+	}
+}
+
+// TestFairQueueWeightsAcrossTenants 租户数不影响优先级之间的份额，优先级内租户平分
+func TestFairQueueWeightsAcrossTenants(t *testing.T) {
+	q := newFairQueue(1000, testClasses)
+	for _, tenant := range []string{"a", "b", "c", "d"} {
+		pushN(t, q, PriorityBatch, tenant, 20)
+	}
+	pushN(t, q, PriorityInteractive, "a", 40)
+	pushN(t, q, PriorityInteractive, "b", 40)
+	pushN(t, q, PriorityStandard, "e", 40)
+
+	// 权重 8:4:1，每 13 次出队 interactive 8 次、standard 4 次、batch 1 次
+	got := popN(q, 26)
+	want := map[string]int{"interactive/a": 8, "interactive/b": 8, "standard/e": 8}
+	for k, n := range want {
+		if got[k] != n {
+			t.Errorf("26 次出队中 %s = %d，期望 %d（全部 %v）", k, got[k], n, got)
+		}
+	}
+	batch := got["batch/a"] + got["batch/b"] + got["batch/c"] + got["batch/d"]
+	if batch != 2 {
+		t.Errorf("26 次出队中 batch 共 %d 次，期望 2（全部 %v）", batch, got)
+	}
+}
+
+// TestFairQueuePosition Position 与实际出队顺序一致，出队部分请求后仍然一致
+func TestFairQueuePosition(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+	classes := []PriorityClass{PriorityInteractive, PriorityStandard, PriorityBatch}
+	tenants := []string{"a", "b", "c"}
+	q := newFairQueue(1000, testClasses)
+	var queued []*StreamRequest
+	push := func(n int) {
+		for i := 0; i < n; i++ {
+			req := &StreamRequest{ID: fmt.Sprint(len(queued), "-", i), Class: classes[rng.Intn(3)], Tenant: tenants[rng.Intn(3)]}
+			if err := q.Push(req); err != nil {
+				t.Fatal(err)
+			}
+			queued = append(queued, req)
+		}
+	}
+	check := func() {
+		t.Helper()
+		positions := make(map[*StreamRequest]int)
+		for _, req := range queued {
+			positions[req] = q.Position(req)
+		}
+		// 记录全部位置后依次出队，比较实际顺序
+		var popped []*StreamRequest
+		for q.Len() > 0 {
+			popped = append(popped, q.Pop())
+		}
+		for i, req := range popped {
+			if positions[req] != i+1 {
+				t.Fatalf("请求 %s（%s/%s）Position = %d，实际第 %d 个出队", req.ID, req.Class, req.Tenant, positions[req], i+1)
+			}
+		}
+		queued = queued[:0]
+	}
+
+	push(60)
+	check()
+	// 出队一部分、再推入新请求后，虚拟时间不再从 0 开始
+	push(40)
+	for i := 0; i < 15; i++ {
+		popped := q.Pop()
+		for j, req := range queued {
+			if req == popped {
+				queued = append(queued[:j], queued[j+1:]...)
+				break
+			}
+		}
+	}
+	push(30)
+	check()
+
+	if got := q.Position(&StreamRequest{Class: PriorityBatch, Tenant: "a"}); got != 0 {
+		t.Errorf("不在队列中的请求 Position = %d，期望 0", got)
+	}
+}
+
+func TestFairQueueLimits(t *testing.T) {
+	q := newFairQueue(3, map[PriorityClass]PriorityClassConfig{PriorityBatch: {Weight: 1, MaxQueue: 1}})
+	pushN(t, q, PriorityBatch, "a", 1)
+	if err := q.Push(&StreamRequest{Class: PriorityBatch, Tenant: "b"}); err != ErrQueueFull {
+		t.Errorf("batch 排队数达到上限时 Push = %v，期望 ErrQueueFull", err)
+	}
+	pushN(t, q, PriorityStandard, "a", 2)
+	if err := q.Push(&StreamRequest{Class: PriorityInteractive, Tenant: "a"}); err != ErrQueueFull {
+		t.Errorf("总排队数达到上限时 Push = %v，期望 ErrQueueFull", err)
+	}
+}
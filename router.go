@@ -0,0 +1,402 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"ai-answer-demo/tracing"
+
+	"github.com/cloudwego/eino/schema"
+)
+
+// 多后端路由：Router 实现 ModelGenerator，把每次生成分配到一个后端，
+// 后端在输出第一个 token 之前失败时透明地换一个后端重试；输出过程中失败时，
+// 把已输出的内容作为 assistant 消息附在对话末尾，由另一个后端续写，并下发 failover 事件
+
+// ErrNoBackend 没有可用的后端：全部不健康、熔断中，或本次生成已逐个尝试过
+var ErrNoBackend = errors.New("no generator backend available")
+
+// RouterStrategy 后端选择策略
+type RouterStrategy string
+
+const (
+	// 平滑加权轮询
+	RouteWeighted RouterStrategy = "weighted"
+	// 选择进行中请求数与权重之比最小的后端
+	RouteLeastLoaded RouterStrategy = "least_loaded"
+)
+
+// Failover 一次生成中途切换后端
+type Failover struct {
+	From   string
+	To     string
+	Tokens int // 切换前已输出的 token 数
+}
+
+// HealthProber 支持主动健康检查的生成器
+type HealthProber interface {
+	Probe(ctx context.Context) error
+}
+
+// breakerState 熔断器状态
+type breakerState int
+
+const (
+	breakerClosed   breakerState = iota
+	breakerOpen                  // 连续失败达到阈值，冷却期内不分配请求
+	breakerHalfOpen              // 冷却期已过，放行一个试探请求
+)
+
+// RouterBackend 路由的一个后端
+type RouterBackend struct {
+	Name      string
+	Weight    int
+	Generator ModelGenerator
+}
+
+// backend 后端及其健康、熔断与负载状态
+type backend struct {
+	RouterBackend
+	prober HealthProber // 为空时视为始终健康
+
+	inflight atomic.Int64
+
+	// 以下字段由 Router.mu 保护
+	healthy  bool
+	state    breakerState
+	failures int // 连续失败次数
+	openedAt time.Time
+	trial    bool // 半开状态下的试探请求进行中
+	current  int  // 平滑加权轮询的当前权重
+
+	succeeded, failed, cancelled *Counter
+}
+
+// Router 多后端路由
+type Router struct {
+	backends   []*backend
+	strategy   RouterStrategy
+	threshold  int
+	openFor    time.Duration
+	interval   time.Duration
+	timeout    time.Duration
+	bufferSize int
+
+	mu     sync.Mutex
+	cancel context.CancelFunc
+	done   chan struct{}
+}
+
+// NewRouter 创建路由并启动健康检查
+func NewRouter(cfg RouterConfig, backends []RouterBackend, bufferSize int) *Router {
+	ctx, cancel := context.WithCancel(context.Background())
+	r := &Router{
+		strategy:   cfg.Strategy,
+		threshold:  max(cfg.FailureThreshold, 1),
+		openFor:    time.Duration(cfg.OpenSeconds) * time.Second,
+		interval:   time.Duration(cfg.HealthIntervalMs) * time.Millisecond,
+		timeout:    time.Duration(cfg.HealthTimeoutMs) * time.Millisecond,
+		bufferSize: bufferSize,
+		cancel:     cancel,
+		done:       make(chan struct{}),
+	}
+	for _, rb := range backends {
+		b := &backend{RouterBackend: rb, healthy: true}
+		b.Weight = max(b.Weight, 1)
+		b.prober, _ = rb.Generator.(HealthProber)
+		help := "Generations served by each router backend, by outcome."
+		b.succeeded = metrics.Registry.NewCounter("stream_backend_requests_total", help, "backend", b.Name, "outcome", "success")
+		b.failed = metrics.Registry.NewCounter("stream_backend_requests_total", help, "backend", b.Name, "outcome", "failure")
+		b.cancelled = metrics.Registry.NewCounter("stream_backend_requests_total", help, "backend", b.Name, "outcome", "cancelled")
+		metrics.Registry.NewGaugeFunc("stream_backend_inflight", "Generations in progress on each router backend.", func() float64 {
+			return float64(b.inflight.Load())
+		}, "backend", b.Name)
+		metrics.Registry.NewGaugeFunc("stream_backend_up", "Whether the backend passes health checks (1) or not (0).", func() float64 {
+			r.mu.Lock()
+			defer r.mu.Unlock()
+			if b.healthy {
+				return 1
+			}
+			return 0
+		}, "backend", b.Name)
+		metrics.Registry.NewGaugeFunc("stream_backend_breaker_state", "Circuit breaker state of each backend: 0 closed, 1 open, 2 half-open.", func() float64 {
+			r.mu.Lock()
+			defer r.mu.Unlock()
+			return float64(b.state)
+		}, "backend", b.Name)
+		r.backends = append(r.backends, b)
+	}
+	go r.probeLoop(ctx)
+	return r
+}
+
+// Names 返回后端名称
+func (r *Router) Names() []string {
+	names := make([]string, len(r.backends))
+	for i, b := range r.backends {
+		names[i] = b.Name
+	}
+	return names
+}
+
+// Close 停止健康检查
+func (r *Router) Close() {
+	r.cancel()
+	<-r.done
+}
+
+// probeLoop 启动时立即检查一次，之后按间隔检查
+func (r *Router) probeLoop(ctx context.Context) {
+	defer close(r.done)
+	if r.interval <= 0 {
+		return
+	}
+	ticker := time.NewTicker(r.interval)
+	defer ticker.Stop()
+	for {
+		r.probeAll(ctx)
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (r *Router) probeAll(ctx context.Context) {
+	var wg sync.WaitGroup
+	for _, b := range r.backends {
+		if b.prober == nil {
+			continue
+		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			pctx, cancel := context.WithTimeout(ctx, r.timeout)
+			defer cancel()
+			err := b.prober.Probe(pctx)
+			if ctx.Err() != nil {
+				return
+			}
+			r.mu.Lock()
+			changed := b.healthy != (err == nil)
+			b.healthy = err == nil
+			r.mu.Unlock()
+			if changed && err != nil {
+				warnf("后端 %s 健康检查失败: %v", b.Name, err)
+			} else if changed {
+				infof("后端 %s 恢复健康", b.Name)
+			}
+		}()
+	}
+	wg.Wait()
+}
+
+// available 后端是否可以接收请求，调用方持有 r.mu
+func (r *Router) available(b *backend, now time.Time) bool {
+	if !b.healthy {
+		return false
+	}
+	switch b.state {
+	case breakerOpen:
+		return now.Sub(b.openedAt) >= r.openFor
+	case breakerHalfOpen:
+		return !b.trial
+	}
+	return true
+}
+
+// acquire 按策略从未尝试过的可用后端中选择一个，没有时返回 nil
+func (r *Router) acquire(tried map[*backend]bool) *backend {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	now := time.Now()
+	var candidates []*backend
+	for _, b := range r.backends {
+		if !tried[b] && r.available(b, now) {
+			candidates = append(candidates, b)
+		}
+	}
+	if len(candidates) == 0 {
+		return nil
+	}
+
+	var picked *backend
+	switch r.strategy {
+	case RouteLeastLoaded:
+		for _, b := range candidates {
+			if picked == nil || float64(b.inflight.Load())/float64(b.Weight) < float64(picked.inflight.Load())/float64(picked.Weight) {
+				picked = b
+			}
+		}
+	default:
+		total := 0
+		for _, b := range candidates {
+			b.current += b.Weight
+			total += b.Weight
+			if picked == nil || b.current > picked.current {
+				picked = b
+			}
+		}
+		picked.current -= total
+	}
+
+	if picked.state != breakerClosed {
+		picked.state, picked.trial = breakerHalfOpen, true
+	}
+	picked.inflight.Add(1)
+	return picked
+}
+
+// release 记录一次生成的结果。输出前被取消（客户端断开）不计入熔断；
+// 输出后被取消（客户端断开、停止序列或 max_tokens）说明后端工作正常，按成功计
+func (r *Router) release(b *backend, err error, cancelled bool) {
+	b.inflight.Add(-1)
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	b.trial = false
+	switch {
+	case cancelled:
+		b.cancelled.Inc()
+	case err == nil:
+		b.succeeded.Inc()
+		b.failures = 0
+		b.state = breakerClosed
+	default:
+		b.failed.Inc()
+		b.failures++
+		if b.state == breakerHalfOpen || b.failures >= r.threshold {
+			if b.state != breakerOpen {
+				warnf("后端 %s 熔断（连续失败 %d 次）: %v", b.Name, b.failures, err)
+			}
+			b.state, b.openedAt = breakerOpen, time.Now()
+		}
+	}
+}
+
+func (r *Router) Stream(ctx context.Context, messages []*schema.Message, opts *GenerateOptions) <-chan Chunk {
+	out := make(chan Chunk, r.bufferSize)
+	go func() {
+		defer close(out)
+		tried := make(map[*backend]bool)
+		var content strings.Builder
+		tokens := 0
+		var last *backend
+		var lastErr error
+		for {
+			b := r.acquire(tried)
+			if b == nil {
+				err := ErrNoBackend
+				if lastErr != nil {
+					err = fmt.Errorf("%w: %s 失败: %w", ErrNoBackend, last.Name, lastErr)
+				}
+				out <- Chunk{Err: err}
+				return
+			}
+			tried[b] = true
+
+			msgs, o := messages, opts
+			if last != nil {
+				if tokens > 0 {
+					metrics.FailoversMidStream.Inc()
+					select {
+					case out <- Chunk{Failover: &Failover{From: last.Name, To: b.Name, Tokens: tokens}}:
+					case <-ctx.Done():
+						r.release(b, nil, true)
+						return
+					}
+					// 续写：已输出的内容作为 assistant 消息，剩余 token 数相应减少
+					msgs = append(append([]*schema.Message(nil), messages...), schema.AssistantMessage(content.String(), nil))
+					cont := *opts
+					if cont.MaxTokens > 0 {
+						cont.MaxTokens = max(cont.MaxTokens-tokens, 1)
+					}
+					o = &cont
+				} else {
+					metrics.FailoversBeforeFirstToken.Inc()
+				}
+				warnf("后端 %s 失败，切换到 %s（已输出 %d 个 token）: %v", last.Name, b.Name, tokens, lastErr)
+			}
+
+			err := r.attempt(ctx, b, msgs, o, out, &content, &tokens)
+			if err == nil {
+				return
+			}
+			last, lastErr = b, err
+		}
+	}()
+	return out
+}
+
+// attempt 在一个后端上生成并转发输出，后端失败时返回其错误；成功或客户端取消时返回 nil
+func (r *Router) attempt(ctx context.Context, b *backend, messages []*schema.Message, opts *GenerateOptions,
+	out chan<- Chunk, content *strings.Builder, tokens *int) error {
+	actx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	actx, span := tracing.Start(actx, "router.attempt", tracing.String("router.backend", b.Name), tracing.Int("router.resume_tokens", *tokens))
+	defer span.End()
+
+	in := b.Generator.Stream(actx, messages, opts)
+	produced := false
+	// 提前返回时先取消后端生成，再排空其输出
+	defer func() {
+		cancel()
+		for range in {
+		}
+	}()
+	for chunk := range in {
+		if chunk.Err != nil {
+			if ctx.Err() != nil {
+				r.release(b, nil, !produced)
+				return nil
+			}
+			span.RecordError(chunk.Err)
+			r.release(b, chunk.Err, false)
+			return chunk.Err
+		}
+		if chunk.Content != "" {
+			content.WriteString(chunk.Content)
+			*tokens++
+		}
+		produced = true
+		select {
+		case out <- chunk:
+		case <-ctx.Done():
+			r.release(b, nil, false)
+			return nil
+		}
+	}
+	r.release(b, nil, ctx.Err() != nil && !produced)
+	return nil
+}
+
+// httpProbe 以 GET 请求检查后端，返回 2xx 视为健康
+type httpProbe struct {
+	url    string
+	header http.Header
+}
+
+func (p *httpProbe) Probe(ctx context.Context) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, p.url, nil)
+	if err != nil {
+		return err
+	}
+	for k, v := range p.header {
+		req.Header[k] = v
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("%s 返回 %s", p.url, resp.Status)
+	}
+	return nil
+}
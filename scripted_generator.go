@@ -0,0 +1,169 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"regexp"
+	"runtime/debug"
+	"time"
+
+	"github.com/cloudwego/eino/schema"
+)
+
+// FixtureHeader 开发模式下按请求选择回放脚本的请求头，值为 fixtures 目录下不带 .json 的文件名
+const FixtureHeader = "X-Fixture"
+
+// Fixture 回放脚本：按顺序执行各步骤，每个步骤输出一个 token、工具调用，或注入停顿、错误与 panic
+type Fixture struct {
+	// 步骤未设置 delay_ms 时使用的默认间隔
+	DefaultDelayMs int           `json:"default_delay_ms"`
+	Steps          []FixtureStep `json:"steps"`
+	// 流结束时返回的用量，为空时由服务端按 token 数统计
+	Usage *schema.TokenUsage `json:"usage,omitempty"`
+}
+
+// FixtureStep 脚本中的一步，各字段互斥
+type FixtureStep struct {
+	Token    string           `json:"token,omitempty"`
+	ToolCall *FixtureToolCall `json:"tool_call,omitempty"`
+	// 本步骤输出前的等待时间，为空时使用 default_delay_ms
+	DelayMs *int `json:"delay_ms,omitempty"`
+	// 停顿指定时间且不输出任何内容，用于测试超时与滞后处理
+	StallMs int `json:"stall_ms,omitempty"`
+	// 以该错误结束生成
+	Error string `json:"error,omitempty"`
+	// 在生成 goroutine 中 panic，由生成器恢复后以 internal_error 结束
+	Panic string `json:"panic,omitempty"`
+}
+
+// FixtureToolCall 脚本中的工具调用
+type FixtureToolCall struct {
+	ID        string `json:"id"`
+	Name      string `json:"name"`
+	Arguments string `json:"arguments"`
+}
+
+// PanicError 生成器内部 panic 被恢复后转换成的错误
+type PanicError struct {
+	Value any
+}
+
+func (e *PanicError) Error() string {
+	return fmt.Sprintf("generator panic: %v", e.Value)
+}
+
+// fixtureNamePattern 限制脚本名字符，防止拼接文件路径时越出脚本目录
+var fixtureNamePattern = regexp.MustCompile(`^[A-Za-z0-9_-]+$`)
+
+// ScriptedGenerator 开发模式的生成器：请求通过 X-Fixture 指定脚本时回放脚本，
+// 否则交给 base 生成。脚本每次请求时重新读取，修改后无需重启
+type ScriptedGenerator struct {
+	base       ModelGenerator
+	dir        string
+	bufferSize int
+}
+
+func NewScriptedGenerator(base ModelGenerator, dir string, bufferSize int) *ScriptedGenerator {
+	return &ScriptedGenerator{base: base, dir: dir, bufferSize: bufferSize}
+}
+
+// Load 读取并校验脚本
+func (g *ScriptedGenerator) Load(name string) (*Fixture, error) {
+	if !fixtureNamePattern.MatchString(name) {
+		return nil, fmt.Errorf("脚本名只能包含字母、数字、'-' 与 '_'")
+	}
+	data, err := os.ReadFile(filepath.Join(g.dir, name+".json"))
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, fmt.Errorf("脚本 %s 不存在", name)
+	}
+	if err != nil {
+		return nil, err
+	}
+	var f Fixture
+	if err := json.Unmarshal(data, &f); err != nil {
+		return nil, fmt.Errorf("脚本 %s 解析失败: %w", name, err)
+	}
+	return &f, nil
+}
+
+func (g *ScriptedGenerator) Stream(ctx context.Context, messages []*schema.Message, opts *GenerateOptions) <-chan Chunk {
+	if opts.Fixture == "" {
+		return g.base.Stream(ctx, messages, opts)
+	}
+
+	out := make(chan Chunk, g.bufferSize)
+	go func() {
+		defer close(out)
+		defer func() {
+			if v := recover(); v != nil {
+				errorf("生成器 panic: %v\n%s", v, debug.Stack())
+				metrics.PanicsRecovered.Inc()
+				out <- Chunk{Err: &PanicError{Value: v}}
+			}
+		}()
+
+		// 校验已在 ValidateOptions 中完成，这里只处理校验之后脚本被删除或改坏的情况
+		f, err := g.Load(opts.Fixture)
+		if err != nil {
+			out <- Chunk{Err: err}
+			return
+		}
+		g.play(ctx, f, out)
+	}()
+	return out
+}
+
+// play 按脚本输出分片，ctx 取消时立即返回
+func (g *ScriptedGenerator) play(ctx context.Context, f *Fixture, out chan<- Chunk) {
+	sleep := func(ms int) bool {
+		if ms <= 0 {
+			return ctx.Err() == nil
+		}
+		t := time.NewTimer(time.Duration(ms) * time.Millisecond)
+		defer t.Stop()
+		select {
+		case <-ctx.Done():
+			return false
+		case <-t.C:
+			return true
+		}
+	}
+
+	calls := 0
+	for _, step := range f.Steps {
+		delay := f.DefaultDelayMs
+		if step.DelayMs != nil {
+			delay = *step.DelayMs
+		}
+		if !sleep(delay) || !sleep(step.StallMs) {
+			return
+		}
+
+		switch {
+		case step.Panic != "":
+			panic(step.Panic)
+		case step.Error != "":
+			out <- Chunk{Err: errors.New(step.Error)}
+			return
+		case step.ToolCall != nil:
+			index := calls
+			calls++
+			out <- Chunk{ToolCalls: []schema.ToolCall{{
+				Index:    &index,
+				ID:       step.ToolCall.ID,
+				Type:     "function",
+				Function: schema.FunctionCall{Name: step.ToolCall.Name, Arguments: step.ToolCall.Arguments},
+			}}}
+		case step.Token != "":
+			out <- Chunk{Content: step.Token}
+		}
+	}
+	if f.Usage != nil {
+		out <- Chunk{Usage: f.Usage}
+	}
+}
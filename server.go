@@ -0,0 +1,150 @@
+package main
+
+import (
+	"context"
+	"log"
+	"net/http"
+	"os"
+	"os/signal"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+)
+
+// ServerLifecycle 统一的服务生命周期：收到信号后停止接收新的流并通知已连接的客户端，
+// 在排空期限内等待进行中的流结束，超时后取消剩余生成
+type ServerLifecycle struct {
+	pipeline *StreamPipeline
+
+	drainCh  chan struct{} // 开始排空时关闭
+	cutoffCh chan struct{} // 排空超时、开始中断剩余流时关闭
+	idleCh   chan struct{} // 排空期间最后一个流结束时关闭
+	deadline atomic.Int64  // 排空截止时间（unix 毫秒）
+
+	// 以下字段由 mu 保护。开始排空与登记新的流在同一把锁下判断，
+	// 排空开始后不会再有流加入，进行中的流数只减不增
+	mu       sync.Mutex
+	draining bool
+	active   int
+	drained  int64
+	cutOff   int64
+}
+
+func NewServerLifecycle(pipeline *StreamPipeline) *ServerLifecycle {
+	return &ServerLifecycle{
+		pipeline: pipeline,
+		drainCh:  make(chan struct{}),
+		cutoffCh: make(chan struct{}),
+		idleCh:   make(chan struct{}),
+	}
+}
+
+// Begin 登记一个正在服务的流，返回的函数在流结束时调用。
+// 已开始排空时返回 false，调用方应拒绝请求：去重命中进行中的生成与断线续传
+// 不经过 StreamPipeline.Submit，只能在这里拦下
+func (l *ServerLifecycle) Begin() (func(), bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.draining {
+		return nil, false
+	}
+	l.active++
+	return l.end, true
+}
+
+func (l *ServerLifecycle) end() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.active--
+	if !l.draining {
+		return
+	}
+	select {
+	case <-l.cutoffCh:
+		l.cutOff++
+	default:
+		l.drained++
+	}
+	if l.active == 0 {
+		close(l.idleCh)
+	}
+}
+
+// Draining 返回开始排空时关闭的 channel
+func (l *ServerLifecycle) Draining() <-chan struct{} {
+	return l.drainCh
+}
+
+// DrainDeadline 返回排空截止时间
+func (l *ServerLifecycle) DrainDeadline() time.Time {
+	return time.UnixMilli(l.deadline.Load())
+}
+
+// Drain 停止接收新的流并等待进行中的流结束，超时后取消剩余生成，
+// 返回排空期间正常结束与被中断的流数量
+func (l *ServerLifecycle) Drain(timeout time.Duration) (drained, cutOff int64) {
+	l.deadline.Store(time.Now().Add(timeout).UnixMilli())
+	l.pipeline.StopAccepting()
+	l.mu.Lock()
+	l.draining = true
+	if l.active == 0 {
+		close(l.idleCh)
+	}
+	l.mu.Unlock()
+	close(l.drainCh)
+
+	if !l.waitIdle(timeout) {
+		close(l.cutoffCh)
+		l.pipeline.CancelAll()
+		// 取消后各个流会写出 done 事件并退出，这里只做有限等待
+		l.waitIdle(5 * time.Second)
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.drained, l.cutOff
+}
+
+// waitIdle 等待进行中的流全部结束，超时返回 false
+func (l *ServerLifecycle) waitIdle(timeout time.Duration) bool {
+	select {
+	case <-l.idleCh:
+		return true
+	case <-time.After(timeout):
+		return false
+	}
+}
+
+// StartServer 启动服务并阻塞到优雅关闭完成
+func StartServer(server *http.Server, lifecycle *ServerLifecycle, drainTimeout time.Duration) {
+	stopped := make(chan struct{})
+
+	// 优雅关闭
+	go func() {
+		defer close(stopped)
+		sig := make(chan os.Signal, 1)
+		signal.Notify(sig, syscall.SIGINT, syscall.SIGTERM)
+		<-sig
+		infof("收到退出信号，开始排空进行中的流（期限 %s）", drainTimeout)
+
+		// Shutdown 立即关闭监听端口，并等待活跃连接结束
+		shutdownErr := make(chan error, 1)
+		go func() {
+			ctx, cancel := context.WithTimeout(context.Background(), drainTimeout+10*time.Second)
+			defer cancel()
+			shutdownErr <- server.Shutdown(ctx)
+		}()
+
+		drained, cutOff := lifecycle.Drain(drainTimeout)
+		infof("排空结束：%d 个流正常完成，%d 个流被中断", drained, cutOff)
+
+		if err := <-shutdownErr; err != nil {
+			errorf("关闭错误: %v", err)
+		}
+	}()
+
+	if err := server.ListenAndServe(); err != http.ErrServerClosed {
+		log.Fatal(err)
+	}
+	<-stopped
+}
@@ -0,0 +1,222 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/cloudwego/eino/schema"
+)
+
+// SessionHandler 服务端多轮对话会话接口，客户端每轮只需发送新消息
+type SessionHandler struct {
+	store    SessionStore
+	registry *GenerationRegistry
+
+	mu   sync.Mutex
+	busy map[string]bool // 正在生成回复的会话，同一会话同时只允许一轮生成
+}
+
+func NewSessionHandler(store SessionStore, registry *GenerationRegistry) *SessionHandler {
+	return &SessionHandler{store: store, registry: registry, busy: make(map[string]bool)}
+}
+
+// Register 在 mux 上注册会话路由，auth 非空时所有路由都要求认证
+func (h *SessionHandler) Register(mux *http.ServeMux, auth *Authenticator) {
+	mux.Handle("POST /sessions", auth.Require(http.HandlerFunc(h.Create)))
+	mux.Handle("GET /sessions/{id}", auth.Require(http.HandlerFunc(h.Get)))
+	mux.Handle("DELETE /sessions/{id}", auth.Require(http.HandlerFunc(h.Delete)))
+	mux.Handle("POST /sessions/{id}/messages", SafeStream(auth.Require(http.HandlerFunc(h.PostMessage))))
+}
+
+// CreateSessionRequest POST /sessions 请求体，可为空
+type CreateSessionRequest struct {
+	System string `json:"system"`
+}
+
+// PostMessageRequest POST /sessions/{id}/messages 请求体，可携带本轮的生成参数
+type PostMessageRequest struct {
+	Content string `json:"content"`
+	GenerateOptions
+}
+
+// Create 处理 POST /sessions
+func (h *SessionHandler) Create(w http.ResponseWriter, r *http.Request) {
+	var req CreateSessionRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil && !errors.Is(err, io.EOF) {
+		writeJSONError(w, http.StatusBadRequest, "请求体不是合法的 JSON: "+err.Error())
+		return
+	}
+
+	now := time.Now()
+	s := &Session{
+		ID:        newID("sess-"),
+		Tenant:    sessionTenant(r),
+		System:    req.System,
+		Messages:  []SessionMessage{},
+		CreatedAt: now,
+		UpdatedAt: now,
+	}
+	if err := h.store.Save(s); err != nil {
+		writeJSONError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	writeJSON(w, http.StatusCreated, s)
+}
+
+// load 读取会话，启用认证时其他密钥创建的会话视为不存在
+func (h *SessionHandler) load(r *http.Request, id string) (*Session, error) {
+	s, err := h.store.Get(id)
+	if err != nil {
+		return nil, err
+	}
+	if key := APIKeyFromContext(r.Context()); key != nil && s.Tenant != key.ID {
+		return nil, ErrSessionNotFound
+	}
+	return s, nil
+}
+
+// sessionTenant 会话所属的租户，未启用认证时为空
+func sessionTenant(r *http.Request) string {
+	if key := APIKeyFromContext(r.Context()); key != nil {
+		return key.ID
+	}
+	return ""
+}
+
+// Get 处理 GET /sessions/{id}
+func (h *SessionHandler) Get(w http.ResponseWriter, r *http.Request) {
+	s, err := h.load(r, r.PathValue("id"))
+	if err != nil {
+		writeStoreError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, s)
+}
+
+// Delete 处理 DELETE /sessions/{id}
+func (h *SessionHandler) Delete(w http.ResponseWriter, r *http.Request) {
+	id := r.PathValue("id")
+	if _, err := h.load(r, id); err != nil {
+		writeStoreError(w, err)
+		return
+	}
+	if err := h.store.Delete(id); err != nil {
+		writeStoreError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// PostMessage 处理 POST /sessions/{id}/messages：追加用户消息，以完整历史为上下文流式返回回复
+func (h *SessionHandler) PostMessage(w http.ResponseWriter, r *http.Request) {
+	if _, ok := w.(http.Flusher); !ok {
+		http.Error(w, "Streaming unsupported", http.StatusInternalServerError)
+		return
+	}
+
+	var req PostMessageRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeJSONError(w, http.StatusBadRequest, "请求体不是合法的 JSON: "+err.Error())
+		return
+	}
+	if strings.TrimSpace(req.Content) == "" {
+		writeJSONError(w, http.StatusBadRequest, "content 不能为空")
+		return
+	}
+	req.Fixture = r.Header.Get(FixtureHeader)
+	if err := h.registry.pipeline.ValidateOptions(&req.GenerateOptions); err != nil {
+		writeOptionError(w, err)
+		return
+	}
+	adm, err := admissionFromRequest(r)
+	if err != nil {
+		writeOptionError(w, err)
+		return
+	}
+
+	id := r.PathValue("id")
+	if !h.acquire(id) {
+		writeJSONError(w, http.StatusConflict, "会话正在生成回复，请等待当前回复结束")
+		return
+	}
+
+	s, err := h.load(r, id)
+	if err != nil {
+		h.release(id)
+		writeStoreError(w, err)
+		return
+	}
+	// 用户消息与回复在生成完成后一并写入，回复未保存时历史中不留下没有回复的用户消息
+	question := SessionMessage{Role: string(schema.User), Content: req.Content, CreatedAt: time.Now()}
+	s.Messages = append(s.Messages, question)
+
+	applyQuota(r.Context(), &req.GenerateOptions)
+	gen, err := h.registry.Start(r.Context(), s.History(), &req.GenerateOptions, adm)
+	if err != nil {
+		h.release(id)
+		rejectSubmit(w, err, h.registry.pipeline)
+		return
+	}
+
+	// 回复在生成结束后写回会话，客户端中途断开也不影响
+	go h.saveReply(id, question, gen)
+
+	w.Header().Set("X-Session-ID", id)
+	serveGeneration(w, r, h.registry, gen, 0)
+}
+
+// saveReply 等待生成结束，将用户消息与助手回复一并追加到会话并释放会话。
+// 被取消或出错的回复不完整，连同用户消息都不写入历史，避免作为上下文再次交给模型
+func (h *SessionHandler) saveReply(id string, question SessionMessage, gen *Generation) {
+	defer h.release(id)
+	<-gen.Done()
+
+	content, reason := gen.Result()
+	if content == "" || (reason != DoneStop && reason != DoneLength) {
+		return
+	}
+	s, err := h.store.Get(id)
+	if err != nil {
+		// 生成期间会话已被删除
+		return
+	}
+	s.Messages = append(s.Messages, question, SessionMessage{Role: string(schema.Assistant), Content: content, CreatedAt: time.Now()})
+	s.UpdatedAt = time.Now()
+	if err := h.store.Save(s); err != nil {
+		errorf("保存会话失败: %v", err)
+	}
+}
+
+func (h *SessionHandler) acquire(id string) bool {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.busy[id] {
+		return false
+	}
+	h.busy[id] = true
+	return true
+}
+
+func (h *SessionHandler) release(id string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	delete(h.busy, id)
+}
+
+func writeStoreError(w http.ResponseWriter, err error) {
+	if errors.Is(err, ErrSessionNotFound) {
+		writeJSONError(w, http.StatusNotFound, err.Error())
+		return
+	}
+	writeJSONError(w, http.StatusInternalServerError, err.Error())
+}
+
+// writeJSONError 以 {"error": "..."} 格式返回错误
+func writeJSONError(w http.ResponseWriter, status int, message string) {
+	writeJSON(w, status, map[string]string{"error": message})
+}
@@ -0,0 +1,194 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sync"
+	"time"
+
+	"github.com/cloudwego/eino/schema"
+)
+
+// ErrSessionNotFound 会话不存在
+var ErrSessionNotFound = errors.New("session not found")
+
+// SessionMessage 会话中的一条消息
+type SessionMessage struct {
+	Role      string    `json:"role"`
+	Content   string    `json:"content"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// Session 多轮对话会话
+type Session struct {
+	ID string `json:"id"`
+	// 创建会话的 API Key，启用认证时只有该密钥可以访问
+	Tenant    string           `json:"tenant,omitempty"`
+	System    string           `json:"system,omitempty"`
+	Messages  []SessionMessage `json:"messages"`
+	CreatedAt time.Time        `json:"created_at"`
+	UpdatedAt time.Time        `json:"updated_at"`
+}
+
+// History 将系统提示与历史消息转换为生成器输入
+func (s *Session) History() []*schema.Message {
+	messages := make([]*schema.Message, 0, len(s.Messages)+1)
+	if s.System != "" {
+		messages = append(messages, schema.SystemMessage(s.System))
+	}
+	for _, m := range s.Messages {
+		messages = append(messages, &schema.Message{Role: schema.RoleType(m.Role), Content: m.Content})
+	}
+	return messages
+}
+
+func (s *Session) clone() *Session {
+	c := *s
+	c.Messages = append([]SessionMessage(nil), s.Messages...)
+	return &c
+}
+
+// SessionStore 会话存储接口
+type SessionStore interface {
+	Get(id string) (*Session, error)
+	Save(s *Session) error
+	Delete(id string) error
+}
+
+// SessionStoreType 定义支持的会话存储类型
+type SessionStoreType string
+
+const (
+	SessionStoreMemory SessionStoreType = "memory"
+	SessionStoreFile   SessionStoreType = "file"
+)
+
+// NewSessionStore 根据配置创建对应的会话存储
+func NewSessionStore(cfg SessionConfig) (SessionStore, error) {
+	switch cfg.Store {
+	case SessionStoreMemory, "":
+		return NewMemorySessionStore(), nil
+	case SessionStoreFile:
+		return NewFileSessionStore(cfg.Dir)
+	default:
+		return nil, fmt.Errorf("unsupported session store: %s", cfg.Store)
+	}
+}
+
+// MemorySessionStore 进程内会话存储，重启后丢失
+type MemorySessionStore struct {
+	mu       sync.RWMutex
+	sessions map[string]*Session
+}
+
+func NewMemorySessionStore() *MemorySessionStore {
+	return &MemorySessionStore{sessions: make(map[string]*Session)}
+}
+
+func (m *MemorySessionStore) Get(id string) (*Session, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	s, ok := m.sessions[id]
+	if !ok {
+		return nil, ErrSessionNotFound
+	}
+	return s.clone(), nil
+}
+
+func (m *MemorySessionStore) Save(s *Session) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.sessions[s.ID] = s.clone()
+	return nil
+}
+
+func (m *MemorySessionStore) Delete(id string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if _, ok := m.sessions[id]; !ok {
+		return ErrSessionNotFound
+	}
+	delete(m.sessions, id)
+	return nil
+}
+
+// sessionIDPattern 限制会话 ID 字符，防止拼接文件路径时越出存储目录
+var sessionIDPattern = regexp.MustCompile(`^[A-Za-z0-9_-]+$`)
+
+// FileSessionStore 文件会话存储，每个会话一个 JSON 文件
+type FileSessionStore struct {
+	dir string
+	mu  sync.Mutex
+}
+
+func NewFileSessionStore(dir string) (*FileSessionStore, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	return &FileSessionStore{dir: dir}, nil
+}
+
+func (f *FileSessionStore) path(id string) (string, error) {
+	if !sessionIDPattern.MatchString(id) {
+		return "", ErrSessionNotFound
+	}
+	return filepath.Join(f.dir, id+".json"), nil
+}
+
+func (f *FileSessionStore) Get(id string) (*Session, error) {
+	path, err := f.path(id)
+	if err != nil {
+		return nil, err
+	}
+	data, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, ErrSessionNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	var s Session
+	if err := json.Unmarshal(data, &s); err != nil {
+		return nil, err
+	}
+	return &s, nil
+}
+
+// Save 先写临时文件再重命名，避免进程中断时留下半个文件
+func (f *FileSessionStore) Save(s *Session) error {
+	path, err := f.path(s.ID)
+	if err != nil {
+		return err
+	}
+	data, err := json.MarshalIndent(s, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+func (f *FileSessionStore) Delete(id string) error {
+	path, err := f.path(id)
+	if err != nil {
+		return err
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	err = os.Remove(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return ErrSessionNotFound
+	}
+	return err
+}
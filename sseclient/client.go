@@ -0,0 +1,216 @@
+package sseclient
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand/v2"
+	"mime"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// ErrNoContent 服务端返回 204，按规范表示不应再重连
+var ErrNoContent = errors.New("sseclient: server responded 204 No Content")
+
+// StatusError 服务端以不可重试的状态码拒绝连接
+type StatusError struct {
+	StatusCode int
+	Body       string
+}
+
+func (e *StatusError) Error() string {
+	return fmt.Sprintf("sseclient: HTTP %d: %s", e.StatusCode, e.Body)
+}
+
+// Client 订阅一个 SSE 地址：连接断开后携带 Last-Event-ID 重连，重连间隔按指数退避增长。
+// 零值之外只有 URL 是必填的
+type Client struct {
+	URL string
+	// 为空时使用 http.DefaultClient。不要设置 Timeout，它会限制整条流的时长
+	HTTPClient *http.Client
+	// 每次请求附带的请求头，如 Authorization
+	Header http.Header
+	// 首次连接携带的 Last-Event-ID，用于从已知位置续传
+	LastEventID string
+
+	// 首次重连的等待时间，服务端 retry 字段会覆盖该值，默认 1s
+	InitialBackoff time.Duration
+	// 重连等待时间上限，默认 30s
+	MaxBackoff time.Duration
+	// 连续重连失败次数上限，0 表示不限
+	MaxRetries int
+
+	// 返回 true 时在交付该事件后结束订阅。服务端在流结束后关闭连接（如 /stream 的 done 事件）时
+	// 需要设置，否则会被当作断线而重连
+	StopAfter func(Event) bool
+}
+
+// IsDone 用于 StopAfter：收到 done 事件即结束
+func IsDone(ev Event) bool {
+	return ev.Type == "done"
+}
+
+// Stream 一次订阅
+type Stream struct {
+	events chan Event
+	err    error
+}
+
+// Events 事件 channel，订阅结束后关闭
+func (s *Stream) Events() <-chan Event {
+	return s.events
+}
+
+// Err 订阅结束的原因，须在 Events 关闭后调用。StopAfter 正常结束时为 nil，
+// ctx 取消时为 ctx.Err()
+func (s *Stream) Err() error {
+	return s.err
+}
+
+// Subscribe 开始订阅，ctx 取消时断开连接并关闭 Events
+func (c *Client) Subscribe(ctx context.Context) *Stream {
+	s := &Stream{events: make(chan Event)}
+	go func() {
+		defer close(s.events)
+		s.err = c.run(ctx, s.events)
+	}()
+	return s
+}
+
+// run 连接、读取直至结束，断线时按退避等待后重连
+func (c *Client) run(ctx context.Context, out chan<- Event) error {
+	lastID := c.LastEventID
+	var retry time.Duration
+	failures := 0
+	for {
+		received, stop, err := c.connect(ctx, out, &lastID, &retry)
+		if stop || ctx.Err() != nil {
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			return err
+		}
+		var status *StatusError
+		if errors.Is(err, ErrNoContent) || errors.As(err, &status) {
+			return err
+		}
+
+		// 收到过事件说明连接是健康的，退避从头开始
+		if received {
+			failures = 0
+		}
+		failures++
+		if c.MaxRetries > 0 && failures > c.MaxRetries {
+			return fmt.Errorf("sseclient: giving up after %d retries: %w", c.MaxRetries, err)
+		}
+
+		t := time.NewTimer(c.backoff(failures, retry))
+		select {
+		case <-ctx.Done():
+			t.Stop()
+			return ctx.Err()
+		case <-t.C:
+		}
+	}
+}
+
+// connect 建立一次连接并交付事件，lastID 与 retry 跨连接保持并在读取中更新。
+// received 表示本次连接至少交付了一个事件，stop 表示订阅应当结束
+func (c *Client) connect(ctx context.Context, out chan<- Event, lastID *string, retry *time.Duration) (received, stop bool, err error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, c.URL, nil)
+	if err != nil {
+		return false, true, err
+	}
+	for k, vs := range c.Header {
+		req.Header[k] = vs
+	}
+	req.Header.Set("Accept", "text/event-stream")
+	req.Header.Set("Cache-Control", "no-cache")
+	if *lastID != "" {
+		req.Header.Set("Last-Event-ID", *lastID)
+	}
+
+	httpClient := c.HTTPClient
+	if httpClient == nil {
+		httpClient = http.DefaultClient
+	}
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return false, false, err
+	}
+	defer resp.Body.Close()
+
+	if err := checkResponse(resp); err != nil {
+		return false, false, err
+	}
+
+	r := NewReader(resp.Body)
+	// 规范中 last event ID 跨连接保持，新连接上没有 id 字段的事件沿用旧值
+	r.idBuf, r.lastID = *lastID, *lastID
+	for {
+		ev, err := r.Next()
+		*lastID = r.LastEventID()
+		if d := r.Retry(); d > 0 {
+			*retry = d
+		}
+		if err != nil {
+			if err == io.EOF {
+				err = io.ErrUnexpectedEOF
+			}
+			return received, false, err
+		}
+		select {
+		case out <- ev:
+			received = true
+		case <-ctx.Done():
+			return received, true, ctx.Err()
+		}
+		if c.StopAfter != nil && c.StopAfter(ev) {
+			return true, true, nil
+		}
+	}
+}
+
+// checkResponse 校验响应。408、429 与 5xx 视为暂时性错误，可以重连；
+// 其余非 200 响应与错误的 Content-Type 返回 StatusError，不再重连
+func checkResponse(resp *http.Response) error {
+	switch {
+	case resp.StatusCode == http.StatusNoContent:
+		return ErrNoContent
+	case resp.StatusCode == http.StatusRequestTimeout, resp.StatusCode == http.StatusTooManyRequests, resp.StatusCode >= 500:
+		return fmt.Errorf("sseclient: HTTP %d", resp.StatusCode)
+	case resp.StatusCode != http.StatusOK:
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return &StatusError{StatusCode: resp.StatusCode, Body: strings.TrimSpace(string(body))}
+	}
+	if mt, _, _ := mime.ParseMediaType(resp.Header.Get("Content-Type")); mt != "text/event-stream" {
+		return &StatusError{StatusCode: resp.StatusCode, Body: "unexpected Content-Type " + resp.Header.Get("Content-Type")}
+	}
+	return nil
+}
+
+// backoff 第 n 次连续失败后的等待时间：以 retry（未设置时为 InitialBackoff）为基数指数增长，
+// 不超过 MaxBackoff，并叠加 ±20% 抖动，避免大量客户端同时重连
+func (c *Client) backoff(n int, retry time.Duration) time.Duration {
+	base := c.InitialBackoff
+	if base <= 0 {
+		base = time.Second
+	}
+	if retry > 0 {
+		base = retry
+	}
+	limit := c.MaxBackoff
+	if limit <= 0 {
+		limit = 30 * time.Second
+	}
+	d := base
+	for i := 1; i < n && d < limit; i++ {
+		d *= 2
+	}
+	d = min(d, limit)
+	jitter := time.Duration((rand.Float64()*0.4 - 0.2) * float64(d))
+	return d + jitter
+}
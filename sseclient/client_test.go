@@ -0,0 +1,275 @@
+package sseclient
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// collect 读取订阅的全部事件，超时视为测试失败
+func collect(t *testing.T, s *Stream) []Event {
+	t.Helper()
+	var events []Event
+	timeout := time.After(5 * time.Second)
+	for {
+		select {
+		case ev, ok := <-s.Events():
+			if !ok {
+				return events
+			}
+			events = append(events, ev)
+		case <-timeout:
+			t.Fatalf("订阅未在 5s 内结束，已收到 %d 个事件", len(events))
+		}
+	}
+}
+
+func TestReaderFields(t *testing.T) {
+	stream := "\uFEFF: keep-alive\n" +
+		"event: token\n" +
+		"id: 1\n" +
+		"data: first line\n" +
+		"data: second line\n" +
+		"\n" +
+		"data:no space\r\n" +
+		"\r\n" +
+		// 没有 data 的事件不分派，但 id 仍然生效
+		"id: 2\n" +
+		"event: ignored\n" +
+		"\n" +
+		"retry: 1500\n" +
+		"retry: 12x\n" +
+		"id: bad\x00id\n" +
+		"data\n" +
+		"\n" +
+		"event: done\r" +
+		"data: {}\r" +
+		"\r" +
+		"data: truncated"
+
+	want := []Event{
+		{ID: "1", Type: "token", Data: "first line\nsecond line"},
+		{ID: "1", Type: "message", Data: "no space"},
+		{ID: "2", Type: "message", Data: ""},
+		{ID: "2", Type: "done", Data: "{}"},
+	}
+	r := NewReader(strings.NewReader(stream))
+	for i, w := range want {
+		ev, err := r.Next()
+		if err != nil {
+			t.Fatalf("第 %d 个事件: %v", i, err)
+		}
+		if ev != w {
+			t.Errorf("第 %d 个事件 = %+v，期望 %+v", i, ev, w)
+		}
+	}
+	if _, err := r.Next(); err != io.EOF {
+		t.Errorf("未以空行结束的残缺事件应被丢弃并返回 io.EOF，得到 %v", err)
+	}
+	if r.Retry() != 1500*time.Millisecond {
+		t.Errorf("Retry() = %s，期望 1.5s（非数字的 retry 应被忽略）", r.Retry())
+	}
+	if r.LastEventID() != "2" {
+		t.Errorf("LastEventID() = %q，期望 2（含 NUL 的 id 应被忽略）", r.LastEventID())
+	}
+}
+
+func TestClientEvents(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer k" {
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		w.Header().Set("Content-Type", "text/event-stream; charset=utf-8")
+		io.WriteString(w, ": hello\n\nid: a\nevent: token\ndata: x\ndata: y\n\nid: b\nevent: done\ndata: {}\n\n")
+	}))
+	defer srv.Close()
+
+	c := &Client{URL: srv.URL, Header: http.Header{"Authorization": {"Bearer k"}}, StopAfter: IsDone}
+	s := c.Subscribe(context.Background())
+	events := collect(t, s)
+	want := []Event{{ID: "a", Type: "token", Data: "x\ny"}, {ID: "b", Type: "done", Data: "{}"}}
+	if fmt.Sprint(events) != fmt.Sprint(want) {
+		t.Errorf("events = %+v，期望 %+v", events, want)
+	}
+	if s.Err() != nil {
+		t.Errorf("StopAfter 正常结束时 Err() 应为 nil，得到 %v", s.Err())
+	}
+}
+
+func TestClientStatusErrors(t *testing.T) {
+	for _, tc := range []struct {
+		status int
+		check  func(error) bool
+	}{
+		{http.StatusNoContent, func(err error) bool { return errors.Is(err, ErrNoContent) }},
+		{http.StatusUnauthorized, func(err error) bool {
+			var se *StatusError
+			return errors.As(err, &se) && se.StatusCode == http.StatusUnauthorized
+		}},
+	} {
+		var requests atomic.Int32
+		srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			requests.Add(1)
+			w.WriteHeader(tc.status)
+		}))
+		s := (&Client{URL: srv.URL, InitialBackoff: time.Millisecond}).Subscribe(context.Background())
+		collect(t, s)
+		srv.Close()
+		if !tc.check(s.Err()) {
+			t.Errorf("HTTP %d: Err() = %v", tc.status, s.Err())
+		}
+		if n := requests.Load(); n != 1 {
+			t.Errorf("HTTP %d 不应重连，请求了 %d 次", tc.status, n)
+		}
+	}
+}
+
+// TestClientReconnect 连接在事件中途断开后重连，携带最后一个完整事件的 ID，残缺的事件被丢弃
+func TestClientReconnect(t *testing.T) {
+	var mu sync.Mutex
+	var lastIDs []string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		lastIDs = append(lastIDs, r.Header.Get("Last-Event-ID"))
+		n := len(lastIDs)
+		mu.Unlock()
+
+		w.Header().Set("Content-Type", "text/event-stream")
+		if n == 1 {
+			// retry 覆盖 InitialBackoff，否则本测试要等待一小时
+			io.WriteString(w, "retry: 10\n\nid: 1\ndata: one\n\nid: 2\ndata: two\n\nid: 3\ndata: thr")
+			return
+		}
+		io.WriteString(w, "data: three\n\nid: 4\nevent: done\ndata: {}\n\n")
+	}))
+	defer srv.Close()
+
+	c := &Client{URL: srv.URL, LastEventID: "0", InitialBackoff: time.Hour, StopAfter: IsDone}
+	s := c.Subscribe(context.Background())
+	events := collect(t, s)
+	if s.Err() != nil {
+		t.Fatalf("Err() = %v", s.Err())
+	}
+	want := []Event{
+		{ID: "1", Type: "message", Data: "one"},
+		{ID: "2", Type: "message", Data: "two"},
+		// 新连接上没有 id 字段的事件沿用上一个连接的 last event ID
+		{ID: "2", Type: "message", Data: "three"},
+		{ID: "4", Type: "done", Data: "{}"},
+	}
+	if fmt.Sprint(events) != fmt.Sprint(want) {
+		t.Errorf("events = %+v，期望 %+v", events, want)
+	}
+	if fmt.Sprint(lastIDs) != fmt.Sprint([]string{"0", "2"}) {
+		t.Errorf("Last-Event-ID = %q，期望首次为 LastEventID、重连时为最后一个完整事件的 ID", lastIDs)
+	}
+}
+
+func TestBackoff(t *testing.T) {
+	c := &Client{InitialBackoff: 100 * time.Millisecond, MaxBackoff: time.Second}
+	for n, want := range []time.Duration{100, 200, 400, 800, 1000, 1000} {
+		want *= time.Millisecond
+		for i := 0; i < 20; i++ {
+			if d := c.backoff(n+1, 0); d < want*8/10 || d > want*12/10 {
+				t.Fatalf("backoff(%d) = %s，期望 %s ±20%%", n+1, d, want)
+			}
+		}
+	}
+	if d := c.backoff(2, 30*time.Millisecond); d < 48*time.Millisecond || d > 72*time.Millisecond {
+		t.Errorf("服务端 retry 为 30ms 时 backoff(2) = %s，期望 60ms ±20%%", d)
+	}
+}
+
+// TestClientBackoffGrowth 连续失败时重连间隔按指数增长，达到 MaxRetries 后放弃
+func TestClientBackoffGrowth(t *testing.T) {
+	var mu sync.Mutex
+	var times []time.Time
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		times = append(times, time.Now())
+		mu.Unlock()
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer srv.Close()
+
+	base := 20 * time.Millisecond
+	s := (&Client{URL: srv.URL, InitialBackoff: base, MaxRetries: 4}).Subscribe(context.Background())
+	collect(t, s)
+	if s.Err() == nil || !strings.Contains(s.Err().Error(), "giving up after 4 retries") {
+		t.Errorf("Err() = %v，期望重试 4 次后放弃", s.Err())
+	}
+	if len(times) != 5 {
+		t.Fatalf("请求了 %d 次，期望 1 次连接加 4 次重连", len(times))
+	}
+	for i := 1; i < len(times); i++ {
+		least := base << (i - 1) * 8 / 10
+		if gap := times[i].Sub(times[i-1]); gap < least {
+			t.Errorf("第 %d 次重连间隔 %s，期望至少 %s", i, gap, least)
+		}
+	}
+}
+
+// TestClientCancel ctx 取消后立即结束退避等待，不再重连
+func TestClientCancel(t *testing.T) {
+	requested := make(chan struct{}, 10)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requested <- struct{}{}
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer srv.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	s := (&Client{URL: srv.URL, InitialBackoff: time.Hour}).Subscribe(ctx)
+	<-requested
+	start := time.Now()
+	cancel()
+	collect(t, s)
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("取消后 %s 才结束", elapsed)
+	}
+	if !errors.Is(s.Err(), context.Canceled) {
+		t.Errorf("Err() = %v，期望 context.Canceled", s.Err())
+	}
+	if n := len(requested); n != 0 {
+		t.Errorf("取消后又请求了 %d 次", n)
+	}
+}
+
+// TestClientCancelMidStream 读取中取消，关闭连接并结束订阅
+func TestClientCancelMidStream(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/event-stream")
+		for i := 0; ; i++ {
+			if _, err := fmt.Fprintf(w, "id: %d\ndata: %d\n\n", i, i); err != nil {
+				return
+			}
+			w.(http.Flusher).Flush()
+			select {
+			case <-r.Context().Done():
+				return
+			case <-time.After(5 * time.Millisecond):
+			}
+		}
+	}))
+	defer srv.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	s := (&Client{URL: srv.URL}).Subscribe(ctx)
+	for i := 0; i < 3; i++ {
+		<-s.Events()
+	}
+	cancel()
+	collect(t, s)
+	if !errors.Is(s.Err(), context.Canceled) {
+		t.Errorf("Err() = %v，期望 context.Canceled", s.Err())
+	}
+}
@@ -0,0 +1,152 @@
+// Package sseclient 是 Server-Sent Events 客户端：按 WHATWG 规范解析事件流，
+// 断线后携带 Last-Event-ID 自动重连，并以 channel 的形式交付事件。
+//
+// 只需要解析单个连接时使用 Reader；需要断线续传时使用 Client。
+package sseclient
+
+import (
+	"bufio"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Event 一个已分派的 SSE 事件
+type Event struct {
+	// 事件的 last event ID。规范中该值在事件之间保持，未携带 id 字段的事件沿用上一个值
+	ID string
+	// 事件类型，未携带 event 字段时为 "message"
+	Type string
+	// 多行 data 以 "\n" 连接
+	Data string
+}
+
+// Reader 从单个连接中逐个解析事件
+type Reader struct {
+	br *bufio.Reader
+
+	// 跨事件保持的状态。id 字段先写入 idBuf，遇到空行分派时才生效，
+	// 避免连接在事件中途断开时用未收全的事件 ID 续传而丢失该事件
+	idBuf  string
+	lastID string
+	retry  time.Duration
+
+	line []byte
+	// 上一行以 '\r' 结尾，下一个字节若为 '\n' 属于同一个换行
+	skipLF bool
+	// 尚未读取第一行
+	bom bool
+}
+
+func NewReader(r io.Reader) *Reader {
+	return &Reader{br: bufio.NewReader(r), bom: true}
+}
+
+// Next 读取下一个事件。流结束时返回 io.EOF，未以空行结束的残缺事件按规范丢弃
+func (r *Reader) Next() (Event, error) {
+	var typ string
+	var data strings.Builder
+	hasData := false
+	for {
+		line, err := r.readLine()
+		if err != nil {
+			return Event{}, err
+		}
+
+		// 空行：分派事件。没有 data 的事件只重置事件类型，不分派
+		if line == "" {
+			r.lastID = r.idBuf
+			if !hasData {
+				typ = ""
+				continue
+			}
+			if typ == "" {
+				typ = "message"
+			}
+			return Event{ID: r.lastID, Type: typ, Data: data.String()}, nil
+		}
+		// 注释行，常用作保活
+		if line[0] == ':' {
+			continue
+		}
+
+		field, value, found := strings.Cut(line, ":")
+		if found {
+			value = strings.TrimPrefix(value, " ")
+		}
+		switch field {
+		case "event":
+			typ = value
+		case "data":
+			if hasData {
+				data.WriteByte('\n')
+			}
+			data.WriteString(value)
+			hasData = true
+		case "id":
+			// 包含 NUL 的 id 按规范忽略
+			if !strings.ContainsRune(value, 0) {
+				r.idBuf = value
+			}
+		case "retry":
+			if ms, err := strconv.ParseUint(value, 10, 32); err == nil && isDigits(value) {
+				r.retry = time.Duration(ms) * time.Millisecond
+			}
+		}
+	}
+}
+
+// LastEventID 最近一个完整接收的事件所携带的 ID，可用于重连时的 Last-Event-ID
+func (r *Reader) LastEventID() string {
+	return r.lastID
+}
+
+// Retry 服务端通过 retry 字段建议的重连间隔，未设置时为 0
+func (r *Reader) Retry() time.Duration {
+	return r.retry
+}
+
+// readLine 读取一行，行尾可以是 "\r\n"、"\n" 或单独的 "\r"
+func (r *Reader) readLine() (string, error) {
+	r.line = r.line[:0]
+	for {
+		b, err := r.br.ReadByte()
+		if err != nil {
+			return "", err
+		}
+		if r.skipLF {
+			r.skipLF = false
+			if b == '\n' {
+				continue
+			}
+		}
+		switch b {
+		case '\n':
+			return r.text(), nil
+		case '\r':
+			// 不等待下一个字节，避免以 "\r" 结尾的事件被延迟分派
+			r.skipLF = true
+			return r.text(), nil
+		}
+		r.line = append(r.line, b)
+	}
+}
+
+// text 返回当前行，流开头的 BOM 按规范去掉
+func (r *Reader) text() string {
+	if r.bom {
+		r.bom = false
+		return strings.TrimPrefix(string(r.line), "\uFEFF")
+	}
+	return string(r.line)
+}
+
+func isDigits(s string) bool {
+	for i := 0; i < len(s); i++ {
+		if s[i] < '0' || s[i] > '9' {
+			return false
+		}
+	}
+	return s != ""
+}
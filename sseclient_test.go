@@ -0,0 +1,154 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"ai-answer-demo/sseclient"
+
+	"github.com/cloudwego/eino/schema"
+)
+
+// 用 sseclient 订阅真实的 SSEHandler。sseclient 不能导入 main 包，
+// 对接服务端的测试放在这里，解析与重连的细节见 sseclient/client_test.go
+
+// tickGenerator 每 interval 输出一个 token，输出形如 "t0"、"t1"
+type tickGenerator struct {
+	interval time.Duration
+}
+
+func (g *tickGenerator) Stream(ctx context.Context, messages []*schema.Message, opts *GenerateOptions) <-chan Chunk {
+	out := make(chan Chunk)
+	go func() {
+		defer close(out)
+		for i := 0; ; i++ {
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(g.interval):
+			}
+			select {
+			case <-ctx.Done():
+				return
+			case out <- Chunk{Content: fmt.Sprintf("t%d", i)}:
+			}
+		}
+	}()
+	return out
+}
+
+// newSSETestServer 以 tickGenerator 启动 /stream，返回服务与收到的 Last-Event-ID 请求头
+func newSSETestServer(t *testing.T, interval time.Duration) (*httptest.Server, func() []string) {
+	pipeline := NewStreamPipeline(&tickGenerator{interval: interval}, 16, nil, nil)
+	pipeline.StartWorkers(2)
+	t.Cleanup(pipeline.Stop)
+	registry := NewGenerationRegistry(pipeline, NewServerLifecycle(pipeline), time.Minute, false, DeliveryConfig{})
+
+	var mu sync.Mutex
+	var lastIDs []string
+	handler := &SSEHandler{registry: registry}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		lastIDs = append(lastIDs, r.Header.Get("Last-Event-ID"))
+		mu.Unlock()
+		handler.ServeHTTP(w, r)
+	}))
+	t.Cleanup(srv.Close)
+	return srv, func() []string {
+		mu.Lock()
+		defer mu.Unlock()
+		return append([]string(nil), lastIDs...)
+	}
+}
+
+// readTokens 读取订阅直到 done，返回 token 内容；afterToken 在每个 token 之后调用
+func readTokens(t *testing.T, s *sseclient.Stream, afterToken func(n int)) ([]string, sseclient.Event) {
+	t.Helper()
+	var tokens []string
+	timeout := time.After(10 * time.Second)
+	for {
+		select {
+		case ev, ok := <-s.Events():
+			if !ok {
+				t.Fatalf("订阅在 done 之前结束: %v", s.Err())
+			}
+			switch ev.Type {
+			case string(EventToken):
+				var data TokenData
+				if err := json.Unmarshal([]byte(ev.Data), &data); err != nil {
+					t.Fatalf("token 事件无法解析: %v", err)
+				}
+				if data.Index != len(tokens) {
+					t.Fatalf("token index = %d，期望 %d", data.Index, len(tokens))
+				}
+				tokens = append(tokens, data.Content)
+				if afterToken != nil {
+					afterToken(len(tokens))
+				}
+			case string(EventDone):
+				return tokens, ev
+			}
+		case <-timeout:
+			t.Fatalf("10s 内未收到 done，已收到 %d 个 token", len(tokens))
+		}
+	}
+}
+
+func TestSSEClientStream(t *testing.T) {
+	srv, lastIDs := newSSETestServer(t, time.Millisecond)
+
+	c := &sseclient.Client{URL: srv.URL + "/stream?prompt=hi&max_tokens=5", StopAfter: sseclient.IsDone}
+	s := c.Subscribe(context.Background())
+	tokens, done := readTokens(t, s, nil)
+	if fmt.Sprint(tokens) != "[t0 t1 t2 t3 t4]" {
+		t.Errorf("tokens = %v", tokens)
+	}
+	if _, seq, ok := parseEventID(done.ID); !ok || seq == 0 {
+		t.Errorf("done 事件 ID = %q，期望 <generationID>:<序号>", done.ID)
+	}
+	if done.Data != `{"v":1,"reason":"length"}` {
+		t.Errorf("done = %s", done.Data)
+	}
+	if got := lastIDs(); len(got) != 1 || got[0] != "" {
+		t.Errorf("Last-Event-ID = %q，期望只有一次不带 Last-Event-ID 的请求", got)
+	}
+}
+
+// TestSSEClientResume 服务端在流中途断开所有连接，客户端携带 Last-Event-ID 重连，
+// 从断点继续收到剩余的 token，既不重复也不遗漏
+func TestSSEClientResume(t *testing.T) {
+	srv, lastIDs := newSSETestServer(t, 10*time.Millisecond)
+
+	c := &sseclient.Client{
+		URL:            srv.URL + "/stream?prompt=hi&max_tokens=20",
+		InitialBackoff: 10 * time.Millisecond,
+		StopAfter:      sseclient.IsDone,
+	}
+	s := c.Subscribe(context.Background())
+	tokens, _ := readTokens(t, s, func(n int) {
+		if n == 5 {
+			srv.CloseClientConnections()
+		}
+	})
+	if len(tokens) != 20 {
+		t.Fatalf("收到 %d 个 token，期望 20", len(tokens))
+	}
+	for i, tok := range tokens {
+		if tok != fmt.Sprintf("t%d", i) {
+			t.Fatalf("tokens[%d] = %q", i, tok)
+		}
+	}
+	got := lastIDs()
+	if len(got) < 2 || got[0] != "" {
+		t.Fatalf("Last-Event-ID = %q，期望首次不携带、断开后重连携带", got)
+	}
+	if _, seq, ok := parseEventID(got[1]); !ok || seq < 4 {
+		t.Errorf("重连的 Last-Event-ID = %q，期望指向已收到的事件", got[1])
+	}
+}
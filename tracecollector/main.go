@@ -0,0 +1,237 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"ai-answer-demo/tracing"
+)
+
+// tracecollector：本地的 OTLP collector 替身，同时可离线查看导出的 trace。
+//
+// 接收：在 OTLP/HTTP 默认端口接收 JSON 编码的 trace，每个请求追加一行到文件，
+// 服务端配置 tracing.export 为 http://localhost:4318/v1/traces 即可：
+//
+//	go run ./tracecollector -out traces/traces.jsonl
+//
+// 查看：按 trace 输出 span 树，每个 span 显示相对 trace 开始的偏移与耗时，
+// 可直接看出时间花在排队、模型还是工具调用上。文件可以是本工具写入的，也可以是服务端直接导出的：
+//
+//	go run ./tracecollector -show traces/traces.jsonl -last 5
+
+// maxBodyBytes 单个导出请求的大小上限
+const maxBodyBytes = 16 << 20
+
+func main() {
+	addr := flag.String("addr", ":4318", "接收 OTLP/HTTP 的监听地址")
+	out := flag.String("out", "traces/traces.jsonl", "接收到的 trace 追加写入的文件")
+	show := flag.String("show", "", "输出指定 OTLP/JSON 文件中的 trace 后退出")
+	traceID := flag.String("trace", "", "与 -show 一起使用，只输出该 trace")
+	last := flag.Int("last", 10, "与 -show 一起使用，输出最近的 N 个 trace，0 表示全部")
+	flag.Parse()
+
+	if *show != "" {
+		if err := showTraces(os.Stdout, *show, *traceID, *last); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+
+	if err := os.MkdirAll(filepath.Dir(*out), 0755); err != nil {
+		log.Fatal(err)
+	}
+	f, err := os.OpenFile(*out, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer f.Close()
+	c := &collector{file: f}
+
+	mux := http.NewServeMux()
+	mux.Handle("POST /v1/traces", c)
+	log.Printf("接收 OTLP/HTTP trace: http://%s/v1/traces，写入 %s", *addr, *out)
+	log.Fatal(http.ListenAndServe(*addr, mux))
+}
+
+// collector 将收到的导出请求逐行写入文件
+type collector struct {
+	mu   sync.Mutex
+	file *os.File
+}
+
+func (c *collector) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if ct := r.Header.Get("Content-Type"); !strings.HasPrefix(ct, "application/json") {
+		http.Error(w, "只支持 OTLP/JSON（Content-Type: application/json）", http.StatusUnsupportedMediaType)
+		return
+	}
+	body, err := io.ReadAll(io.LimitReader(r.Body, maxBodyBytes+1))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if len(body) > maxBodyBytes {
+		http.Error(w, "请求过大", http.StatusRequestEntityTooLarge)
+		return
+	}
+	var req tracing.ExportRequest
+	if err := json.Unmarshal(body, &req); err != nil {
+		http.Error(w, "不是合法的 ExportTraceServiceRequest: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	// 压缩为一行，保证文件每行一个请求
+	var line bytes.Buffer
+	if err := json.Compact(&line, body); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	line.WriteByte('\n')
+
+	c.mu.Lock()
+	_, err = c.file.Write(line.Bytes())
+	c.mu.Unlock()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	spans := 0
+	for _, rs := range req.ResourceSpans {
+		for _, ss := range rs.ScopeSpans {
+			spans += len(ss.Spans)
+		}
+	}
+	log.Printf("收到 %d 个 span", spans)
+	w.Header().Set("Content-Type", "application/json")
+	w.Write([]byte("{}"))
+}
+
+// span 查看时使用的 span
+type span struct {
+	tracing.SpanData
+	start, end time.Time
+	children   []*span
+}
+
+func parseNano(s string) time.Time {
+	n, _ := strconv.ParseInt(s, 10, 64)
+	return time.Unix(0, n)
+}
+
+// showTraces 读取 JSONL 文件，按 trace 分组输出 span 树，trace 按开始时间排序
+func showTraces(w io.Writer, path, traceID string, last int) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	traces := map[string][]*span{}
+	sc := bufio.NewScanner(f)
+	sc.Buffer(nil, maxBodyBytes)
+	for lineNo := 1; sc.Scan(); lineNo++ {
+		if len(bytes.TrimSpace(sc.Bytes())) == 0 {
+			continue
+		}
+		var req tracing.ExportRequest
+		if err := json.Unmarshal(sc.Bytes(), &req); err != nil {
+			return fmt.Errorf("%s:%d: %w", path, lineNo, err)
+		}
+		for _, rs := range req.ResourceSpans {
+			for _, ss := range rs.ScopeSpans {
+				for _, d := range ss.Spans {
+					if traceID != "" && d.TraceID != traceID {
+						continue
+					}
+					s := &span{SpanData: d, start: parseNano(d.StartTimeUnixNano), end: parseNano(d.EndTimeUnixNano)}
+					traces[d.TraceID] = append(traces[d.TraceID], s)
+				}
+			}
+		}
+	}
+	if err := sc.Err(); err != nil {
+		return err
+	}
+
+	type trace struct {
+		id    string
+		start time.Time
+		roots []*span
+	}
+	var list []*trace
+	for id, spans := range traces {
+		t := &trace{id: id, start: spans[0].start}
+		byID := map[string]*span{}
+		for _, s := range spans {
+			byID[s.SpanID] = s
+			if s.start.Before(t.start) {
+				t.start = s.start
+			}
+		}
+		// 父 span 不在文件中（如来自上游服务或尚未导出）时作为根显示
+		for _, s := range spans {
+			if p, ok := byID[s.ParentSpanID]; ok && s.ParentSpanID != "" {
+				p.children = append(p.children, s)
+			} else {
+				t.roots = append(t.roots, s)
+			}
+		}
+		for _, s := range spans {
+			sortSpans(s.children)
+		}
+		sortSpans(t.roots)
+		list = append(list, t)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].start.Before(list[j].start) })
+	if last > 0 && len(list) > last {
+		list = list[len(list)-last:]
+	}
+
+	for _, t := range list {
+		fmt.Fprintf(w, "trace %s  %s\n", t.id, t.start.Format("2006-01-02 15:04:05.000"))
+		for _, s := range t.roots {
+			printSpan(w, s, t.start, 1)
+		}
+		fmt.Fprintln(w)
+	}
+	return nil
+}
+
+func sortSpans(spans []*span) {
+	sort.Slice(spans, func(i, j int) bool { return spans[i].start.Before(spans[j].start) })
+}
+
+// printSpan 输出一行：偏移、耗时、名称、状态与属性，子 span 缩进
+func printSpan(w io.Writer, s *span, origin time.Time, depth int) {
+	var b strings.Builder
+	fmt.Fprintf(&b, "%10s %10s  %s%s", fmtMs(s.start.Sub(origin)), fmtMs(s.end.Sub(s.start)), strings.Repeat("  ", depth-1), s.Name)
+	if s.Status.Code == tracing.StatusError {
+		fmt.Fprintf(&b, "  [ERROR %s]", s.Status.Message)
+	}
+	for _, kv := range s.Attributes {
+		fmt.Fprintf(&b, " %s=%s", kv.Key, kv.Value.Text())
+	}
+	fmt.Fprintln(w, b.String())
+	for _, e := range s.Events {
+		fmt.Fprintf(w, "%10s %10s  %s· %s\n", fmtMs(parseNano(e.TimeUnixNano).Sub(origin)), "", strings.Repeat("  ", depth), e.Name)
+	}
+	for _, c := range s.children {
+		printSpan(w, c, origin, depth+1)
+	}
+}
+
+func fmtMs(d time.Duration) string {
+	return strconv.FormatFloat(float64(d.Microseconds())/1000, 'f', 1, 64) + "ms"
+}
@@ -0,0 +1,240 @@
+package tracing
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// 以下类型对应 OTLP/JSON 的 ExportTraceServiceRequest（opentelemetry-proto 的 JSON 映射）：
+// trace 与 span ID 为十六进制字符串，64 位整数编码为字符串
+
+type ExportRequest struct {
+	ResourceSpans []ResourceSpans `json:"resourceSpans"`
+}
+
+type ResourceSpans struct {
+	Resource   Resource     `json:"resource"`
+	ScopeSpans []ScopeSpans `json:"scopeSpans"`
+}
+
+type Resource struct {
+	Attributes []KeyValue `json:"attributes"`
+}
+
+type ScopeSpans struct {
+	Scope Scope      `json:"scope"`
+	Spans []SpanData `json:"spans"`
+}
+
+type Scope struct {
+	Name string `json:"name"`
+}
+
+type SpanData struct {
+	TraceID           string      `json:"traceId"`
+	SpanID            string      `json:"spanId"`
+	ParentSpanID      string      `json:"parentSpanId,omitempty"`
+	Name              string      `json:"name"`
+	Kind              SpanKind    `json:"kind"`
+	StartTimeUnixNano string      `json:"startTimeUnixNano"`
+	EndTimeUnixNano   string      `json:"endTimeUnixNano"`
+	Attributes        []KeyValue  `json:"attributes,omitempty"`
+	Events            []EventData `json:"events,omitempty"`
+	Status            Status      `json:"status"`
+}
+
+type EventData struct {
+	TimeUnixNano string     `json:"timeUnixNano"`
+	Name         string     `json:"name"`
+	Attributes   []KeyValue `json:"attributes,omitempty"`
+}
+
+type Status struct {
+	Code    StatusCode `json:"code,omitempty"`
+	Message string     `json:"message,omitempty"`
+}
+
+type KeyValue struct {
+	Key   string   `json:"key"`
+	Value AnyValue `json:"value"`
+}
+
+type AnyValue struct {
+	StringValue *string  `json:"stringValue,omitempty"`
+	IntValue    *string  `json:"intValue,omitempty"`
+	DoubleValue *float64 `json:"doubleValue,omitempty"`
+	BoolValue   *bool    `json:"boolValue,omitempty"`
+}
+
+// Text 返回属性值的文本形式
+func (v AnyValue) Text() string {
+	switch {
+	case v.StringValue != nil:
+		return *v.StringValue
+	case v.IntValue != nil:
+		return *v.IntValue
+	case v.DoubleValue != nil:
+		return strconv.FormatFloat(*v.DoubleValue, 'g', -1, 64)
+	case v.BoolValue != nil:
+		return strconv.FormatBool(*v.BoolValue)
+	}
+	return ""
+}
+
+func unixNano(t time.Time) string {
+	return strconv.FormatInt(t.UnixNano(), 10)
+}
+
+// encodeAttrs 同名属性只保留最后一次设置的值，顺序按首次出现
+func encodeAttrs(attrs []Attr) []KeyValue {
+	index := make(map[string]int, len(attrs))
+	var kvs []KeyValue
+	for _, a := range attrs {
+		var v AnyValue
+		switch x := a.Value.(type) {
+		case string:
+			v.StringValue = &x
+		case int64:
+			s := strconv.FormatInt(x, 10)
+			v.IntValue = &s
+		case float64:
+			v.DoubleValue = &x
+		case bool:
+			v.BoolValue = &x
+		default:
+			s := fmt.Sprint(x)
+			v.StringValue = &s
+		}
+		if i, ok := index[a.Key]; ok {
+			kvs[i].Value = v
+			continue
+		}
+		index[a.Key] = len(kvs)
+		kvs = append(kvs, KeyValue{Key: a.Key, Value: v})
+	}
+	return kvs
+}
+
+func (s *Span) data() SpanData {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	d := SpanData{
+		TraceID:           s.sc.TraceID.String(),
+		SpanID:            s.sc.SpanID.String(),
+		Name:              s.name,
+		Kind:              s.kind,
+		StartTimeUnixNano: unixNano(s.start),
+		EndTimeUnixNano:   unixNano(s.end),
+		Attributes:        encodeAttrs(s.attrs),
+		Status:            Status{Code: s.status, Message: s.statusMessage},
+	}
+	if s.parent.IsValid() {
+		d.ParentSpanID = s.parent.String()
+	}
+	for _, e := range s.events {
+		d.Events = append(d.Events, EventData{TimeUnixNano: unixNano(e.Time), Name: e.Name, Attributes: encodeAttrs(e.Attrs)})
+	}
+	return d
+}
+
+// export 编码一批 span 并交给 exporter，失败只记录日志
+func (t *Tracer) export(batch []*Span) {
+	spans := make([]SpanData, len(batch))
+	for i, s := range batch {
+		spans[i] = s.data()
+	}
+	req := ExportRequest{ResourceSpans: []ResourceSpans{{
+		Resource:   Resource{Attributes: encodeAttrs([]Attr{String("service.name", t.service)})},
+		ScopeSpans: []ScopeSpans{{Scope: Scope{Name: "ai-answer-demo/tracing"}, Spans: spans}},
+	}}}
+	body, err := json.Marshal(req)
+	if err != nil {
+		log.Printf("编码 trace 失败: %v", err)
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	if err := t.exporter.Export(ctx, body); err != nil {
+		log.Printf("导出 trace 失败（%d 个 span）: %v", len(batch), err)
+	}
+}
+
+// Exporter 接收编码好的 OTLP/JSON ExportTraceServiceRequest
+type Exporter interface {
+	Export(ctx context.Context, body []byte) error
+	Close() error
+}
+
+// NewExporter 按目标创建 exporter：http:// 或 https:// 开头时发送到 OTLP/HTTP collector
+// （如 http://localhost:4318/v1/traces），否则视为文件路径
+func NewExporter(target string) (Exporter, error) {
+	if strings.HasPrefix(target, "http://") || strings.HasPrefix(target, "https://") {
+		return &HTTPExporter{URL: target, Client: &http.Client{}}, nil
+	}
+	return OpenFileExporter(target)
+}
+
+// FileExporter 每批 span 追加一行 JSON，格式与 OpenTelemetry Collector 的 file exporter 相同
+type FileExporter struct {
+	mu   sync.Mutex
+	file *os.File
+}
+
+func OpenFileExporter(path string) (*FileExporter, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return nil, err
+	}
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		return nil, err
+	}
+	return &FileExporter{file: f}, nil
+}
+
+func (e *FileExporter) Export(_ context.Context, body []byte) error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	_, err := e.file.Write(append(body, '\n'))
+	return err
+}
+
+func (e *FileExporter) Close() error {
+	return e.file.Close()
+}
+
+// HTTPExporter 以 OTLP/HTTP JSON 编码 POST 到 collector
+type HTTPExporter struct {
+	URL    string
+	Client *http.Client
+}
+
+func (e *HTTPExporter) Export(ctx context.Context, body []byte) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, e.URL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := e.Client.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("collector 返回 %s", resp.Status)
+	}
+	return nil
+}
+
+func (e *HTTPExporter) Close() error {
+	return nil
+}
@@ -0,0 +1,266 @@
+package tracing
+
+import (
+	"context"
+	"errors"
+	"math/rand/v2"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// SpanKind 与 OTLP 的 span.kind 取值一致
+type SpanKind int
+
+const (
+	KindInternal SpanKind = 1
+	KindServer   SpanKind = 2
+	KindClient   SpanKind = 3
+)
+
+// StatusCode 与 OTLP 的 status.code 取值一致
+type StatusCode int
+
+const (
+	StatusUnset StatusCode = 0
+	StatusOK    StatusCode = 1
+	StatusError StatusCode = 2
+)
+
+// Attr span 或事件的一个属性
+type Attr struct {
+	Key   string
+	Value any // string、int64、float64 或 bool
+}
+
+func String(key, v string) Attr          { return Attr{key, v} }
+func Int(key string, v int) Attr         { return Attr{key, int64(v)} }
+func Int64(key string, v int64) Attr     { return Attr{key, v} }
+func Float64(key string, v float64) Attr { return Attr{key, v} }
+func Bool(key string, v bool) Attr       { return Attr{key, v} }
+
+// Event span 内的时间点事件
+type Event struct {
+	Name  string
+	Time  time.Time
+	Attrs []Attr
+}
+
+// Span 一段计时的操作。nil *Span 的方法都是空操作；
+// 未被采样的 span 只用于向下游传递 trace，不会导出
+type Span struct {
+	tracer    *Tracer
+	sc        SpanContext
+	parent    SpanID
+	name      string
+	kind      SpanKind
+	start     time.Time
+	recording bool
+
+	mu            sync.Mutex
+	end           time.Time
+	ended         bool
+	attrs         []Attr
+	events        []Event
+	status        StatusCode
+	statusMessage string
+}
+
+// SpanContext 返回 span 的标识，nil span 返回零值
+func (s *Span) SpanContext() SpanContext {
+	if s == nil {
+		return SpanContext{}
+	}
+	return s.sc
+}
+
+// SetAttrs 设置属性，同名属性以最后一次为准
+func (s *Span) SetAttrs(attrs ...Attr) {
+	if s == nil || !s.recording {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.attrs = append(s.attrs, attrs...)
+}
+
+// AddEvent 记录一个时间点事件
+func (s *Span) AddEvent(name string, attrs ...Attr) {
+	if s == nil || !s.recording {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.events = append(s.events, Event{Name: name, Time: time.Now(), Attrs: attrs})
+}
+
+// RecordError 记录 exception 事件并将状态置为错误；err 为 nil 或 context.Canceled 时不记录，
+// 客户端主动取消不视为失败
+func (s *Span) RecordError(err error) {
+	if s == nil || !s.recording || err == nil || errors.Is(err, context.Canceled) {
+		return
+	}
+	s.AddEvent("exception", String("exception.message", err.Error()))
+	s.SetStatus(StatusError, err.Error())
+}
+
+// SetStatus 设置 span 状态
+func (s *Span) SetStatus(code StatusCode, message string) {
+	if s == nil || !s.recording {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.status, s.statusMessage = code, message
+}
+
+// End 结束 span 并交给导出队列，重复调用只有第一次生效
+func (s *Span) End() {
+	if s == nil || !s.recording {
+		return
+	}
+	s.mu.Lock()
+	if s.ended {
+		s.mu.Unlock()
+		return
+	}
+	s.ended = true
+	s.end = time.Now()
+	s.mu.Unlock()
+	s.tracer.enqueue(s)
+}
+
+// Tracer 创建 span 并按批导出
+type Tracer struct {
+	service     string
+	sampleRatio float64
+	exporter    Exporter
+
+	queue   chan *Span
+	stop    chan struct{}
+	done    chan struct{}
+	dropped atomic.Uint64
+}
+
+const (
+	queueSize     = 2048
+	maxBatch      = 512
+	flushInterval = 5 * time.Second
+)
+
+// NewTracer 创建 tracer 并启动导出循环。sampleRatio 为没有上游 trace 时新建 trace 的采样率，
+// 接续上游的 trace 时沿用上游的采样决定
+func NewTracer(service string, sampleRatio float64, exporter Exporter) *Tracer {
+	t := &Tracer{
+		service:     service,
+		sampleRatio: sampleRatio,
+		exporter:    exporter,
+		queue:       make(chan *Span, queueSize),
+		stop:        make(chan struct{}),
+		done:        make(chan struct{}),
+	}
+	go t.run()
+	return t
+}
+
+// Start 创建以 ctx 中的 span 为父的新 span，返回携带它的 context
+func (t *Tracer) Start(ctx context.Context, kind SpanKind, name string, attrs ...Attr) (context.Context, *Span) {
+	if t == nil {
+		return ctx, nil
+	}
+	parent := parentFromContext(ctx)
+	span := &Span{
+		tracer: t,
+		name:   name,
+		kind:   kind,
+		start:  time.Now(),
+		attrs:  attrs,
+	}
+	if parent.IsValid() {
+		span.sc = SpanContext{TraceID: parent.TraceID, Sampled: parent.Sampled}
+		span.parent = parent.SpanID
+	} else {
+		span.sc = SpanContext{TraceID: newTraceID(), Sampled: rand.Float64() < t.sampleRatio}
+	}
+	span.sc.SpanID = newSpanID()
+	span.recording = span.sc.Sampled
+	return ContextWithSpan(ctx, span), span
+}
+
+// Dropped 返回导出队列已满而丢弃的 span 数
+func (t *Tracer) Dropped() uint64 {
+	return t.dropped.Load()
+}
+
+// enqueue 不阻塞调用方，队列满时丢弃
+func (t *Tracer) enqueue(s *Span) {
+	select {
+	case t.queue <- s:
+	default:
+		t.dropped.Add(1)
+	}
+}
+
+// run 攒够 maxBatch 个或每隔 flushInterval 导出一批
+func (t *Tracer) run() {
+	defer close(t.done)
+	ticker := time.NewTicker(flushInterval)
+	defer ticker.Stop()
+	var batch []*Span
+	flush := func() {
+		if len(batch) == 0 {
+			return
+		}
+		t.export(batch)
+		batch = nil
+	}
+	for {
+		select {
+		case s := <-t.queue:
+			batch = append(batch, s)
+			if len(batch) >= maxBatch {
+				flush()
+			}
+		case <-ticker.C:
+			flush()
+		case <-t.stop:
+			for {
+				select {
+				case s := <-t.queue:
+					batch = append(batch, s)
+				default:
+					flush()
+					return
+				}
+			}
+		}
+	}
+}
+
+// Shutdown 导出剩余的 span 并关闭 exporter，ctx 到期时放弃等待
+func (t *Tracer) Shutdown(ctx context.Context) error {
+	close(t.stop)
+	select {
+	case <-t.done:
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+	return t.exporter.Close()
+}
+
+var defaultTracer atomic.Pointer[Tracer]
+
+// SetDefault 设置包级函数 Start 使用的 tracer，传 nil 关闭追踪
+func SetDefault(t *Tracer) {
+	defaultTracer.Store(t)
+}
+
+// Start 使用默认 tracer 创建 KindInternal 的 span，未设置时返回 nil span
+func Start(ctx context.Context, name string, attrs ...Attr) (context.Context, *Span) {
+	return defaultTracer.Load().Start(ctx, KindInternal, name, attrs...)
+}
+
+// StartKind 与 Start 相同，但指定 span 类型
+func StartKind(ctx context.Context, kind SpanKind, name string, attrs ...Attr) (context.Context, *Span) {
+	return defaultTracer.Load().Start(ctx, kind, name, attrs...)
+}
@@ -0,0 +1,319 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"ai-answer-demo/tracing"
+
+	"github.com/cloudwego/eino/schema"
+)
+
+// WebSocket 客户端消息类型
+const (
+	WSStart    = "start"     // 开始一次新的生成
+	WSCancel   = "cancel"    // 按 generation_id 取消生成
+	WSFollowUp = "follow_up" // 在某次生成的对话之后追问，上一轮结束后开始
+)
+
+// WebSocket 服务端消息类型
+const (
+	WSStarted = "started" // 生成已提交，携带 generation_id
+	WSEvent   = "event"   // 与 SSE 相同的事件，data 为同一份 payload
+	WSError   = "error"   // 客户端消息无法处理
+)
+
+// WebSocket 错误码
+const (
+	WSErrInvalidMessage = "invalid_message"
+	WSErrInvalidOption  = "invalid_option"
+	WSErrNotFound       = "generation_not_found"
+	WSErrRejected       = "rejected"
+	WSErrQuotaExceeded  = AuthQuotaExceeded
+)
+
+// WSClientMessage 客户端发送的消息
+type WSClientMessage struct {
+	Type string `json:"type"`
+	// 客户端自定义的关联 ID，在 started 与 error 消息中原样返回
+	Ref          string          `json:"ref,omitempty"`
+	GenerationID string          `json:"generation_id,omitempty"`
+	Prompt       string          `json:"prompt,omitempty"`
+	Options      GenerateOptions `json:"options"`
+}
+
+// WSServerMessage 服务端发送的消息
+type WSServerMessage struct {
+	Type         string          `json:"type"`
+	Ref          string          `json:"ref,omitempty"`
+	GenerationID string          `json:"generation_id,omitempty"`
+	ID           string          `json:"id,omitempty"` // 事件 ID，格式与 SSE 的 id 字段相同
+	Event        EventType       `json:"event,omitempty"`
+	Data         json.RawMessage `json:"data,omitempty"`
+	Code         string          `json:"code,omitempty"`
+	Message      string          `json:"message,omitempty"`
+}
+
+// WSHandler 处理 /ws：与 SSE 共用 GenerationRegistry 与事件格式，
+// 单个连接上可以并发进行多次生成，并通过消息取消或追问
+type WSHandler struct {
+	registry *GenerationRegistry
+}
+
+// wsSession 一个 WebSocket 连接上的状态
+type wsSession struct {
+	conn     *wsConn
+	registry *GenerationRegistry
+	ctx      context.Context // 连接关闭时取消
+	fixture  string          // 握手请求的 X-Fixture，作用于该连接上的所有生成
+	adm      Admission       // 握手请求确定的优先级与租户，作用于该连接上的所有生成
+
+	mu      sync.Mutex
+	streams map[string]chan struct{} // 正在转发的生成，关闭 channel 表示客户端取消
+	started map[string]bool          // 本连接上开始过的生成，只能对它们追问
+	wg      sync.WaitGroup
+}
+
+func (h *WSHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	adm, err := admissionFromRequest(r)
+	if err != nil {
+		writeOptionError(w, err)
+		return
+	}
+	conn, err := upgradeWebSocket(w, r)
+	if err != nil {
+		debugf("WebSocket 握手失败: %v", err)
+		return
+	}
+	conn.writeTimeout = h.registry.delivery.writeTimeout()
+
+	// 握手完成后 r.Context() 不再随连接关闭而取消，由读循环负责；
+	// 握手请求携带 traceparent 时，该连接上的生成都接续同一个 trace
+	ctx, cancel := context.WithCancel(tracing.Extract(context.WithoutCancel(r.Context()), r.Header))
+	s := &wsSession{conn: conn, registry: h.registry, ctx: ctx, fixture: r.Header.Get(FixtureHeader), adm: adm,
+		streams: make(map[string]chan struct{}), started: make(map[string]bool)}
+	defer func() {
+		cancel()
+		s.wg.Wait()
+		conn.Close(nil)
+	}()
+	go s.watchShutdown()
+
+	for {
+		data, err := conn.ReadMessage()
+		if err != nil {
+			if errors.Is(err, errWSMessageTooLarge) {
+				conn.Close(closePayload(1009, "message too large"))
+			}
+			return
+		}
+		var msg WSClientMessage
+		if err := json.Unmarshal(data, &msg); err != nil {
+			s.sendError("", "", WSErrInvalidMessage, "消息不是合法的 JSON: "+err.Error())
+			continue
+		}
+		s.handle(&msg)
+	}
+}
+
+func (s *wsSession) handle(msg *WSClientMessage) {
+	switch msg.Type {
+	case WSStart:
+		if strings.TrimSpace(msg.Prompt) == "" {
+			s.sendError(msg.Ref, "", WSErrInvalidMessage, "prompt 不能为空")
+			return
+		}
+		s.start(msg.Ref, promptMessages(msg.Prompt), &msg.Options)
+
+	case WSCancel:
+		if !s.cancel(msg.GenerationID) {
+			s.sendError(msg.Ref, msg.GenerationID, WSErrNotFound, "该连接上没有进行中的此生成")
+		}
+
+	case WSFollowUp:
+		// 其他连接的生成同样视为不存在，避免读取或续写别人的对话
+		s.mu.Lock()
+		owned := s.started[msg.GenerationID]
+		s.mu.Unlock()
+		prev, ok := s.registry.Get(msg.GenerationID, s.adm.Tenant)
+		if !ok || !owned {
+			s.sendError(msg.Ref, msg.GenerationID, WSErrNotFound, "生成不存在或已过期")
+			return
+		}
+		if strings.TrimSpace(msg.Prompt) == "" {
+			s.sendError(msg.Ref, msg.GenerationID, WSErrInvalidMessage, "prompt 不能为空")
+			return
+		}
+		// 上一轮结束后才知道完整回复，等待期间不阻塞读循环
+		s.wg.Add(1)
+		go func() {
+			defer s.wg.Done()
+			select {
+			case <-prev.Done():
+			case <-s.ctx.Done():
+				return
+			}
+			content, _ := prev.Result()
+			messages := append(append([]*schema.Message(nil), prev.Messages()...),
+				schema.AssistantMessage(content, nil), schema.UserMessage(msg.Prompt))
+			s.start(msg.Ref, messages, &msg.Options)
+		}()
+
+	default:
+		s.sendError(msg.Ref, "", WSErrInvalidMessage, "未知的消息类型: "+msg.Type)
+	}
+}
+
+// start 提交一次生成并开始转发其事件
+func (s *wsSession) start(ref string, messages []*schema.Message, opts *GenerateOptions) {
+	if s.ctx.Err() != nil {
+		return
+	}
+	opts.Fixture = s.fixture
+	if err := s.registry.pipeline.ValidateOptions(opts); err != nil {
+		s.sendError(ref, "", WSErrInvalidOption, err.Error())
+		return
+	}
+	// 认证在握手时完成，长连接上的每次生成仍需检查当日额度
+	if key, usage := APIKeyFromContext(s.ctx), usageFromContext(s.ctx); key != nil && key.DailyTokens > 0 && usage.remaining(key.DailyTokens, time.Now()) <= 0 {
+		s.sendError(ref, "", WSErrQuotaExceeded, "今日 token 额度已用尽")
+		return
+	}
+
+	applyQuota(s.ctx, opts)
+	gen, err := s.registry.Start(s.ctx, messages, opts, s.adm)
+	if err != nil {
+		metrics.RequestsRejected.Inc()
+		s.sendError(ref, "", WSErrRejected, submitErrorMessage(err))
+		return
+	}
+
+	stop := make(chan struct{})
+	s.mu.Lock()
+	s.started[gen.ID] = true
+	if _, dup := s.streams[gen.ID]; dup {
+		// 去重命中本连接上已在转发的生成
+		s.mu.Unlock()
+		s.send(&WSServerMessage{Type: WSStarted, Ref: ref, GenerationID: gen.ID})
+		return
+	}
+	end, ok := s.registry.lifecycle.Begin()
+	if !ok {
+		s.mu.Unlock()
+		s.registry.Cancel(gen)
+		metrics.RequestsRejected.Inc()
+		s.sendError(ref, "", WSErrRejected, submitErrorMessage(ErrShuttingDown))
+		return
+	}
+	s.streams[gen.ID] = stop
+	s.mu.Unlock()
+
+	s.registry.Attach(gen)
+	s.send(&WSServerMessage{Type: WSStarted, Ref: ref, GenerationID: gen.ID})
+	s.wg.Add(1)
+	go s.forward(gen, stop, end)
+}
+
+// cancel 停止转发并在没有其他订阅者时取消生成
+func (s *wsSession) cancel(id string) bool {
+	s.mu.Lock()
+	stop, ok := s.streams[id]
+	if ok {
+		delete(s.streams, id)
+	}
+	s.mu.Unlock()
+	if ok {
+		close(stop)
+	}
+	return ok
+}
+
+// forward 将生成的事件转发到连接，生成结束、客户端取消或连接关闭时返回，返回前调用 end
+func (s *wsSession) forward(gen *Generation, stop chan struct{}, end func()) {
+	defer s.wg.Done()
+	defer end()
+
+	tracker := metrics.TrackStream(s.ctx)
+	cancelled := true
+	defer func() {
+		tracker.Finish(cancelled)
+		s.registry.Detach(gen)
+		// WebSocket 没有断线续传，客户端不再接收时立即取消，而不是等待宽限期
+		if cancelled {
+			s.registry.Cancel(gen)
+		}
+	}()
+
+	sendEvent := func(id string, ev StreamEvent) {
+		s.send(&WSServerMessage{Type: WSEvent, GenerationID: gen.ID, ID: id, Event: ev.Type, Data: ev.Data})
+	}
+	if !waitQueued(s.ctx, s.registry, gen, func(ev StreamEvent) { sendEvent("", ev) }) {
+		return
+	}
+
+	seq := 0
+	for {
+		events, done, changed := gen.Next(seq)
+		for _, ev := range events {
+			sendEvent(formatEventID(gen.ID, seq), ev)
+			if ev.Type == EventToken {
+				tracker.Token(ev.tokens)
+			}
+			seq++
+		}
+		if done {
+			cancelled = false
+			s.mu.Lock()
+			if s.streams[gen.ID] == stop {
+				delete(s.streams, gen.ID)
+			}
+			s.mu.Unlock()
+			return
+		}
+
+		select {
+		case <-s.ctx.Done():
+			return
+		case <-stop:
+			// 客户端取消：生成可能仍被其他订阅者共享，直接以 cancelled 结束本连接上的这条流
+			sendEvent("", NewStreamEvent(EventDone, &DoneData{V: EventProtocolVersion, Reason: DoneCancelled}))
+			return
+		case <-changed:
+		}
+	}
+}
+
+// watchShutdown 服务开始关闭时通知客户端，进行中的生成会在排空期限内继续
+func (s *wsSession) watchShutdown() {
+	select {
+	case <-s.ctx.Done():
+	case <-s.registry.lifecycle.Draining():
+		ev := NewStreamEvent(EventShutdown, &ShutdownData{
+			V:             EventProtocolVersion,
+			DrainDeadline: s.registry.lifecycle.DrainDeadline().Format(time.RFC3339),
+		})
+		s.send(&WSServerMessage{Type: WSEvent, Event: ev.Type, Data: ev.Data})
+	}
+}
+
+func (s *wsSession) send(msg *WSServerMessage) {
+	data, err := json.Marshal(msg)
+	if err != nil {
+		errorf("编码 WebSocket 消息失败: %v", err)
+		return
+	}
+	// 写失败（含写出超时）时关闭连接，读循环随后会退出并清理
+	if err := s.conn.WriteText(data); err != nil {
+		countWriteTimeout(err)
+		s.conn.Close(nil)
+	}
+}
+
+func (s *wsSession) sendError(ref, genID, code, message string) {
+	s.send(&WSServerMessage{Type: WSError, Ref: ref, GenerationID: genID, Code: code, Message: message})
+}